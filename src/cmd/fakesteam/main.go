@@ -0,0 +1,63 @@
+// Command fakesteam runs a stand-in for the Steam web API so that crawls can
+// be run offline. Point the crawler at it with -steamAPIHost localhost:8090
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/steamFriendsGraphing/fakesteam"
+)
+
+func main() {
+	port := flag.String("port", "8090", "Port the fake Steam web API is served on")
+	graphFile := flag.String("graph", "", "JSON fixture of the friend network to serve. A random one is generated if not given")
+	users := flag.Int("users", 1000, "How many users a generated friend network has")
+	friends := flag.Int("friends", 20, "How many friends each user of a generated friend network has on average")
+	seed := flag.Int64("seed", 1, "Seed used to generate the friend network and to pick which requests fail")
+	save := flag.String("save", "", "Save the friend network being served to this file as a fixture")
+	keys := flag.String("keys", "", "Comma separated API keys to accept. Any API key is accepted if not given")
+
+	// Fault injection flags
+	latency := flag.Duration("latency", 0, "Latency added to every response")
+	rateLimitRate := flag.Float64("rateLimitRate", 0, "Share of requests answered with 429 Too Many Requests")
+	serverErrorRate := flag.Float64("serverErrorRate", 0, "Share of requests answered with 503 Service Unavailable")
+	privateRate := flag.Float64("privateRate", 0, "Share of users whose profiles are private")
+	flag.Parse()
+
+	graph := fakesteam.RandomGraph(*users, *friends, *seed)
+	if *graphFile != "" {
+		var err error
+		graph, err = fakesteam.LoadGraph(*graphFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *save != "" {
+		if err := graph.Save(*save); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	validKeys := []string{}
+	if *keys != "" {
+		validKeys = strings.Split(*keys, ",")
+	}
+	server := fakesteam.NewServer(graph, fakesteam.Faults{
+		Latency:         *latency,
+		RateLimitRate:   *rateLimitRate,
+		ServerErrorRate: *serverErrorRate,
+		PrivateRate:     *privateRate,
+		Seed:            *seed,
+	}, validKeys...)
+
+	steamIDs := graph.SteamIDs()
+	fmt.Printf("Serving %d users with %d friendships on :%s\n", graph.Len(), graph.Edges(), *port)
+	if len(steamIDs) > 0 {
+		fmt.Printf("Try crawling %s\n", steamIDs[0])
+	}
+	log.Fatal(http.ListenAndServe(":"+*port, server))
+}
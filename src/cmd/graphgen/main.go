@@ -0,0 +1,65 @@
+// Command graphgen generates synthetic friend networks for load and regression
+// testing, either as a cache folder the crawler can read or as a fixture for fakesteam
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/steamFriendsGraphing/fakesteam"
+	"github.com/steamFriendsGraphing/util"
+)
+
+func main() {
+	model := flag.String("model", string(fakesteam.BarabasiAlbert), "Model used to generate the friend network: uniform, ba (Barabási–Albert), ws (Watts–Strogatz) or community")
+	users := flag.Int("users", 10000, "How many users to generate")
+	friends := flag.Int("friends", 20, "How many friends each user has on average")
+	rewire := flag.Float64("rewire", 0.1, "Share of friendships rewired at random by the ws model")
+	communityCount := flag.Int("communities", 10, "How many communities the community model splits users into")
+	mixing := flag.Float64("mixing", 0.1, "Share of friendships the community model makes between communities")
+	privateRate := flag.Float64("privateRate", 0, "Share of users whose profiles are private")
+	nameCollisionRate := flag.Float64("nameCollisionRate", 0, "Share of users who share their personaname with another user")
+	seed := flag.Int64("seed", 1, "Seed the friend network is generated from")
+
+	cacheFolder := flag.String("cache", "", "Folder to write the friend list of every public user to, as the crawler caches them")
+	fixture := flag.String("fixture", "", "File to save the friend network to as a fixture for fakesteam")
+	flag.Parse()
+
+	if *cacheFolder == "" && *fixture == "" {
+		log.Fatal("give a -cache folder or a -fixture file to write the friend network to")
+	}
+
+	start := time.Now()
+	graph, err := fakesteam.Generate(fakesteam.GeneratorConfig{
+		Model:             fakesteam.Model(*model),
+		Users:             *users,
+		FriendsPerUser:    *friends,
+		Rewire:            *rewire,
+		CommunityCount:    *communityCount,
+		Mixing:            *mixing,
+		PrivateRate:       *privateRate,
+		NameCollisionRate: *nameCollisionRate,
+		Seed:              *seed,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Generated %d users with %d friendships in %s\n", graph.Len(), graph.Edges(), time.Since(start).Round(time.Millisecond))
+
+	if *cacheFolder != "" {
+		err = graph.WriteCache(util.Controller{}, *cacheFolder, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Wrote the friend lists of public users to %s\n", *cacheFolder)
+	}
+	if *fixture != "" {
+		err = graph.Save(*fixture)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Saved the friend network to %s\n", *fixture)
+	}
+}
@@ -9,6 +9,17 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
+)
+
+const (
+	DefaultSteamAPIScheme = "http"
+	DefaultSteamAPIHost   = "api.steampowered.com"
+	// DefaultSteamAPITimeout is how long a single request to the Steam web API can take
+	DefaultSteamAPITimeout = 10 * time.Second
+	// DefaultSteamAPIRetries is how many more times a request that failed
+	// with a server or network error is tried before giving up
+	DefaultSteamAPIRetries = 2
 )
 
 type Info struct {
@@ -19,10 +30,31 @@ type Info struct {
 	FinishedGraphsLocation  string
 	StaticDirectoryLocation string
 	TemplateDirectory       string
+	CheckpointsLocation     string
+	// FrontierLocation is where crawl frontiers too big
+	// to be kept in memory are spilled to
+	FrontierLocation string
+	// ChangesLocation is where the history of changes
+	// to each user's friend list is kept
+	ChangesLocation string
+	// VanityURLsLocation is where custom profile names
+	// resolved to steamIDs are cached
+	VanityURLsLocation string
 
 	// Configuration flags
 	IgnoreCache bool
 	AlwaysCrawl bool
+	// MaxCacheAge is how long a cached friend list is trusted for before
+	// it's fetched again. Zero means cached friend lists never go stale
+	MaxCacheAge time.Duration
+
+	// SteamAPIScheme and SteamAPIHost are where calls to the Steam web API
+	// are sent. The host can include a port and a path so that a mirror,
+	// a caching proxy or a local stand-in can be used instead
+	SteamAPIScheme  string
+	SteamAPIHost    string
+	SteamAPITimeout time.Duration
+	SteamAPIRetries int
 
 	UrlMap map[string]string
 }
@@ -35,6 +67,20 @@ func SetConfig(config Info) {
 	AppConfig = config
 }
 
+// SteamAPIURL returns the URL of a Steam web API method such as
+// ISteamUser/GetFriendList/v0001/, using the defaults for
+// the scheme and host if they haven't been set
+func (info Info) SteamAPIURL(method string) string {
+	scheme, host := info.SteamAPIScheme, info.SteamAPIHost
+	if scheme == "" {
+		scheme = DefaultSteamAPIScheme
+	}
+	if host == "" {
+		host = DefaultSteamAPIHost
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, strings.TrimSuffix(host, "/"), strings.TrimPrefix(method, "/"))
+}
+
 func InitAndSetConfig(mode string, dontReadCache, alwaysCrawl bool) {
 	// baseFolder is the root directory for steamFriendsGraphing
 	baseFolder := ""
@@ -45,6 +91,10 @@ func InitAndSetConfig(mode string, dontReadCache, alwaysCrawl bool) {
 	finishedGraphsLocation := ""
 	staticDirectoyLocation := ""
 	templateDirectory := ""
+	checkpointsLocation := ""
+	frontierLocation := ""
+	changesLocation := ""
+	vanityURLsLocation := ""
 
 	path, err := os.Getwd()
 	CheckErr(err)
@@ -54,11 +104,19 @@ func InitAndSetConfig(mode string, dontReadCache, alwaysCrawl bool) {
 		cacheFolderLocation = filepath.Join(baseFolder, "testData")
 		logsFolderLocation = filepath.Join(baseFolder, "testLogs")
 		finishedGraphsLocation = filepath.Join(baseFolder, "testFinishedGraphs")
+		checkpointsLocation = filepath.Join(baseFolder, "testCheckpoints")
+		frontierLocation = filepath.Join(baseFolder, "testFrontier")
+		changesLocation = filepath.Join(baseFolder, "testChanges")
+		vanityURLsLocation = filepath.Join(baseFolder, "testVanityURLs.json")
 	} else {
 		baseFolder = fmt.Sprintf("%s/../", path)
 		cacheFolderLocation = filepath.Join(baseFolder, "userData")
 		logsFolderLocation = filepath.Join(baseFolder, "logs")
 		finishedGraphsLocation = filepath.Join(baseFolder, "static/graph")
+		checkpointsLocation = filepath.Join(baseFolder, "checkpoints")
+		frontierLocation = filepath.Join(baseFolder, "frontier")
+		changesLocation = filepath.Join(baseFolder, "changes")
+		vanityURLsLocation = filepath.Join(baseFolder, "config/vanityURLs.json")
 	}
 
 	apiKeysFileLocation = filepath.Join(baseFolder, "APIKEYS.txt")
@@ -74,8 +132,16 @@ func InitAndSetConfig(mode string, dontReadCache, alwaysCrawl bool) {
 		FinishedGraphsLocation:  finishedGraphsLocation,
 		StaticDirectoryLocation: staticDirectoyLocation,
 		TemplateDirectory:       templateDirectory,
+		CheckpointsLocation:     checkpointsLocation,
+		FrontierLocation:        frontierLocation,
+		ChangesLocation:         changesLocation,
+		VanityURLsLocation:      vanityURLsLocation,
 		IgnoreCache:             dontReadCache,
 		AlwaysCrawl:             alwaysCrawl,
+		SteamAPIScheme:          DefaultSteamAPIScheme,
+		SteamAPIHost:            DefaultSteamAPIHost,
+		SteamAPITimeout:         DefaultSteamAPITimeout,
+		SteamAPIRetries:         DefaultSteamAPIRetries,
 	}
 
 	urlMap := make(map[string]string)
@@ -0,0 +1,508 @@
+// +build service
+
+package fakesteam
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/util"
+	"github.com/steamFriendsGraphing/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	configuration.InitAndSetConfig("testing", false, false)
+
+	code := m.Run()
+
+	os.Exit(code)
+}
+
+// useFakeSteam points the Steam web API at a fake server for the rest of the test
+func useFakeSteam(t *testing.T, server *Server) (util.Controller, func()) {
+	testServer := httptest.NewServer(server)
+	appConfig := configuration.AppConfig
+	configuration.AppConfig.SteamAPIHost = strings.TrimPrefix(testServer.URL, "http://")
+	configuration.AppConfig.SteamAPIScheme = "http"
+
+	cntr := util.Controller{HTTP: util.NewHTTPClient(time.Second, 0)}
+	return cntr, func() {
+		testServer.Close()
+		configuration.SetConfig(appConfig)
+	}
+}
+
+// useTempFolders points every folder a crawl writes to at a temporary directory and
+// gives it a report page template holding the graph's ID and how many users failed
+func useTempFolders(t *testing.T) string {
+	tempDir := t.TempDir()
+	for _, folder := range []string{"cache", "logs", "graphs", "checkpoints", "frontier", "changes", "templates"} {
+		os.MkdirAll(filepath.Join(tempDir, folder), 0755)
+	}
+	configuration.AppConfig.CacheFolderLocation = filepath.Join(tempDir, "cache")
+	configuration.AppConfig.LogsFolderLocation = filepath.Join(tempDir, "logs")
+	configuration.AppConfig.FinishedGraphsLocation = filepath.Join(tempDir, "graphs")
+	configuration.AppConfig.CheckpointsLocation = filepath.Join(tempDir, "checkpoints")
+	configuration.AppConfig.FrontierLocation = filepath.Join(tempDir, "frontier")
+	configuration.AppConfig.ChangesLocation = filepath.Join(tempDir, "changes")
+	configuration.AppConfig.TemplateDirectory = filepath.Join(tempDir, "templates")
+	configuration.AppConfig.UrlMappingsLocation = filepath.Join(tempDir, "urlMappings.txt")
+	configuration.AppConfig.UrlMap = make(map[string]string)
+	ioutil.WriteFile(filepath.Join(tempDir, "templates", "reportPage.html"), []byte("{{.ID}} {{len .Failures}}"), 0644)
+	return tempDir
+}
+
+// readGraphAndReport reads the rendered graph and the report page saved for a graph
+func readGraphAndReport(t *testing.T, tempDir, graphID string) (string, string) {
+	renderedGraph, err := ioutil.ReadFile(filepath.Join(tempDir, "graphs", graphID+".html"))
+	assert.Nil(t, err)
+	report, err := ioutil.ReadFile(filepath.Join(tempDir, "graphs", graphID+".report.html"))
+	assert.Nil(t, err)
+	return string(renderedGraph), string(report)
+}
+
+func TestRandomGraphIsTheSameForTheSameSeed(t *testing.T) {
+	graph := RandomGraph(50, 6, 7)
+	sameGraph := RandomGraph(50, 6, 7)
+
+	assert.Equal(t, 50, graph.Len())
+	assert.Equal(t, graph.Edges(), sameGraph.Edges())
+	for _, steamID := range graph.SteamIDs() {
+		user, _ := graph.User(steamID)
+		sameUser, _ := sameGraph.User(steamID)
+		assert.Equal(t, user.Friends, sameUser.Friends)
+		for _, friend := range user.Friends {
+			assert.True(t, graph.AreFriends(friend, steamID), "friendships should go both ways")
+		}
+	}
+}
+
+func TestGraphFixtureRoundTrips(t *testing.T) {
+	graph := NewGraph()
+	graph.AddUser(SteamIDFor(1), "alice")
+	graph.AddUser(SteamIDFor(2), "bob").Private = true
+	graph.AddFriendship(SteamIDFor(1), SteamIDFor(2))
+	graph.AddFriendship(SteamIDFor(1), SteamIDFor(3))
+
+	fileName := filepath.Join(t.TempDir(), "graph.json")
+	assert.Nil(t, graph.Save(fileName))
+	loaded, err := LoadGraph(fileName)
+
+	assert.Nil(t, err)
+	assert.Equal(t, graph.SteamIDs(), loaded.SteamIDs())
+	assert.Equal(t, 2, loaded.Edges())
+	bob, _ := loaded.User(SteamIDFor(2))
+	assert.True(t, bob.Private)
+	assert.True(t, loaded.AreFriends(SteamIDFor(3), SteamIDFor(1)))
+}
+
+func TestLoadGraphRejectsInvalidSteamIDs(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "graph.json")
+	ioutil.WriteFile(fileName, []byte(`{"users":[{"steamid":"notASteamID","friends":[]}]}`), 0644)
+
+	_, err := LoadGraph(fileName)
+
+	assert.NotNil(t, err)
+}
+
+func TestServerServesFriendListsAndSummaries(t *testing.T) {
+	graph := NewGraph()
+	graph.AddUser(SteamIDFor(1), "alice")
+	graph.AddUser(SteamIDFor(2), "bob")
+	graph.AddUser(SteamIDFor(3), "carol").Private = true
+	graph.AddFriendship(SteamIDFor(1), SteamIDFor(2))
+	graph.AddFriendship(SteamIDFor(1), SteamIDFor(3))
+	server := NewServer(graph, Faults{}, "goodKey")
+	cntr, closeServer := useFakeSteam(t, server)
+	defer closeServer()
+
+	friends, err := cntr.CallGetFriendsListAPI(context.Background(), SteamIDFor(1), "goodKey")
+	assert.Nil(t, err)
+	assert.Len(t, friends.FriendsList.Friends, 2)
+
+	summaries, err := cntr.CallPlayerSummaryAPI(context.Background(), SteamIDFor(2)+","+SteamIDFor(3), "goodKey")
+	assert.Nil(t, err)
+	assert.Len(t, summaries.Response.Players, 2)
+	for _, player := range summaries.Response.Players {
+		assert.Equal(t, player.Steamid == SteamIDFor(3), util.IsPrivateProfile(player.Communityvisibilitystate))
+	}
+
+	_, err = cntr.CallGetFriendsListAPI(context.Background(), SteamIDFor(3), "goodKey")
+	assert.True(t, errors.Is(err, util.ErrPrivateProfile))
+	_, err = cntr.CallGetFriendsListAPI(context.Background(), SteamIDFor(1), "badKey")
+	assert.True(t, errors.Is(err, util.ErrInvalidKey))
+
+	assert.Nil(t, util.CheckAPIKeys(cntr, []string{"goodKey"}))
+	assert.NotNil(t, util.CheckAPIKeys(cntr, []string{"badKey"}))
+	assert.Equal(t, 5, server.Requests(friendListPath))
+}
+
+func TestServerInjectsFaults(t *testing.T) {
+	graph := RandomGraph(20, 4, 1)
+	server := NewServer(graph, Faults{RateLimitRate: 0.3, ServerErrorRate: 0.3, Seed: 1})
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	statusCodes := make(map[int]int)
+	for i := 0; i < 200; i++ {
+		res, err := http.Get(testServer.URL + friendListPath + "?key=anyKey&steamid=" + SteamIDFor(1))
+		assert.Nil(t, err)
+		res.Body.Close()
+		statusCodes[res.StatusCode]++
+	}
+
+	assert.Len(t, statusCodes, 3)
+	for _, statusCode := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		assert.InDelta(t, 66, statusCodes[statusCode], 30, "status %d", statusCode)
+	}
+}
+
+func TestPrivateFaultsPickTheSameUsersForTheSameSeed(t *testing.T) {
+	graph := RandomGraph(200, 4, 1)
+	server := NewServer(graph, Faults{PrivateRate: 0.25, Seed: 3})
+	sameServer := NewServer(graph, Faults{PrivateRate: 0.25, Seed: 3})
+
+	private := 0
+	for _, steamID := range graph.SteamIDs() {
+		assert.Equal(t, server.IsPrivate(steamID), sameServer.IsPrivate(steamID))
+		if server.IsPrivate(steamID) {
+			private++
+		}
+	}
+	assert.InDelta(t, 50, private, 25)
+}
+
+// TestCrawlOneUserAgainstFakeSteam runs a whole crawl through to the rendered
+// graph page without touching the real Steam web API or the test data folders
+func TestCrawlOneUserAgainstFakeSteam(t *testing.T) {
+	graph := RandomGraph(60, 5, 2)
+	server := NewServer(graph, Faults{PrivateRate: 0.2, Latency: time.Millisecond, Seed: 2}, "fakeKey")
+	cntr, closeServer := useFakeSteam(t, server)
+	defer closeServer()
+
+	tempDir := useTempFolders(t)
+
+	// The seed must be public for there to be anything to crawl
+	seed := ""
+	for _, steamID := range graph.SteamIDs() {
+		if !server.IsPrivate(steamID) {
+			seed = steamID
+			break
+		}
+	}
+	config := worker.CrawlerConfig{
+		Level:    2,
+		Workers:  4,
+		TestKeys: true,
+		APIKeys:  []string{"fakeKey"},
+	}
+	err := worker.CrawlOneUser(context.Background(), seed, cntr, config)
+	assert.Nil(t, err)
+
+	graphID := configuration.AppConfig.UrlMap[seed]
+	assert.NotEmpty(t, graphID)
+	renderedGraph, report := readGraphAndReport(t, tempDir, graphID)
+	seedUser, _ := graph.User(seed)
+	assert.Contains(t, renderedGraph, seedUser.Personaname, "the seed's node should be in the rendered graph")
+	assert.True(t, strings.HasPrefix(report, graphID))
+
+	stats, err := worker.LoadCrawlStats(graphID)
+	assert.Nil(t, err)
+	assert.Len(t, stats, 1)
+	// Only the seed's public friends are crawled on the second level
+	// while the private ones are counted and left uncrawled
+	publicFriends := 0
+	for _, friend := range seedUser.Friends {
+		if !server.IsPrivate(friend) {
+			publicFriends++
+		}
+	}
+	assert.True(t, stats[0].Complete)
+	assert.Equal(t, 1+publicFriends, stats[0].UsersCrawled)
+	assert.GreaterOrEqual(t, stats[0].PrivateFriends, len(seedUser.Friends)-publicFriends)
+	assert.Equal(t, len(seedUser.Friends), stats[0].FriendsPerLevel[2])
+	assert.Equal(t, stats[0].CacheMisses, server.Requests(friendListPath)-1, "every user not cached should be fetched exactly once besides the key check")
+}
+
+func TestCrawlManyUsersReportsFailuresOnTheGraphPage(t *testing.T) {
+	graph := NewGraph()
+	seeds := []string{SteamIDFor(1), SteamIDFor(2), SteamIDFor(3)}
+	for _, seed := range seeds {
+		graph.AddFriendship(seed, SteamIDFor(4))
+	}
+	// The first seed's friend has no account so their friend list can't be fetched
+	graph.AddFriendship(seeds[0], SteamIDFor(5))
+	delete(graph.users, SteamIDFor(5))
+	server := NewServer(graph, Faults{}, "fakeKey")
+	cntr, closeServer := useFakeSteam(t, server)
+	defer closeServer()
+	tempDir := useTempFolders(t)
+
+	config := worker.CrawlerConfig{Level: 2, Workers: 2, TestKeys: true, APIKeys: []string{"fakeKey"}}
+	err := worker.CrawlUsers(context.Background(), seeds, configuration.AppConfig.UrlMap, cntr, config)
+	assert.Nil(t, err)
+
+	graphID := configuration.AppConfig.UrlMap[strings.Join(seeds, ",")]
+	assert.NotEmpty(t, graphID)
+	renderedGraph, report := readGraphAndReport(t, tempDir, graphID)
+	for _, steamID := range append(seeds, SteamIDFor(4)) {
+		assert.Contains(t, renderedGraph, steamID, "the report page shouldn't replace the rendered graph")
+	}
+	assert.Equal(t, graphID+" 1", report)
+}
+
+func TestCrawlOneUserAgainKeepsTheReportOfTheFirstCrawl(t *testing.T) {
+	graph := NewGraph()
+	seed := SteamIDFor(1)
+	graph.AddFriendship(seed, SteamIDFor(2))
+	// The seed's friend has no account so their friend list can't be fetched
+	graph.AddFriendship(seed, SteamIDFor(3))
+	delete(graph.users, SteamIDFor(3))
+	server := NewServer(graph, Faults{}, "fakeKey")
+	cntr, closeServer := useFakeSteam(t, server)
+	defer closeServer()
+	tempDir := useTempFolders(t)
+
+	config := worker.CrawlerConfig{Level: 2, Workers: 2, TestKeys: true, APIKeys: []string{"fakeKey"}}
+	assert.Nil(t, worker.CrawlOneUser(context.Background(), seed, cntr, config))
+	assert.Nil(t, worker.CrawlOneUser(context.Background(), seed, cntr, config))
+
+	graphID := configuration.AppConfig.UrlMap[seed]
+	_, report := readGraphAndReport(t, tempDir, graphID)
+	assert.Equal(t, graphID+" 1", report)
+}
+
+func TestCrawlManyUsersSharesItsBudgetAcrossSeeds(t *testing.T) {
+	graph := NewGraph()
+	seeds := []string{SteamIDFor(1), SteamIDFor(2), SteamIDFor(3)}
+	for i, seed := range seeds {
+		for friend := 0; friend < 4; friend++ {
+			graph.AddFriendship(seed, SteamIDFor(10*(i+1)+friend))
+		}
+	}
+	server := NewServer(graph, Faults{}, "fakeKey")
+	cntr, closeServer := useFakeSteam(t, server)
+	defer closeServer()
+	tempDir := useTempFolders(t)
+
+	config := worker.CrawlerConfig{Level: 2, Workers: 4, APIKeys: []string{"fakeKey"}, MaxUsers: 3}
+	err := worker.CrawlUsers(context.Background(), seeds, configuration.AppConfig.UrlMap, cntr, config)
+	assert.Nil(t, err)
+
+	// The first seed's crawl uses up the budget so the other seeds are never crawled
+	assert.Equal(t, 3, server.Requests(friendListPath))
+	graphID := configuration.AppConfig.UrlMap[strings.Join(seeds, ",")]
+	_, err = os.Stat(filepath.Join(tempDir, "graphs", graphID+".html"))
+	assert.Nil(t, err)
+}
+
+func TestCrawlPathFirstSavesStatsAndTheGraphPage(t *testing.T) {
+	graph := NewGraph()
+	seeds := []string{SteamIDFor(1), SteamIDFor(2)}
+	for _, seed := range seeds {
+		graph.AddFriendship(seed, SteamIDFor(3))
+	}
+	server := NewServer(graph, Faults{}, "fakeKey")
+	cntr, closeServer := useFakeSteam(t, server)
+	defer closeServer()
+	tempDir := useTempFolders(t)
+
+	config := worker.CrawlerConfig{Level: 3, Workers: 2, APIKeys: []string{"fakeKey"}, PathFirst: true}
+	err := worker.CrawlUsers(context.Background(), seeds, configuration.AppConfig.UrlMap, cntr, config)
+	assert.Nil(t, err)
+
+	graphID := configuration.AppConfig.UrlMap[strings.Join(seeds, ",")]
+	assert.NotEmpty(t, graphID)
+	renderedGraph, report := readGraphAndReport(t, tempDir, graphID)
+	// Every user on the path is a node of the graph that is served rather than being
+	// replaced by the report page. Users are named after their steamIDs here
+	for _, steamID := range append(seeds, SteamIDFor(3)) {
+		assert.Contains(t, renderedGraph, steamID)
+	}
+	assert.Equal(t, graphID+" 0", report)
+
+	stats, err := worker.LoadCrawlStats(graphID)
+	assert.Nil(t, err)
+	assert.Len(t, stats, 2)
+	assert.True(t, stats[0].Complete)
+	assert.Equal(t, 2, stats[0].UsersCrawled+stats[1].UsersCrawled)
+}
+
+func TestBarabasiAlbertGrowsHubs(t *testing.T) {
+	graph, err := Generate(GeneratorConfig{Model: BarabasiAlbert, Users: 2000, FriendsPerUser: 6, Seed: 4})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2000, graph.Len())
+	// Every user after the first few makes exactly three friendships
+	assert.Equal(t, 3*(2000-4)+6, graph.Edges())
+	mostFriends := 0
+	for _, steamID := range graph.SteamIDs() {
+		user, _ := graph.User(steamID)
+		assert.GreaterOrEqual(t, len(user.Friends), 3)
+		if len(user.Friends) > mostFriends {
+			mostFriends = len(user.Friends)
+		}
+	}
+	assert.Greater(t, mostFriends, 10*6, "preferential attachment should give hubs far above the average")
+}
+
+func TestWattsStrogatzWithoutRewiringIsARing(t *testing.T) {
+	graph, err := Generate(GeneratorConfig{Model: WattsStrogatz, Users: 100, FriendsPerUser: 4})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 200, graph.Edges())
+	assert.True(t, graph.AreFriends(SteamIDFor(1), SteamIDFor(100)))
+	assert.True(t, graph.AreFriends(SteamIDFor(1), SteamIDFor(99)))
+	assert.False(t, graph.AreFriends(SteamIDFor(1), SteamIDFor(4)))
+
+	rewired, err := Generate(GeneratorConfig{Model: WattsStrogatz, Users: 100, FriendsPerUser: 4, Rewire: 0.5, Seed: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rewired.Edges(), "rewiring moves friendships without losing any")
+}
+
+func TestCommunitiesMostlyBefriendEachOther(t *testing.T) {
+	graph, err := Generate(GeneratorConfig{Model: Communities, Users: 500, FriendsPerUser: 10, CommunityCount: 5, Mixing: 0.1, Seed: 2})
+	assert.Nil(t, err)
+
+	within, between := 0, 0
+	for _, steamID := range graph.SteamIDs() {
+		user, _ := graph.User(steamID)
+		assert.NotZero(t, user.Community)
+		for _, friendID := range user.Friends {
+			friend, _ := graph.User(friendID)
+			if friend.Community == user.Community {
+				within++
+			} else {
+				between++
+			}
+		}
+	}
+	assert.InDelta(t, 0.08, float64(between)/float64(within+between), 0.04)
+}
+
+func TestGenerateRejectsBadConfigs(t *testing.T) {
+	for _, config := range []GeneratorConfig{
+		{Model: "unknown", Users: 10},
+		{Model: BarabasiAlbert, Users: -1},
+		{Model: Communities, Users: 10},
+		{Model: Uniform, Users: 10, PrivateRate: 1.5},
+	} {
+		_, err := Generate(config)
+		assert.NotNil(t, err, "%+v", config)
+	}
+}
+
+func TestGeneratedCacheIsReadByTheCrawler(t *testing.T) {
+	graph, err := Generate(GeneratorConfig{Model: Uniform, Users: 100, FriendsPerUser: 8, PrivateRate: 0.2, NameCollisionRate: 0.3, Seed: 5})
+	assert.Nil(t, err)
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.CacheFolderLocation = t.TempDir()
+
+	fetchedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = graph.WriteCache(util.Controller{}, configuration.AppConfig.CacheFolderLocation, fetchedAt)
+	assert.Nil(t, err)
+
+	names := make(map[string]bool)
+	private := 0
+	for _, steamID := range graph.SteamIDs() {
+		user, _ := graph.User(steamID)
+		names[user.Personaname] = true
+		friends, err := worker.GetCache(util.Controller{}, steamID)
+		if user.Private {
+			private++
+			assert.NotNil(t, err, "private users shouldn't be cached")
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, user.Personaname, friends.Username)
+		assert.True(t, friends.FetchedAt.Equal(fetchedAt))
+		assert.Len(t, friends.FriendsList.Friends, len(user.Friends))
+		for _, friend := range friends.FriendsList.Friends {
+			friendUser, _ := graph.User(friend.Steamid)
+			assert.Equal(t, friendUser.Private, util.IsPrivateProfile(friend.CommunityVisibilityState))
+		}
+	}
+	assert.InDelta(t, 20, private, 12)
+	assert.Less(t, len(names), 85, "some users should share their personanames")
+}
+
+func TestGamesEnrichmentAgainstFakeSteam(t *testing.T) {
+	graph := RandomGraph(30, 4, 5)
+	server := NewServer(graph, Faults{PrivateRate: 0.3, Seed: 5}, "fakeKey")
+	cntr, closeServer := useFakeSteam(t, server)
+	defer closeServer()
+	configuration.AppConfig.CacheFolderLocation = t.TempDir()
+	configuration.AppConfig.LogsFolderLocation = t.TempDir()
+	// Users are cached as public but the fault makes some of them hide their games
+	assert.Nil(t, graph.WriteCache(cntr, configuration.AppConfig.CacheFolderLocation, time.Now()))
+
+	private := 0
+	owners := 0
+	for _, steamID := range graph.SteamIDs() {
+		if server.IsPrivate(steamID) {
+			private++
+			continue
+		}
+		for _, game := range server.OwnedGames(steamID) {
+			if game.AppID == 730 {
+				owners++
+			}
+		}
+	}
+
+	config := worker.CrawlerConfig{Workers: 3, APIKeys: []string{"fakeKey"}, Games: worker.GamesOwned}
+	enrichment, err := worker.EnrichGames(context.Background(), cntr, config, graph.SteamIDs())
+	assert.Nil(t, err)
+	assert.Equal(t, worker.GamesEnrichment{Fetched: 30 - private, Private: private}, enrichment)
+	assert.Equal(t, 30, server.Requests(ownedGamesPath))
+
+	for _, steamID := range graph.SteamIDs() {
+		friends, err := worker.GetCache(cntr, steamID)
+		assert.Nil(t, err)
+		assert.Equal(t, string(worker.GamesOwned), friends.Games.Source)
+		assert.Equal(t, server.IsPrivate(steamID), friends.Games.Private)
+		if !server.IsPrivate(steamID) {
+			assert.Equal(t, server.OwnedGames(steamID), friends.Games.Games)
+		}
+	}
+
+	// Games already in the cache aren't fetched again
+	enrichment, err = worker.EnrichGames(context.Background(), cntr, config, graph.SteamIDs())
+	assert.Nil(t, err)
+	assert.Equal(t, worker.GamesEnrichment{Cached: 30}, enrichment)
+	assert.Equal(t, 30, server.Requests(ownedGamesPath))
+
+	report := worker.SharedGames(cntr, graph.SteamIDs())
+	assert.Equal(t, 30, report.Users)
+	assert.Equal(t, 30-private, report.WithGames)
+	assert.Equal(t, private, report.Private)
+	assert.NotEmpty(t, report.Games)
+	for i, game := range report.Games {
+		assert.GreaterOrEqual(t, game.Owners, 2)
+		if i > 0 {
+			assert.LessOrEqual(t, game.Owners, report.Games[i-1].Owners)
+		}
+		if game.AppID == 730 {
+			assert.Equal(t, owners, game.Owners)
+			assert.Equal(t, "Counter-Strike 2", game.Name)
+		}
+	}
+
+	// Recently played games are a different source so they're fetched
+	config.Games = worker.GamesRecent
+	enrichment, err = worker.EnrichGames(context.Background(), cntr, config, graph.SteamIDs())
+	assert.Nil(t, err)
+	assert.Equal(t, 30-private, enrichment.Fetched)
+	assert.Equal(t, 30, server.Requests(recentGamesPath))
+}
@@ -0,0 +1,273 @@
+package fakesteam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+// Model is a way of generating a synthetic friend network
+type Model string
+
+const (
+	// Uniform picks every friendship uniformly at random
+	Uniform Model = "uniform"
+	// BarabasiAlbert grows the network one user at a time with each new user
+	// befriending existing users in proportion to how many friends they have,
+	// giving a few very well connected hubs
+	BarabasiAlbert Model = "ba"
+	// WattsStrogatz starts from a ring where each user is friends with their
+	// nearest neighbours and rewires some of those friendships at random,
+	// giving a small world with lots of mutual friends
+	WattsStrogatz Model = "ws"
+	// Communities splits users into groups that mostly befriend each other
+	Communities Model = "community"
+)
+
+// communityVisibilityPrivate is the communityvisibilitystate
+// given by the Steam web API for private profiles
+const communityVisibilityPrivate = 1
+
+// GeneratorConfig describes the synthetic friend network to generate
+type GeneratorConfig struct {
+	Model Model
+	Users int
+	// FriendsPerUser is the average amount of friends each user has
+	FriendsPerUser int
+	// Rewire is the share of friendships rewired by WattsStrogatz
+	Rewire float64
+	// CommunityCount is how many groups Communities splits users into and
+	// Mixing is the share of friendships made outside of a user's group
+	CommunityCount int
+	Mixing         float64
+
+	// PrivateRate is the share of users whose profiles are private
+	PrivateRate float64
+	// NameCollisionRate is the share of users who take the personaname
+	// of another user instead of having a unique one
+	NameCollisionRate float64
+	Seed              int64
+}
+
+// Generate creates a synthetic friend network. The same config always gives the same graph
+func Generate(config GeneratorConfig) (*Graph, error) {
+	if config.Users < 0 || config.FriendsPerUser < 0 {
+		return nil, util.MakeErr(fmt.Errorf("users (%d) and friends per user (%d) can't be negative", config.Users, config.FriendsPerUser))
+	}
+	for name, rate := range map[string]float64{
+		"rewire":              config.Rewire,
+		"mixing":              config.Mixing,
+		"private rate":        config.PrivateRate,
+		"name collision rate": config.NameCollisionRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return nil, util.MakeErr(fmt.Errorf("%s must be between 0 and 1, got %v", name, rate))
+		}
+	}
+
+	random := rand.New(rand.NewSource(config.Seed))
+	var graph *Graph
+	switch config.Model {
+	case Uniform, "":
+		graph = RandomGraph(config.Users, config.FriendsPerUser, config.Seed)
+	case BarabasiAlbert:
+		graph = barabasiAlbert(random, config.Users, config.FriendsPerUser)
+	case WattsStrogatz:
+		graph = wattsStrogatz(random, config.Users, config.FriendsPerUser, config.Rewire)
+	case Communities:
+		if config.CommunityCount < 1 {
+			return nil, util.MakeErr(errors.New("the community model needs at least one community"))
+		}
+		graph = communities(random, config.Users, config.FriendsPerUser, config.CommunityCount, config.Mixing)
+	default:
+		return nil, util.MakeErr(fmt.Errorf("unknown model %q", config.Model))
+	}
+
+	for i := 1; i <= config.Users; i++ {
+		user := graph.users[SteamIDFor(i)]
+		user.Private = random.Float64() < config.PrivateRate
+		if i > 1 && random.Float64() < config.NameCollisionRate {
+			user.Personaname = graph.users[SteamIDFor(random.Intn(i-1)+1)].Personaname
+		}
+	}
+	return graph, nil
+}
+
+// newNumberedGraph creates a graph of users numbered from 1 without any friendships
+func newNumberedGraph(users int) *Graph {
+	graph := NewGraph()
+	for i := 1; i <= users; i++ {
+		graph.AddUser(SteamIDFor(i), fmt.Sprintf("user%d", i))
+	}
+	return graph
+}
+
+func barabasiAlbert(random *rand.Rand, users, friendsPerUser int) *Graph {
+	graph := newNumberedGraph(users)
+	// Each new user makes perUser friendships, each counted
+	// from both sides, giving friendsPerUser on average
+	perUser := friendsPerUser / 2
+	if perUser < 1 {
+		perUser = 1
+	}
+	if users <= perUser {
+		perUser = users - 1
+	}
+
+	// Every user appears in endpoints once for each friend they have so
+	// picking from it uniformly picks users in proportion to their friends
+	endpoints := []int{}
+	for i := 1; i <= perUser+1 && i <= users; i++ {
+		for j := 1; j < i; j++ {
+			graph.AddFriendship(SteamIDFor(i), SteamIDFor(j))
+			endpoints = append(endpoints, i, j)
+		}
+	}
+	for i := perUser + 2; i <= users; i++ {
+		picked := make(map[int]bool, perUser)
+		friends := make([]int, 0, perUser)
+		for len(friends) < perUser {
+			friend := endpoints[random.Intn(len(endpoints))]
+			if !picked[friend] {
+				picked[friend] = true
+				friends = append(friends, friend)
+			}
+		}
+		for _, friend := range friends {
+			graph.AddFriendship(SteamIDFor(i), SteamIDFor(friend))
+			endpoints = append(endpoints, i, friend)
+		}
+	}
+	return graph
+}
+
+func wattsStrogatz(random *rand.Rand, users, friendsPerUser int, rewire float64) *Graph {
+	graph := newNumberedGraph(users)
+	neighbours := friendsPerUser / 2
+	if neighbours >= (users+1)/2 {
+		neighbours = (users - 1) / 2
+	}
+
+	adjacent := make([]map[int]bool, users+1)
+	for i := range adjacent {
+		adjacent[i] = make(map[int]bool)
+	}
+	type edge struct{ from, to int }
+	edges := []edge{}
+	for i := 1; i <= users; i++ {
+		for offset := 1; offset <= neighbours; offset++ {
+			j := (i-1+offset)%users + 1
+			edges = append(edges, edge{i, j})
+			adjacent[i][j], adjacent[j][i] = true, true
+		}
+	}
+	// Rewiring keeps one end of a friendship and moves the other end
+	// to a random user the first isn't already friends with
+	for index, current := range edges {
+		if random.Float64() >= rewire || len(adjacent[current.from]) >= users-1 {
+			continue
+		}
+		to := random.Intn(users) + 1
+		for to == current.from || adjacent[current.from][to] {
+			to = random.Intn(users) + 1
+		}
+		delete(adjacent[current.from], current.to)
+		delete(adjacent[current.to], current.from)
+		adjacent[current.from][to], adjacent[to][current.from] = true, true
+		edges[index].to = to
+	}
+	for _, current := range edges {
+		graph.AddFriendship(SteamIDFor(current.from), SteamIDFor(current.to))
+	}
+	return graph
+}
+
+func communities(random *rand.Rand, users, friendsPerUser, communityCount int, mixing float64) *Graph {
+	graph := newNumberedGraph(users)
+	if communityCount > users {
+		communityCount = users
+	}
+	members := make([][]int, communityCount)
+	for i := 1; i <= users; i++ {
+		community := (i - 1) % communityCount
+		graph.users[SteamIDFor(i)].Community = community + 1
+		members[community] = append(members[community], i)
+	}
+	if users < 2 {
+		return graph
+	}
+
+	for i := 0; i < users*friendsPerUser/2; i++ {
+		from := random.Intn(users) + 1
+		community := members[(from-1)%communityCount]
+		to := community[random.Intn(len(community))]
+		if len(community) < 2 || random.Float64() < mixing {
+			to = random.Intn(users) + 1
+		}
+		graph.AddFriendship(SteamIDFor(from), SteamIDFor(to))
+	}
+	return graph
+}
+
+// WriteCache writes the friend list of every public user in the graph to
+// cacheFolder in the same format the crawler caches them, as if each had
+// been fetched at fetchedAt. Private users are left out since the crawler
+// can't fetch their friend lists
+func (graph *Graph) WriteCache(cntr util.ControllerInterface, cacheFolder string, fetchedAt time.Time) error {
+	err := os.MkdirAll(cacheFolder, 0755)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	for _, steamID := range graph.SteamIDs() {
+		user := graph.users[steamID]
+		if user.Private {
+			continue
+		}
+
+		friendsObj := util.FriendsStruct{
+			Username:                 user.Personaname,
+			CommunityVisibilityState: util.CommunityVisibilityPublic,
+			FriendsList:              util.Friendslist{Friends: make([]util.Friend, 0, len(user.Friends))},
+			FetchedAt:                fetchedAt,
+		}
+		for _, friendID := range user.Friends {
+			friend := graph.users[friendID]
+			visibility := util.CommunityVisibilityPublic
+			if friend.Private {
+				visibility = communityVisibilityPrivate
+			}
+			friendsObj.FriendsList.Friends = append(friendsObj.FriendsList.Friends, util.Friend{
+				Username:                 friend.Personaname,
+				Steamid:                  friendID,
+				Relationship:             "friend",
+				FriendSince:              friendSince(steamID, friendID),
+				CommunityVisibilityState: visibility,
+			})
+		}
+
+		jsonObj, err := json.Marshal(friendsObj)
+		if err != nil {
+			return util.MakeErr(err)
+		}
+		file, err := cntr.CreateFile(filepath.Join(cacheFolder, fmt.Sprintf("%s.gz", steamID)))
+		if err != nil {
+			return util.MakeErr(err)
+		}
+		err = cntr.WriteGzip(file, string(jsonObj))
+		if err != nil {
+			file.Close()
+			return util.MakeErr(err)
+		}
+		err = file.Close()
+		if err != nil {
+			return util.MakeErr(err)
+		}
+	}
+	return nil
+}
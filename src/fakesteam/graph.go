@@ -0,0 +1,181 @@
+package fakesteam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+// steamID64Base is the steamID of the account with account ID 0
+const steamID64Base = 76561197960265728
+
+// User is an account served by the fake Steam web API
+type User struct {
+	SteamID     string `json:"steamid"`
+	Personaname string `json:"personaname"`
+	// Private profiles refuse to give out their friends list
+	Private bool `json:"private,omitempty"`
+	// Community is the group the Communities model put the user in, 0 if none
+	Community int      `json:"community,omitempty"`
+	Friends   []string `json:"friends"`
+}
+
+// Graph is the friend network served by the fake Steam web API.
+// Friendships always go both ways
+type Graph struct {
+	users   map[string]*User
+	friends map[string]map[string]bool
+}
+
+// NewGraph creates an empty Graph
+func NewGraph() *Graph {
+	return &Graph{
+		users:   make(map[string]*User),
+		friends: make(map[string]map[string]bool),
+	}
+}
+
+// SteamIDFor returns the steamID of the nth account. Synthetic
+// graphs number their users from 1 using this
+func SteamIDFor(n int) string {
+	return strconv.FormatInt(steamID64Base+int64(n), 10)
+}
+
+// AddUser adds an account to the graph. Adding an account that
+// already exists changes its personaname
+func (graph *Graph) AddUser(steamID, personaname string) *User {
+	user, exists := graph.users[steamID]
+	if !exists {
+		user = &User{SteamID: steamID, Friends: []string{}}
+		graph.users[steamID] = user
+		graph.friends[steamID] = make(map[string]bool)
+	}
+	user.Personaname = personaname
+	return user
+}
+
+// AddFriendship makes two accounts friends with each other. Accounts that
+// aren't in the graph yet are added with their steamID as their personaname
+func (graph *Graph) AddFriendship(steamID1, steamID2 string) {
+	if steamID1 == steamID2 {
+		return
+	}
+	for _, steamID := range []string{steamID1, steamID2} {
+		if _, exists := graph.users[steamID]; !exists {
+			graph.AddUser(steamID, steamID)
+		}
+	}
+	if graph.friends[steamID1][steamID2] {
+		return
+	}
+	graph.friends[steamID1][steamID2] = true
+	graph.friends[steamID2][steamID1] = true
+	graph.users[steamID1].Friends = append(graph.users[steamID1].Friends, steamID2)
+	graph.users[steamID2].Friends = append(graph.users[steamID2].Friends, steamID1)
+}
+
+// AreFriends checks whether two accounts are friends
+func (graph *Graph) AreFriends(steamID1, steamID2 string) bool {
+	return graph.friends[steamID1][steamID2]
+}
+
+// User returns the account with the given steamID
+func (graph *Graph) User(steamID string) (*User, bool) {
+	user, exists := graph.users[steamID]
+	return user, exists
+}
+
+// SteamIDs returns the steamID of every account in the graph in order
+func (graph *Graph) SteamIDs() []string {
+	steamIDs := make([]string, 0, len(graph.users))
+	for steamID := range graph.users {
+		steamIDs = append(steamIDs, steamID)
+	}
+	sort.Strings(steamIDs)
+	return steamIDs
+}
+
+// Len returns how many accounts are in the graph
+func (graph *Graph) Len() int {
+	return len(graph.users)
+}
+
+// Edges returns how many friendships are in the graph
+func (graph *Graph) Edges() int {
+	edges := 0
+	for _, friends := range graph.friends {
+		edges += len(friends)
+	}
+	return edges / 2
+}
+
+// graphFile is how a Graph is saved as a fixture
+type graphFile struct {
+	Users []*User `json:"users"`
+}
+
+// LoadGraph loads a graph from a JSON fixture file
+func LoadGraph(fileName string) (*Graph, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, util.MakeErr(err)
+	}
+	fixture := graphFile{}
+	err = json.Unmarshal(content, &fixture)
+	if err != nil {
+		return nil, util.MakeErr(err)
+	}
+
+	graph := NewGraph()
+	for _, user := range fixture.Users {
+		if !util.IsValidFormatSteamID(user.SteamID) {
+			return nil, util.MakeErr(fmt.Errorf("invalid steamID %s in %s", user.SteamID, fileName))
+		}
+		added := graph.AddUser(user.SteamID, user.Personaname)
+		added.Private = user.Private
+		added.Community = user.Community
+	}
+	for _, user := range fixture.Users {
+		for _, friend := range user.Friends {
+			graph.AddFriendship(user.SteamID, friend)
+		}
+	}
+	return graph, nil
+}
+
+// Save writes the graph to a JSON fixture file
+func (graph *Graph) Save(fileName string) error {
+	fixture := graphFile{}
+	for _, steamID := range graph.SteamIDs() {
+		fixture.Users = append(fixture.Users, graph.users[steamID])
+	}
+	jsonObj, err := json.MarshalIndent(fixture, "", "\t")
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	err = ioutil.WriteFile(fileName, jsonObj, 0644)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	return nil
+}
+
+// RandomGraph creates a graph of the given amount of users where each user is
+// friends with friendsPerUser others on average, picked uniformly at random.
+// The same seed always gives the same graph
+func RandomGraph(users, friendsPerUser int, seed int64) *Graph {
+	random := rand.New(rand.NewSource(seed))
+	graph := newNumberedGraph(users)
+	if users < 2 {
+		return graph
+	}
+	for i := 0; i < users*friendsPerUser/2; i++ {
+		graph.AddFriendship(SteamIDFor(random.Intn(users)+1), SteamIDFor(random.Intn(users)+1))
+	}
+	return graph
+}
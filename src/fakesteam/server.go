@@ -0,0 +1,303 @@
+package fakesteam
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+const (
+	friendListPath      = "/ISteamUser/GetFriendList/v0001/"
+	playerSummaryPath   = "/ISteamUser/GetPlayerSummaries/v0002/"
+	ownedGamesPath      = "/IPlayerService/GetOwnedGames/v0001/"
+	recentGamesPath     = "/IPlayerService/GetRecentlyPlayedGames/v0001/"
+	maxSummariesPerCall = 100
+	// keyCheckSteamID is the account whose friend list is requested when
+	// validating API keys. It's always served even if it isn't in the graph
+	keyCheckSteamID = "76561198282036055"
+	// friendSinceBase is the earliest time a friendship can have started, 2010-01-01
+	friendSinceBase = 1262304000
+)
+
+// catalogue is every game served. Each account owns a game with
+// the chance given by its popularity, see Server.OwnedGames
+var catalogue = []struct {
+	game       util.OwnedGame
+	popularity float64
+}{
+	{util.OwnedGame{AppID: 730, Name: "Counter-Strike 2"}, 0.7},
+	{util.OwnedGame{AppID: 570, Name: "Dota 2"}, 0.5},
+	{util.OwnedGame{AppID: 440, Name: "Team Fortress 2"}, 0.45},
+	{util.OwnedGame{AppID: 271590, Name: "Grand Theft Auto V"}, 0.35},
+	{util.OwnedGame{AppID: 578080, Name: "PUBG: BATTLEGROUNDS"}, 0.3},
+	{util.OwnedGame{AppID: 252490, Name: "Rust"}, 0.25},
+	{util.OwnedGame{AppID: 105600, Name: "Terraria"}, 0.25},
+	{util.OwnedGame{AppID: 413150, Name: "Stardew Valley"}, 0.2},
+	{util.OwnedGame{AppID: 892970, Name: "Valheim"}, 0.15},
+	{util.OwnedGame{AppID: 945360, Name: "Among Us"}, 0.1},
+}
+
+// Faults are the failures a Server injects into its responses
+type Faults struct {
+	// Latency is added to every response
+	Latency time.Duration
+	// RateLimitRate and ServerErrorRate are the shares of requests
+	// that are answered with 429 Too Many Requests and with
+	// 503 Service Unavailable instead of being served. 500 Internal
+	// Server Error is kept for steamIDs without an account the same
+	// as the Steam web API
+	RateLimitRate   float64
+	ServerErrorRate float64
+	// PrivateRate is the share of accounts whose profiles are made private
+	// on top of those marked private in the graph. The same accounts are
+	// always picked for the same Seed
+	PrivateRate float64
+	Seed        int64
+}
+
+// Server is a stand-in for the Steam web API serving GetFriendList,
+// GetPlayerSummaries, GetOwnedGames and GetRecentlyPlayedGames from a Graph.
+// API keys are validated the same way as GetFriendList so key validation
+// works against it too
+type Server struct {
+	graph     *Graph
+	faults    Faults
+	validKeys map[string]bool
+
+	mutex    sync.Mutex
+	random   *rand.Rand
+	requests map[string]int
+}
+
+// NewServer creates a Server for a graph. Only the given API keys are
+// accepted, or any API key if none are given
+func NewServer(graph *Graph, faults Faults, validKeys ...string) *Server {
+	server := &Server{
+		graph:     graph,
+		faults:    faults,
+		validKeys: make(map[string]bool),
+		random:    rand.New(rand.NewSource(faults.Seed)),
+		requests:  make(map[string]int),
+	}
+	for _, apiKey := range validKeys {
+		server.validKeys[apiKey] = true
+	}
+	return server
+}
+
+// Requests returns how many requests have been made to a path such
+// as /ISteamUser/GetFriendList/v0001/, including ones that failed
+func (server *Server) Requests(path string) int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.requests[path]
+}
+
+// IsPrivate checks whether an account's profile is private, either
+// because the graph says so or because a fault made it private
+func (server *Server) IsPrivate(steamID string) bool {
+	if user, exists := server.graph.User(steamID); exists && user.Private {
+		return true
+	}
+	if server.faults.PrivateRate <= 0 {
+		return false
+	}
+	return hashShare(server.faults.Seed, steamID) < server.faults.PrivateRate
+}
+
+// ServeHTTP serves a request the way the Steam web API would
+func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Any prefix before the method is ignored so the
+	// server can be mounted anywhere a mirror could be
+	path := req.URL.Path
+	for _, iface := range []string{"/ISteamUser/", "/IPlayerService/"} {
+		if index := strings.Index(path, iface); index > 0 {
+			path = path[index:]
+		}
+	}
+
+	server.mutex.Lock()
+	server.requests[path]++
+	roll := server.random.Float64()
+	server.mutex.Unlock()
+
+	if server.faults.Latency > 0 {
+		time.Sleep(server.faults.Latency)
+	}
+	if path != friendListPath && path != playerSummaryPath && path != ownedGamesPath && path != recentGamesPath {
+		http.NotFound(w, req)
+		return
+	}
+	if len(server.validKeys) > 0 && !server.validKeys[req.URL.Query().Get("key")] {
+		writeHTMLError(w, http.StatusForbidden)
+		return
+	}
+	if roll < server.faults.RateLimitRate {
+		writeHTMLError(w, http.StatusTooManyRequests)
+		return
+	}
+	if roll < server.faults.RateLimitRate+server.faults.ServerErrorRate {
+		writeHTMLError(w, http.StatusServiceUnavailable)
+		return
+	}
+
+	switch path {
+	case friendListPath:
+		server.getFriendList(w, req)
+	case playerSummaryPath:
+		server.getPlayerSummaries(w, req)
+	default:
+		server.getGames(w, req, path == recentGamesPath)
+	}
+}
+
+func (server *Server) getFriendList(w http.ResponseWriter, req *http.Request) {
+	steamID := req.URL.Query().Get("steamid")
+	user, exists := server.graph.User(steamID)
+	if !exists && steamID == keyCheckSteamID {
+		user, exists = &User{SteamID: steamID, Friends: []string{}}, true
+	}
+	// The Steam web API answers unknown steamIDs with an internal server error
+	if !exists {
+		writeHTMLError(w, http.StatusInternalServerError)
+		return
+	}
+	if server.IsPrivate(steamID) {
+		writeHTMLError(w, http.StatusUnauthorized)
+		return
+	}
+
+	friendsList := util.Friendslist{Friends: make([]util.Friend, 0, len(user.Friends))}
+	for _, friend := range user.Friends {
+		friendsList.Friends = append(friendsList.Friends, util.Friend{
+			Steamid:      friend,
+			Relationship: "friend",
+			FriendSince:  friendSince(steamID, friend),
+		})
+	}
+	writeJSON(w, struct {
+		Friendslist util.Friendslist `json:"friendslist"`
+	}{friendsList})
+}
+
+func (server *Server) getPlayerSummaries(w http.ResponseWriter, req *http.Request) {
+	steamIDs := strings.Split(req.URL.Query().Get("steamids"), ",")
+	if len(steamIDs) > maxSummariesPerCall {
+		writeHTMLError(w, http.StatusBadRequest)
+		return
+	}
+
+	response := util.UserStatsStruct{}
+	response.Response.Players = make([]util.Player, 0, len(steamIDs))
+	for _, steamID := range steamIDs {
+		user, exists := server.graph.User(steamID)
+		if !exists {
+			continue
+		}
+		visibility := util.CommunityVisibilityPublic
+		if server.IsPrivate(steamID) {
+			visibility = 1
+		}
+		response.Response.Players = append(response.Response.Players, util.Player{
+			Steamid:                  steamID,
+			Communityvisibilitystate: visibility,
+			Profilestate:             1,
+			Personaname:              user.Personaname,
+			Profileurl:               fmt.Sprintf("https://steamcommunity.com/profiles/%s/", steamID),
+		})
+	}
+	writeJSON(w, response)
+}
+
+// OwnedGames returns the games an account owns. The same account always owns
+// the same games with the same playtimes. Some of them were played recently
+func (server *Server) OwnedGames(steamID string) []util.OwnedGame {
+	games := make([]util.OwnedGame, 0)
+	for _, entry := range catalogue {
+		appID := fmt.Sprintf("%s:%d", steamID, entry.game.AppID)
+		if hashShare(server.faults.Seed, appID) >= entry.popularity {
+			continue
+		}
+		game := entry.game
+		game.PlaytimeForever = int(hashShare(server.faults.Seed+1, appID) * 300 * 60)
+		if hashShare(server.faults.Seed+2, appID) < 0.3 {
+			game.Playtime2Weeks = 1 + int(hashShare(server.faults.Seed+3, appID)*20*60)
+		}
+		games = append(games, game)
+	}
+	return games
+}
+
+// getGames serves an account's owned games or the ones they played recently. Like
+// the Steam web API unknown accounts and private profiles get an empty response
+func (server *Server) getGames(w http.ResponseWriter, req *http.Request, recent bool) {
+	steamID := req.URL.Query().Get("steamid")
+	response := util.GamesStruct{}
+	if _, exists := server.graph.User(steamID); !exists || server.IsPrivate(steamID) {
+		writeJSON(w, response)
+		return
+	}
+
+	games := server.OwnedGames(steamID)
+	if recent {
+		played := make([]util.OwnedGame, 0, len(games))
+		for _, game := range games {
+			if game.Playtime2Weeks > 0 {
+				played = append(played, game)
+			}
+		}
+		games = played
+	}
+	count := len(games)
+	if recent {
+		response.Response.TotalCount = &count
+	} else {
+		response.Response.GameCount = &count
+	}
+	response.Response.Games = games
+	writeJSON(w, response)
+}
+
+// friendSince gives every friendship a start time that's the same from either side
+func friendSince(steamID1, steamID2 string) int {
+	if steamID2 < steamID1 {
+		steamID1, steamID2 = steamID2, steamID1
+	}
+	tenYears := 10 * 365 * 24 * 60 * 60
+	return friendSinceBase + int(hashShare(0, steamID1+steamID2)*float64(tenYears))
+}
+
+// hashShare maps a seed and steamID onto [0, 1)
+func hashShare(seed int64, steamID string) float64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d:%s", seed, steamID)
+	// steamIDs only differ in their last few digits so the
+	// hash is mixed to spread them over the whole range
+	sum := hash.Sum64()
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	sum *= 0xc4ceb9fe1a85ec53
+	sum ^= sum >> 33
+	return float64(sum>>11) / float64(1<<53)
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeHTMLError answers the way the Steam web API does, with a short HTML page
+func writeHTMLError(w http.ResponseWriter, statusCode int) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(statusCode)
+	fmt.Fprintf(w, "<html><head><title>%[1]s</title></head><body><h1>%[1]s</h1></body></html>", http.StatusText(statusCode))
+}
@@ -1,18 +1,17 @@
 package graphing
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
 	"sync"
-	"sync/atomic"
-	"time"
 
 	"github.com/go-echarts/go-echarts/charts"
 	dijkstra "github.com/iamcathal/dijkstra2"
 	"github.com/steamFriendsGraphing/configuration"
 	"github.com/steamFriendsGraphing/logging"
+	"github.com/steamFriendsGraphing/queue"
 	"github.com/steamFriendsGraphing/util"
 )
 
@@ -24,12 +23,33 @@ type graphConfig struct {
 	existingNodes map[string]bool
 }
 
-type workerConfig struct {
-	wg              *sync.WaitGroup
-	jobMutex        *sync.Mutex
-	resMutex        *sync.Mutex
-	activeJobsMutex *sync.Mutex
-}
+// Every node is put into a category so that the graph's legend shows
+// which users are private and which were left out by friend sampling
+const (
+	publicCategory = iota
+	privateCategory
+	skippedCategory
+	// Graphs of several seed users also have a category for the friends
+	// they share followed by a category for each seed user
+	sharedCategory
+	firstSeedCategory
+)
+
+var (
+	graphCategories = []*charts.GraphCategory{
+		{Name: "Public profile"},
+		{Name: "Private profile"},
+		{Name: "Not followed (sampled out)"},
+	}
+	// categoryColors are the colors of each category in order
+	categoryColors = charts.ColorOpts{"#5470c6", "#b5b5b5", "#e0a458"}
+	sharedColor    = "#ffd700"
+	gameColor      = "#21ba45"
+	// seedColors are handed out to seed users in order and reused
+	// once there are more seeds than colors
+	seedColors = []string{"#000000", "#d62728", "#2ca02c", "#9467bd", "#8c564b",
+		"#e377c2", "#17becf", "#bcbd22", "#1f77b4", "#ff7f0e"}
+)
 
 // GraphData holds all of the data points needed to a friend network
 // graph using go-echarts
@@ -42,66 +62,106 @@ type GraphData struct {
 	ApplyDijkstra bool
 	UsersMap      map[int]string
 	DijkstraGraph *dijkstra.Graph
+
+	// Note is shown under the graph's title
+	Note string
+
+	// SampledEdges and SkippedEdges are how many links were followed
+	// and how many were left out by the crawl's friend sampling
+	SampledEdges int
+	SkippedEdges int
+
+	FriendsPerLevel  map[int]int
+	TotalFriends     int
+	ReachableFriends int
+
+	// SeedNames holds the username of each seed user
+	// when the graph was merged from several seeds
+	SeedNames []string
+
+	// NodeSteamIDs maps the name of each node to the steamID of its user
+	NodeSteamIDs map[string]string
+	// Game is the game overlay applied to the graph, if any
+	Game *GameOverlay
+}
+
+// GameOverlay picks out the users in a graph who have a game
+type GameOverlay struct {
+	AppID int
+	// Label is the legend entry the users with the game are shown under
+	Label string
+	// Owners holds the steamIDs of the users who have the game
+	Owners map[string]bool
+	// Filter removes everyone without the game from the graph apart from seed users
+	Filter bool
+}
+
+// graphResult is handed back by a graphWorker once a
+// user's friends have been read from cache
+type graphResult struct {
+	job     infoStruct
+	friends []infoStruct
+	err     error
 }
 
 // graphWorker is the graphing worker queue implementation. It's quite similar to
 // the crawling worker in the worker module but this is purely for graphing
-func graphWorker(id int, jobs <-chan infoStruct, results chan<- infoStruct, wConfig *workerConfig, gConfig *graphConfig, wg *sync.WaitGroup, activeJobs *int64, levelCap int) {
-	for {
+func graphWorker(ctx context.Context, jobs <-chan infoStruct, results chan<- graphResult, skipped map[string]map[string]bool, wg *sync.WaitGroup) {
+	defer wg.Done()
 
-		wConfig.jobMutex.Lock()
-		job := <-jobs
-		wConfig.jobMutex.Unlock()
-		rand.Seed(time.Now().UTC().UnixNano())
-
-		if job.level != 0 {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-jobs:
+			if !ok {
+				return
+			}
+			// Users that failed to be crawled have no cache
+			// file and are left in the graph as a leaf
+			result := graphResult{job: job}
+			if !CacheExists(job.steamID) {
+				results <- result
+				continue
+			}
 			friendsObj, err := GetCache(job.steamID)
-			CheckErr(err)
-
-			friendCount := len(friendsObj.FriendsList.Friends)
+			if err != nil {
+				result.err = util.MakeErr(err, fmt.Sprintf("failed to read the cache of %s", job.steamID))
+				results <- result
+				continue
+			}
 
-			// Iterate through the user's friendlist and add them onto the
+			// Hand the user's friendlist back on the
 			// results channel for future processing.
-			for i := 0; i < friendCount; i++ {
-				tempStruct := infoStruct{
+			for _, friend := range friendsObj.FriendsList.Friends {
+				result.friends = append(result.friends, infoStruct{
 					level:    job.level + 1,
 					from:     job.username,
-					steamID:  friendsObj.FriendsList.Friends[i].Steamid,
-					username: friendsObj.FriendsList.Friends[i].Username,
-				}
-
-				if tempStruct.level <= levelCap {
-					atomic.AddInt64(activeJobs, 1)
-				}
-
-				wConfig.resMutex.Lock()
-				results <- tempStruct
-				wConfig.resMutex.Unlock()
+					steamID:  friend.Steamid,
+					username: friend.Username,
+					private:  util.IsPrivateProfile(friend.CommunityVisibilityState),
+					skipped:  skipped[job.steamID][friend.Steamid],
+				})
 			}
-			wConfig.activeJobsMutex.Lock()
-			atomic.AddInt64(activeJobs, -1)
-			wConfig.activeJobsMutex.Unlock()
-			wg.Done()
+			results <- result
 		}
 	}
 }
 
-// CrawlCachedFriends builds the graph structure from cached users
-func CrawlCachedFriends(cntr util.ControllerInterface, level, workers int, steamID, username string) *GraphData {
-	jobs := make(chan infoStruct, 500000)
-	results := make(chan infoStruct, 500000)
+// CrawlCachedFriends builds the graph structure from cached users. Friends the crawl skipped
+// when sampling are graphed as leaves. If ctx is cancelled the graph built up until then
+// is returned along with the error
+func CrawlCachedFriends(ctx context.Context, cntr util.ControllerInterface, level, workers int, steamID, username string, skipped util.SkippedFriends) (*GraphData, error) {
+	// Users waiting to be graphed are kept in a queue that spills to
+	// disk and are handed to the workers from it one at a time
+	frontier := queue.New(configuration.AppConfig.FrontierLocation, 0)
+	defer frontier.Close()
+	jobs := make(chan infoStruct)
+	results := make(chan graphResult, workers*2)
 
 	var wg sync.WaitGroup
-	var jobMutex sync.Mutex
-	var resMutex sync.Mutex
-	var activeJobsMutex sync.Mutex
-
-	wConfig := workerConfig{
-		wg:              &wg,
-		jobMutex:        &jobMutex,
-		resMutex:        &resMutex,
-		activeJobsMutex: &activeJobsMutex,
-	}
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
 
 	logMsg := ""
 
@@ -119,12 +179,26 @@ func CrawlCachedFriends(cntr util.ControllerInterface, level, workers int, steam
 		existingNodes: existingNodes,
 	}
 
-	var activeJobs int64 = 0
 	levelCap := level
 	friendsPerLevel := make(map[int]int)
 
+	skippedSet := make(map[string]map[string]bool, len(skipped))
+	for user, friends := range skipped {
+		skippedSet[user] = make(map[string]bool, len(friends))
+		for _, friend := range friends {
+			skippedSet[user][friend] = true
+		}
+	}
+	// nodeIndexes is used to move a node out of the skipped category
+	// if it is reached through a link that was followed
+	nodeIndexes := make(map[string]int)
+	nodeSteamIDs := map[string]string{username: steamID}
+	sampledEdges := 0
+	skippedEdges := 0
+
+	wg.Add(workers * 2)
 	for i := 0; i < workers*2; i++ {
-		go graphWorker(i, jobs, results, &wConfig, &gConfig, &wg, &activeJobs, levelCap)
+		go graphWorker(workersCtx, jobs, results, skippedSet, &wg)
 	}
 
 	tempStruct := infoStruct{
@@ -149,11 +223,12 @@ func CrawlCachedFriends(cntr util.ControllerInterface, level, workers int, steam
 	gConfig.existingNodes[tempStruct.username] = true
 	// Give the original user a black colored node to stand out
 	specColor := charts.ItemStyleOpts{Color: "#000000"}
-	gConfig.nodes = append(gConfig.nodes, charts.GraphNode{Name: tempStruct.username, ItemStyle: specColor})
+	gConfig.nodes = append(gConfig.nodes, charts.GraphNode{Name: tempStruct.username, ItemStyle: specColor, Category: publicCategory})
 
-	wg.Add(1)
-	activeJobs++
-	jobs <- tempStruct
+	// pendingJobs is the amount of users placed onto the jobs
+	// queue that haven't had their friends handed back yet
+	pendingJobs := 1
+	graphErr := frontier.Push(tempStruct)
 	friendsPerLevel[1]++
 
 	reachableFriends := 0
@@ -161,54 +236,114 @@ func CrawlCachedFriends(cntr util.ControllerInterface, level, workers int, steam
 
 	graph := charts.NewGraph()
 
-	for {
-		if activeJobs == 0 {
-			break
+	var nextJob infoStruct
+	hasNextJob := false
+	for pendingJobs > 0 && graphErr == nil {
+		if !hasNextJob {
+			nextJob = infoStruct{}
+			hasNextJob, graphErr = frontier.Pop(&nextJob)
+			if graphErr != nil {
+				break
+			}
+		}
+		// Sending on a nil channel blocks forever so jobs
+		// are only handed out while there's one waiting
+		var jobsOut chan<- infoStruct
+		if hasNextJob {
+			jobsOut = jobs
 		}
-		result := <-results
-		totalFriends++
-		friendsPerLevel[result.level]++
 
-		if result.level <= levelCap {
-			reachableFriends++
+		select {
+		case jobsOut <- nextJob:
+			hasNextJob = false
 
-			if exists := NodeExists(result.username, existingNodes); !exists {
-				gConfig.existingNodes[result.username] = true
-				gConfig.nodes = append(gConfig.nodes, charts.GraphNode{Name: result.username})
+		case <-ctx.Done():
+			graphErr = ctx.Err()
 
-				users[usersCount] = result.username
-				dijkstraGraph.AddVertex(usersCount)
-				usersCount++
+		case jobResult := <-results:
+			pendingJobs--
+			if jobResult.err != nil {
+				graphErr = jobResult.err
+				break
 			}
-			gConfig.links = append(gConfig.links, charts.GraphLink{Source: result.from, Target: result.username})
 
-			fromNum, ok := GetKeyFromValue(users, result.from)
-			if !ok {
-				log.Fatal("BAD THIS SHOULD NEVER HAPPEN")
-			}
-			dijkstraGraph.AddArc(fromNum, usersCount-1, 1)
-			dijkstraGraph.AddArc(usersCount-1, fromNum, 1)
-
-			logMsg += fmt.Sprintf("[%d] %s[%s] -> %s[%s]\n", result.level, result.from, result.steamID, result.username, result.steamID)
-			newJob := infoStruct{
-				level:    result.level,
-				steamID:  result.steamID,
-				from:     result.from,
-				username: result.username,
-			}
-			wg.Add(1)
-			if newJob.from == "" {
-				log.Fatalf("Empty job caught: %+v", newJob)
+			for _, result := range jobResult.friends {
+				totalFriends++
+				friendsPerLevel[result.level]++
+
+				if result.level > levelCap {
+					continue
+				}
+				reachableFriends++
+
+				category := publicCategory
+				if result.private {
+					category = privateCategory
+				} else if result.skipped {
+					category = skippedCategory
+				}
+				if result.skipped {
+					skippedEdges++
+				} else {
+					sampledEdges++
+				}
+
+				if exists := NodeExists(result.username, existingNodes); !exists {
+					gConfig.existingNodes[result.username] = true
+					nodeIndexes[result.username] = len(gConfig.nodes)
+					nodeSteamIDs[result.username] = result.steamID
+					gConfig.nodes = append(gConfig.nodes, charts.GraphNode{Name: result.username, Category: category})
+
+					users[usersCount] = result.username
+					dijkstraGraph.AddVertex(usersCount)
+					usersCount++
+				} else if index, ok := nodeIndexes[result.username]; ok && gConfig.nodes[index].Category == skippedCategory {
+					gConfig.nodes[index].Category = category
+				}
+				gConfig.links = append(gConfig.links, charts.GraphLink{Source: result.from, Target: result.username})
+
+				fromNum, ok := GetKeyFromValue(users, result.from)
+				if !ok {
+					log.Fatal("BAD THIS SHOULD NEVER HAPPEN")
+				}
+				dijkstraGraph.AddArc(fromNum, usersCount-1, 1)
+				dijkstraGraph.AddArc(usersCount-1, fromNum, 1)
+
+				logMsg += fmt.Sprintf("[%d] %s[%s] -> %s[%s]\n", result.level, result.from, result.steamID, result.username, result.steamID)
+				newJob := infoStruct{
+					level:    result.level,
+					steamID:  result.steamID,
+					from:     result.from,
+					username: result.username,
+				}
+				if newJob.from == "" {
+					log.Fatalf("Empty job caught: %+v", newJob)
+				}
+				// Private users and users skipped by sampling
+				// are never crawled so they're left as leaves
+				if result.private || result.skipped {
+					continue
+				}
+				pendingJobs++
+				if err := frontier.Push(newJob); err != nil {
+					graphErr = err
+					break
+				}
 			}
-			jobs <- newJob
 		}
-
 	}
 
-	wg.Wait()
-	logMsg += "\n============== Done ==============\n"
+	// Workers can be part way through sending a result so
+	// results are thrown away until every worker has stopped
+	stopWorkers()
 	close(jobs)
-	close(results)
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for range results {
+	}
+	logMsg += "\n============== Done ==============\n"
 
 	gData := &GraphData{
 		SteamID:      steamID,
@@ -218,10 +353,19 @@ func CrawlCachedFriends(cntr util.ControllerInterface, level, workers int, steam
 
 		UsersMap:      users,
 		DijkstraGraph: dijkstraGraph,
+
+		SampledEdges: sampledEdges,
+		SkippedEdges: skippedEdges,
+
+		FriendsPerLevel:  friendsPerLevel,
+		TotalFriends:     totalFriends,
+		ReachableFriends: reachableFriends,
+
+		NodeSteamIDs: nodeSteamIDs,
 	}
 	logFileName := fmt.Sprintf("%s/%s.txt", configuration.AppConfig.LogsFolderLocation, configuration.AppConfig.UrlMap[steamID])
 	logging.SpecialLog(cntr, logFileName, logMsg)
-	return gData
+	return gData, graphErr
 }
 
 func mergeUsersMaps(startUsersMap, endUsersMap map[int]string) map[int]string {
@@ -331,11 +475,25 @@ func MergeDijkstraGraphs(startUserGraph, endUserGraph *dijkstra.Graph, startUser
 
 // Render generates the HTML graph output
 func (gData *GraphData) Render(fileName string) error {
-	gData.EchartsGraph.SetGlobalOptions(charts.TitleOpts{Title: "Yop the ladeens 薄煎饼"},
-		charts.InitOpts{Width: "1800px", Height: "1080px"})
+	subtitle := gData.Note
+	if gData.SkippedEdges > 0 {
+		if subtitle != "" {
+			subtitle += "\n"
+		}
+		subtitle += fmt.Sprintf("Friend sampling followed %d links and skipped %d", gData.SampledEdges, gData.SkippedEdges)
+	}
+	categories, colors := gData.categories()
+	legend := make([]string, 0, len(categories))
+	for _, category := range categories {
+		legend = append(legend, category.Name)
+	}
+	gData.EchartsGraph.SetGlobalOptions(charts.TitleOpts{Title: "Yop the ladeens 薄煎饼", Subtitle: subtitle},
+		charts.InitOpts{Width: "1800px", Height: "1080px"},
+		charts.LegendOpts{Show: true, Data: legend},
+		colors)
 
 	gData.EchartsGraph.Add("graph", gData.Nodes, gData.Links,
-		charts.GraphOpts{Layout: "force", Roam: true, Force: charts.GraphForce{Repulsion: 34, Gravity: 0.16}, FocusNodeAdjacency: true},
+		charts.GraphOpts{Layout: "force", Roam: true, Force: charts.GraphForce{Repulsion: 34, Gravity: 0.16}, FocusNodeAdjacency: true, Categories: categories},
 		charts.EmphasisOpts{Label: charts.LabelTextOpts{Show: true, Position: "left", Color: "black"}},
 		charts.LineStyleOpts{Width: 1, Color: "#b5b5b5"},
 	)
@@ -351,14 +509,150 @@ func (gData *GraphData) Render(fileName string) error {
 	return nil
 }
 
-// InitGraphing kicks off the graphing process
-func InitGraphing(cntr util.ControllerInterface, level, workers int, steamID string) (*GraphData, error) {
+// categories returns the graph's categories and their colors. Graphs merged from
+// several seeds have a category for shared friends and one for each seed
+func (gData *GraphData) categories() ([]*charts.GraphCategory, charts.ColorOpts) {
+	categories := append([]*charts.GraphCategory{}, graphCategories...)
+	colors := append(charts.ColorOpts{}, categoryColors...)
+	if len(gData.SeedNames) >= 2 {
+		categories = append(categories, &charts.GraphCategory{Name: "Shared friend"})
+		colors = append(colors, sharedColor)
+		for i, seedName := range gData.SeedNames {
+			categories = append(categories, &charts.GraphCategory{Name: seedName})
+			colors = append(colors, seedColor(i))
+		}
+	}
+	// A game overlay's category always comes last
+	if gData.Game != nil {
+		categories = append(categories, &charts.GraphCategory{Name: gData.Game.Label})
+		colors = append(colors, gameColor)
+	}
+	return categories, colors
+}
+
+// ApplyGameOverlay puts the users who have the overlay's game into their own category so
+// that they stand out. Seed users keep their own colors. If the overlay filters the graph
+// everyone else is removed along with their links. It returns how many users have the game
+func (gData *GraphData) ApplyGameOverlay(overlay GameOverlay) int {
+	gData.Game = &overlay
+	categories, _ := gData.categories()
+	gameCategory := len(categories) - 1
+
+	seeds := make(map[string]bool)
+	for _, seedName := range gData.SeedNames {
+		seeds[seedName] = true
+	}
+	if len(gData.Nodes) > 0 {
+		seeds[gData.Nodes[0].Name] = true
+	}
+
+	owners := 0
+	kept := make(map[string]bool, len(gData.Nodes))
+	nodes := make([]charts.GraphNode, 0, len(gData.Nodes))
+	for _, node := range gData.Nodes {
+		hasGame := overlay.Owners[gData.NodeSteamIDs[node.Name]]
+		if hasGame {
+			owners++
+			if !seeds[node.Name] {
+				node.Category = gameCategory
+				node.ItemStyle = charts.ItemStyleOpts{Color: gameColor}
+			}
+		} else if overlay.Filter && !seeds[node.Name] {
+			continue
+		}
+		kept[node.Name] = true
+		nodes = append(nodes, node)
+	}
+	gData.Nodes = nodes
+
+	if overlay.Filter {
+		links := make([]charts.GraphLink, 0, len(gData.Links))
+		for _, link := range gData.Links {
+			source, _ := link.Source.(string)
+			target, _ := link.Target.(string)
+			if kept[source] && kept[target] {
+				links = append(links, link)
+			}
+		}
+		gData.Links = links
+	}
+	return owners
+}
+
+func seedColor(seed int) string {
+	return seedColors[seed%len(seedColors)]
+}
+
+// MergeGraphs merges the graphs of several seed users into one graph. Each seed is given
+// its own category and color and users that are in more than one seed's graph are put in
+// the shared friend category so that they stand out
+func MergeGraphs(graphs ...*GraphData) *GraphData {
+	merged := &GraphData{
+		EchartsGraph:    charts.NewGraph(),
+		ApplyDijkstra:   true,
+		UsersMap:        make(map[int]string),
+		DijkstraGraph:   dijkstra.NewGraph(),
+		FriendsPerLevel: make(map[int]int),
+		NodeSteamIDs:    make(map[string]string),
+	}
+	nodeLists := make([][]charts.GraphNode, 0, len(graphs))
+	seedOf := make(map[string]int)
+	// graphsIn counts how many of the seeds' graphs each user is in
+	graphsIn := make(map[string]int)
+	for i, gData := range graphs {
+		nodeLists = append(nodeLists, gData.Nodes)
+		if len(gData.Nodes) > 0 {
+			merged.SeedNames = append(merged.SeedNames, gData.Nodes[0].Name)
+			seedOf[gData.Nodes[0].Name] = i
+		}
+		for _, node := range gData.Nodes {
+			graphsIn[node.Name]++
+		}
+		merged.Links = append(merged.Links, gData.Links...)
+		for name, steamID := range gData.NodeSteamIDs {
+			merged.NodeSteamIDs[name] = steamID
+		}
+
+		if i == 0 {
+			merged.DijkstraGraph, merged.UsersMap = gData.DijkstraGraph, gData.UsersMap
+		} else {
+			merged.DijkstraGraph, merged.UsersMap = MergeDijkstraGraphs(merged.DijkstraGraph, gData.DijkstraGraph, merged.UsersMap, gData.UsersMap)
+		}
+		merged.SampledEdges += gData.SampledEdges
+		merged.SkippedEdges += gData.SkippedEdges
+		merged.TotalFriends += gData.TotalFriends
+		merged.ReachableFriends += gData.ReachableFriends
+		for level, friends := range gData.FriendsPerLevel {
+			merged.FriendsPerLevel[level] += friends
+		}
+	}
+
+	merged.Nodes = MergeNodes(nodeLists...)
+	for i, node := range merged.Nodes {
+		if seed, isSeed := seedOf[node.Name]; isSeed {
+			merged.Nodes[i].Category = firstSeedCategory + seed
+			merged.Nodes[i].ItemStyle = charts.ItemStyleOpts{Color: seedColor(seed)}
+		} else if graphsIn[node.Name] > 1 {
+			merged.Nodes[i].Category = sharedCategory
+			merged.Nodes[i].ItemStyle = charts.ItemStyleOpts{Color: sharedColor}
+			merged.Nodes[i].SymbolSize = 15
+		}
+	}
+	return merged
+}
+
+// InitGraphing kicks off the graphing process. skipped holds the friends the crawl
+// left out when sampling and may be nil if every friend was followed
+func InitGraphing(ctx context.Context, cntr util.ControllerInterface, level, workers int, steamID string, skipped util.SkippedFriends) (*GraphData, error) {
 	logMsg := ""
 	logMsg += "=============================================\n"
 	logMsg += "                GRAPHING\n\n"
 	logFileName := fmt.Sprintf("%s/%s.txt", configuration.AppConfig.LogsFolderLocation, configuration.AppConfig.UrlMap[steamID])
 	logging.SpecialLog(cntr, logFileName, logMsg)
 	username, err := GetUsernameFromCacheFile(steamID)
+	if err != nil {
+		return nil, err
+	}
 
-	return CrawlCachedFriends(cntr, level, workers, steamID, username), err
+	return CrawlCachedFriends(ctx, cntr, level, workers, steamID, username, skipped)
 }
@@ -3,12 +3,20 @@
 package graphing
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-echarts/go-echarts/charts"
+	dijkstra "github.com/iamcathal/dijkstra2"
 	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/util"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestMain(m *testing.M) {
@@ -69,6 +77,91 @@ func TestMergeNodes(t *testing.T) {
 	assert.Equal(t, expectedNodes, actualNodeNames)
 }
 
+func TestMergeGraphsHighlightsSeedsAndSharedFriends(t *testing.T) {
+	newGraph := func(names ...string) *GraphData {
+		gData := &GraphData{
+			UsersMap:      make(map[int]string),
+			DijkstraGraph: dijkstra.NewGraph(),
+			TotalFriends:  len(names) - 1,
+		}
+		for _, name := range names {
+			gData.Nodes = append(gData.Nodes, charts.GraphNode{Name: name, Category: publicCategory})
+			gData.Links = append(gData.Links, charts.GraphLink{Source: names[0], Target: name})
+		}
+		return gData
+	}
+
+	merged := MergeGraphs(
+		newGraph("Cathal", "Joe", "Declan"),
+		newGraph("Michael", "Declan", "Johnny"),
+		newGraph("Mairtin", "Cathal", "Johnny"),
+	)
+
+	categories := make(map[string]interface{})
+	for _, node := range merged.Nodes {
+		categories[node.Name] = node.Category
+	}
+	assert.Equal(t, map[string]interface{}{
+		"Cathal":  firstSeedCategory,
+		"Michael": firstSeedCategory + 1,
+		"Mairtin": firstSeedCategory + 2,
+		"Declan":  sharedCategory,
+		"Johnny":  sharedCategory,
+		"Joe":     publicCategory,
+	}, categories)
+	assert.Equal(t, []string{"Cathal", "Michael", "Mairtin"}, merged.SeedNames)
+	assert.Len(t, merged.Links, 9)
+	assert.Equal(t, 6, merged.TotalFriends)
+
+	graphCategories, colors := merged.categories()
+	assert.Len(t, graphCategories, firstSeedCategory+3)
+	assert.Len(t, colors, firstSeedCategory+3)
+	assert.Equal(t, "Michael", graphCategories[firstSeedCategory+1].Name)
+}
+
+func TestGameOverlayHighlightsAndFiltersOwners(t *testing.T) {
+	newGraph := func() *GraphData {
+		gData := &GraphData{NodeSteamIDs: make(map[string]string)}
+		for i, name := range []string{"Cathal", "Joe", "Declan", "Michael"} {
+			gData.Nodes = append(gData.Nodes, charts.GraphNode{Name: name, Category: publicCategory})
+			gData.NodeSteamIDs[name] = fmt.Sprintf("7656119800000000%d", i)
+		}
+		gData.Links = []charts.GraphLink{
+			{Source: "Cathal", Target: "Joe"},
+			{Source: "Cathal", Target: "Declan"},
+			{Source: "Declan", Target: "Michael"},
+		}
+		return gData
+	}
+	overlay := GameOverlay{
+		AppID: 730,
+		Label: "Owns Counter-Strike",
+		// The seed user Cathal owning the game doesn't change their node
+		Owners: map[string]bool{"76561198000000000": true, "76561198000000001": true, "76561198000000003": true},
+	}
+
+	gData := newGraph()
+	assert.Equal(t, 3, gData.ApplyGameOverlay(overlay))
+	categories, colors := gData.categories()
+	gameCategory := len(categories) - 1
+	assert.Equal(t, "Owns Counter-Strike", categories[gameCategory].Name)
+	assert.Equal(t, gameColor, colors[gameCategory])
+	assert.Equal(t, publicCategory, gData.Nodes[0].Category)
+	assert.Equal(t, gameCategory, gData.Nodes[1].Category)
+	assert.Equal(t, publicCategory, gData.Nodes[2].Category)
+	assert.Len(t, gData.Links, 3)
+
+	overlay.Filter = true
+	gData = newGraph()
+	assert.Equal(t, 3, gData.ApplyGameOverlay(overlay))
+	names := []string{}
+	for _, node := range gData.Nodes {
+		names = append(names, node.Name)
+	}
+	assert.Equal(t, []string{"Cathal", "Joe", "Michael"}, names)
+	assert.Equal(t, []charts.GraphLink{{Source: "Cathal", Target: "Joe"}}, gData.Links)
+}
+
 func TestNodeExistsInt(t *testing.T) {
 	targetID := 6
 	nodeMap := make(map[int]bool, 0)
@@ -142,3 +235,48 @@ func TestMergeUsersMaps(t *testing.T) {
 // 	graphData.Render(fmt.Sprintf("%s/../../finishedGraphs/testerGraph2", os.Getenv("BWD")))
 // 	os.Remove(fmt.Sprintf("%s/../../finishedGraphs/testerGraph2.html", os.Getenv("BWD")))
 // }
+
+func TestInfoStructSurvivesBeingSpilledToDisk(t *testing.T) {
+	info := infoStruct{level: 2, steamID: "76561198000000002", username: "Joe", from: "Cathal", skipped: true}
+
+	encoded, err := json.Marshal(info)
+	assert.Nil(t, err)
+	decoded := infoStruct{}
+	err = json.Unmarshal(encoded, &decoded)
+	assert.Nil(t, err)
+
+	assert.Equal(t, info, decoded)
+}
+
+func TestCrawlCachedFriendsReturnsCacheReadErrors(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	assert.Nil(t, err)
+	defer os.Remove(tempLogFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+
+	cacheFolder := configuration.AppConfig.CacheFolderLocation
+	defer func() { configuration.AppConfig.CacheFolderLocation = cacheFolder }()
+	configuration.AppConfig.CacheFolderLocation = t.TempDir()
+	// A cache file that isn't gzipped can't be read
+	steamID := "76561198000000001"
+	err = ioutil.WriteFile(filepath.Join(configuration.AppConfig.CacheFolderLocation, steamID+".gz"), []byte("not gzipped"), 0644)
+	assert.Nil(t, err)
+
+	_, err = CrawlCachedFriends(context.Background(), mockController, 2, 2, steamID, "Cathal", nil)
+
+	assert.NotNil(t, err)
+}
+
+func TestGenerateReportPageReturnsTemplateErrors(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	templateDirectory := configuration.AppConfig.TemplateDirectory
+	defer func() { configuration.AppConfig.TemplateDirectory = templateDirectory }()
+	// There is no report page template in an empty folder
+	configuration.AppConfig.TemplateDirectory = t.TempDir()
+
+	err := GenerateReportPage(mockController, "graphID", nil, "")
+
+	assert.NotNil(t, err)
+	mockController.AssertNotCalled(t, "CreateFile", mock.Anything)
+}
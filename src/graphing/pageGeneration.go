@@ -8,31 +8,46 @@ import (
 	"github.com/steamFriendsGraphing/util"
 )
 
-type graphPageInfo struct {
-	GraphCode string
-	ID        string
+type reportPageInfo struct {
+	ID       string
+	Failures util.CrawlFailures
+	Note     string
 }
 
-func GenerateGraphPage(cntr util.ControllerInterface, ID string) error {
-	graphData := graphPageInfo{
-		GraphCode: "",
-		ID:        ID,
+// ReportPageLocation is where the report page of a finished graph is saved.
+// It sits beside the graph rather than replacing it
+func ReportPageLocation(ID string) string {
+	return fmt.Sprintf("%s/%s.report.html", configuration.AppConfig.FinishedGraphsLocation, ID)
+}
+
+// GenerateReportPage generates the report page for a finished graph listing any
+// users that couldn't be crawled along with a note if the graph is partial
+func GenerateReportPage(cntr util.ControllerInterface, ID string, failures util.CrawlFailures, note string) (err error) {
+	reportData := reportPageInfo{
+		ID:       ID,
+		Failures: failures,
+		Note:     note,
 	}
-	fullFilename := fmt.Sprintf("%s/%s.html", configuration.AppConfig.FinishedGraphsLocation, ID)
-	templateLocation := fmt.Sprintf("%s/graphPage.html", configuration.AppConfig.TemplateDirectory)
+	templateLocation := fmt.Sprintf("%s/reportPage.html", configuration.AppConfig.TemplateDirectory)
 
 	tmpl, err := template.ParseFiles(templateLocation)
-
-	file, err := cntr.CreateFile(fullFilename)
 	if err != nil {
 		return util.MakeErr(err)
 	}
 
-	err = tmpl.Execute(file, graphData)
+	file, err := cntr.CreateFile(ReportPageLocation(ID))
 	if err != nil {
 		return util.MakeErr(err)
 	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = util.MakeErr(closeErr)
+		}
+	}()
 
-	file.Close()
+	err = tmpl.Execute(file, reportData)
+	if err != nil {
+		return util.MakeErr(err)
+	}
 	return nil
 }
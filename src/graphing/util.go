@@ -27,6 +27,8 @@ type IndivFriend struct {
 	// FriendSince is the unix timestamp of when the friend request was accepted
 	Username string `json:"username"`
 	// Username is steam public username
+	CommunityVisibilityState int `json:"communityvisibilitystate,omitempty"`
+	// CommunityVisibilityState is the visibility of the friend's profile
 }
 
 // FriendsStruct is messy but it holds the array of all friends for a given user
@@ -72,6 +74,47 @@ type infoStruct struct {
 	steamID  string
 	username string
 	from     string
+	private  bool
+	// skipped is set if the crawl's friend sampling didn't follow this user
+	skipped bool
+}
+
+// queuedInfo is how an infoStruct is saved when
+// the graphing frontier is spilled to disk
+type queuedInfo struct {
+	Level    int    `json:"level"`
+	SteamID  string `json:"steamID"`
+	Username string `json:"username"`
+	From     string `json:"from"`
+	Private  bool   `json:"private,omitempty"`
+	Skipped  bool   `json:"skipped,omitempty"`
+}
+
+func (info infoStruct) MarshalJSON() ([]byte, error) {
+	return json.Marshal(queuedInfo{
+		Level:    info.level,
+		SteamID:  info.steamID,
+		Username: info.username,
+		From:     info.from,
+		Private:  info.private,
+		Skipped:  info.skipped,
+	})
+}
+
+func (info *infoStruct) UnmarshalJSON(data []byte) error {
+	queued := queuedInfo{}
+	if err := json.Unmarshal(data, &queued); err != nil {
+		return err
+	}
+	*info = infoStruct{
+		level:    queued.Level,
+		steamID:  queued.SteamID,
+		username: queued.Username,
+		from:     queued.From,
+		private:  queued.Private,
+		skipped:  queued.Skipped,
+	}
+	return nil
 }
 
 // GetCache gets a user's cached records if it exists
@@ -87,7 +130,10 @@ func GetCache(steamID string) (FriendsStruct, error) {
 	}
 	defer file.Close()
 
-	gz, _ := gzip.NewReader(file)
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return FriendsStruct{}, util.MakeErr(err)
+	}
 	defer gz.Close()
 	scanner := bufio.NewScanner(gz)
 	res := ""
@@ -98,6 +144,13 @@ func GetCache(steamID string) (FriendsStruct, error) {
 	return temp, nil
 }
 
+// CacheExists checks if a user has a cache file. Users that failed
+// to be crawled won't have one
+func CacheExists(steamID string) bool {
+	_, err := os.Stat(fmt.Sprintf("%s/%s.gz", configuration.AppConfig.CacheFolderLocation, steamID))
+	return err == nil
+}
+
 // IsEnvVarSet does a simple check to see if an environment
 // variable is set
 func IsEnvVarSet(envvar string) bool {
@@ -121,7 +174,10 @@ func GetUsernameFromCacheFile(steamID string) (string, error) {
 		return "", err
 	}
 
-	gz, _ := gzip.NewReader(file)
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return "", util.MakeErr(err)
+	}
 	defer gz.Close()
 	scanner := bufio.NewScanner(gz)
 	res := ""
@@ -188,26 +244,25 @@ func GetKeyFromValue(userMap map[int]string, value string) (int, bool) {
 	return -1, false
 }
 
-// MergeNodes merges the node lists of the starting and target users. If there are
-// duplicate nodes in the graphing stage then the graphing framework will fail.
-func MergeNodes(firstNodes, secondNodes []charts.GraphNode) []charts.GraphNode {
-	foundNodes := make(map[string]bool)
+// MergeNodes merges the node lists of several seed users. The first node of each list
+// is its seed user. If there are duplicate nodes in the graphing stage then the graphing
+// framework will fail so each user is only kept once. A seed user always keeps their
+// own node so that it stands out
+func MergeNodes(nodeLists ...[]charts.GraphNode) []charts.GraphNode {
+	foundNodes := make(map[string]int)
 	allNodes := make([]charts.GraphNode, 0)
 
-	secondUsername := secondNodes[0].Name
-
-	for _, node := range firstNodes {
-		if node.Name != secondUsername {
-			allNodes = append(allNodes, node)
-			foundNodes[node.Name] = true
-			// fmt.Printf("[%d : %s]\n", len(allNodes), node.Name)
-		}
-	}
-
-	for _, node := range secondNodes {
-		if _, existing := foundNodes[node.Name]; !existing {
-			allNodes = append(allNodes, node)
-			// fmt.Printf("[%d : %s]\n", len(allNodes), node.Name)
+	for _, nodes := range nodeLists {
+		for i, node := range nodes {
+			index, existing := foundNodes[node.Name]
+			if !existing {
+				foundNodes[node.Name] = len(allNodes)
+				allNodes = append(allNodes, node)
+				continue
+			}
+			if i == 0 {
+				allNodes[index] = node
+			}
 		}
 	}
 	return allNodes
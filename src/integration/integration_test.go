@@ -3,6 +3,7 @@
 package integration
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -63,7 +64,7 @@ func TestGetUsernameFromCacheFile(t *testing.T) {
 	apiKeys := getAPIKeysForTesting()
 	jobs := make(chan worker.JobsStruct, 100)
 
-	targetSteamID := "76561198130544932"
+	targetSteamID := util.SteamID(76561198130544932)
 	expectedUsername := "nestororan100"
 
 	firstJob := worker.JobsStruct{
@@ -76,7 +77,7 @@ func TestGetUsernameFromCacheFile(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	usernameOfTargetUser, err := worker.GetUsernameFromCacheFile(cntr, targetSteamID)
+	usernameOfTargetUser, err := worker.GetUsernameFromCacheFile(cntr, targetSteamID.String())
 	assert.Nil(t, err)
 	assert.Equal(t, expectedUsername, usernameOfTargetUser)
 }
@@ -95,7 +96,7 @@ func TestEndToEndCrawlingAndGraphingFunctionalityWithOneUser(t *testing.T) {
 		APIKeys:  getAPIKeysForTesting(),
 	}
 
-	err := worker.CrawlOneUser(targetSteamID, cntr, crawlerConfig)
+	err := worker.CrawlOneUser(context.Background(), targetSteamID, cntr, crawlerConfig)
 
 	assert.Nil(t, err)
 }
@@ -116,7 +117,7 @@ func TestEndToEndCrawlingAndGraphingFunctionalityWithTwoUsers(t *testing.T) {
 		APIKeys:  getAPIKeysForTesting(),
 	}
 
-	err := worker.CrawlTwoUsers(firstTargetSteamID, secondTargetSteamID, mockUrlMap, cntr, crawlerConfig)
+	err := worker.CrawlTwoUsers(context.Background(), firstTargetSteamID, secondTargetSteamID, mockUrlMap, cntr, crawlerConfig)
 
 	assert.Nil(t, err)
 }
@@ -1,32 +1,90 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/queue"
 	"github.com/steamFriendsGraphing/server"
 	"github.com/steamFriendsGraphing/util"
 	"github.com/steamFriendsGraphing/worker"
 )
 
 func main() {
-	level := flag.Int("level", 2, "Level of friends you want to crawl. 1 is just one user, 2 is immediate friends, 3 is mutual friends etc")
+	level := flag.Int("level", worker.DefaultLevel, "Level of friends you want to crawl. 1 is just one user, 2 is immediate friends, 3 is mutual friends etc")
 	statMode := flag.Bool("stat", false, "Perform a simple lookup of one user to retrieve basic profile details ")
 	testKeys := flag.Bool("testkeys", false, "Test if all keys in APIKEYS.txt are valid")
 	workers := flag.Int("workers", 2, "Amount of workers used to crawl")
 	httpserver := flag.Bool("httpserver", false, "Run the application as a HTTP server")
+	resume := flag.String("resume", "", "Resume an interrupted crawl from its last checkpoint using its crawl ID")
+	changes := flag.String("changes", "", "List the changes recorded to the friend lists of a user, given their steamID, or of everyone in a saved graph, given its ID")
+	refresh := flag.String("refresh", "", "Re-crawl a saved graph using its ID, only refetching users whose cached friend lists are older than -maxAge. Graphs saved without crawl stats are re-crawled to the default level")
+	checkpointInterval := flag.Duration("checkpointInterval", 30*time.Second, "How often the state of a crawl is checkpointed to disk")
+	plan := flag.Bool("plan", false, "Estimate the API calls and time a crawl would take from the cache without crawling")
+	pathFirst := flag.Bool("pathFirst", false, "When given two users only search for the shortest paths between them instead of crawling both fully")
+	maxUsers := flag.Int("maxUsers", 0, "Stop the crawl once this many users have been crawled. 0 means no limit")
+	maxAPICalls := flag.Int("maxAPICalls", 0, "Stop the crawl once this many calls have been made to the Steam web API. 0 means no limit")
+	maxDuration := flag.Duration("maxDuration", 0, "Stop the crawl once it has run for this long. 0 means no limit")
+	retries := flag.Int("retries", worker.DefaultMaxRetries, "How many times a user is retried before they're added to the failures report")
+
+	// Friend sampling flags
+	sample := flag.Int("sample", 0, "Only follow the top N friends of each user. 0 follows every friend")
+	sampleBy := flag.String("sampleBy", string(worker.SampleByFriendSince), "How friends are ranked when sampling: friendSince, random or mutual")
+	sampleSeed := flag.Int64("sampleSeed", 1, "Seed used when sampling friends at random")
+
+	// Games enrichment flags
+	games := flag.String("games", "", "Fetch the games of every crawled user once the crawl is done: owned or recent. Empty fetches no games")
+	gameOverlay := flag.Int("gameOverlay", 0, "Highlight the users who have the game with this appID on the graph. 0 means no overlay")
+	gameFilter := flag.Bool("gameFilter", false, "Only keep the seed users and the users who have the -gameOverlay game on the graph")
+	sharedGames := flag.String("sharedGames", "", "List the games most shared within a saved graph, given its ID, or a user's friends, given their steamID, from the cache")
+
+	// Rate limiting flags, 0 means no limit
+	rateLimit := flag.Float64("rateLimit", 0, "Maximum requests per second made to the Steam web API across all API keys")
+	dailyLimit := flag.Int("dailyLimit", 0, "Maximum requests per day made to the Steam web API across all API keys")
+	keyRateLimit := flag.Float64("keyRateLimit", 5, "Maximum requests per second made with each API key")
+	keyDailyLimit := flag.Int("keyDailyLimit", 100000, "Maximum requests per day made with each API key")
+
+	progress := flag.Bool("progress", true, "Draw a progress bar while crawling")
+	// Steam web API flags, a local stand-in or proxy can be used instead
+	steamAPIScheme := flag.String("steamAPIScheme", configuration.DefaultSteamAPIScheme, "Scheme used to call the Steam web API, http or https")
+	steamAPIHost := flag.String("steamAPIHost", configuration.DefaultSteamAPIHost, "Host, with an optional port and path, the Steam web API is called at")
+	steamAPITimeout := flag.Duration("steamAPITimeout", configuration.DefaultSteamAPITimeout, "How long a single call to the Steam web API can take")
+	steamAPIRetries := flag.Int("steamAPIRetries", configuration.DefaultSteamAPIRetries, "How many more times a call to the Steam web API that failed with a server or network error is tried")
+
+	frontierMemory := flag.Int("frontierMemory", queue.DefaultMemoryLimit, "How many queued users are kept in memory before the rest are spilled to disk")
 
 	// Configuratiob flags
 	ignorecache := flag.Bool("ignorecache", false, "Don't read from cache")
+	maxAge := flag.Duration("maxAge", 0, "Refetch cached friend lists older than this. 0 means cached friend lists never go stale")
 	alwaysCrawl := flag.Bool("alwaysCrawl", false, "Crawl any user even if they've been crawled before")
 	flag.Parse()
 
-	cntr := util.Controller{}
 	configuration.InitAndSetConfig("normal", *ignorecache, *alwaysCrawl)
+	configuration.AppConfig.MaxCacheAge = *maxAge
+	if *steamAPIScheme != "http" && *steamAPIScheme != "https" {
+		log.Fatalf("invalid -steamAPIScheme %q, it must be http or https", *steamAPIScheme)
+	}
+	configuration.AppConfig.SteamAPIScheme = *steamAPIScheme
+	configuration.AppConfig.SteamAPIHost = *steamAPIHost
+	configuration.AppConfig.SteamAPITimeout = *steamAPITimeout
+	configuration.AppConfig.SteamAPIRetries = *steamAPIRetries
+
+	// The server shares this controller so it calls the
+	// Steam web API the same way the crawler does
+	cntr := util.Controller{
+		Limiter: util.NewRateLimiter(
+			util.RateLimit{PerSecond: *rateLimit, PerDay: *dailyLimit},
+			util.RateLimit{PerSecond: *keyRateLimit, PerDay: *keyDailyLimit},
+		),
+		HTTP: util.NewHTTPClient(configuration.AppConfig.SteamAPITimeout, configuration.AppConfig.SteamAPIRetries),
+	}
 
 	if *httpserver {
 		server.SetController(cntr)
@@ -34,19 +92,89 @@ func main() {
 		return
 	}
 
+	if *changes != "" {
+		history, err := worker.ChangeHistory(cntr, *changes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printChangeHistory(history)
+		return
+	}
+
+	if *sharedGames != "" {
+		report, err := worker.GamesInCommon(cntr, *sharedGames)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(worker.FormatGamesReport(report, 25))
+		return
+	}
+
 	apiKeys, err := util.GetAPIKeys(cntr)
 	util.CheckErr(err)
 
-	steamIDs, err := util.ExtractSteamIDs(os.Args)
+	sampleRanking, err := worker.ParseSampleBy(*sampleBy)
 	if err != nil {
 		log.Fatal(err)
 	}
+	gamesSource, err := worker.ParseGamesSource(*games)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *gameFilter && *gameOverlay == 0 {
+		log.Fatal("-gameFilter needs -gameOverlay to know which game to filter by")
+	}
+
 	config := worker.CrawlerConfig{
-		Level:    *level,
-		StatMode: *statMode,
-		TestKeys: *testKeys,
-		Workers:  *workers,
-		APIKeys:  apiKeys,
+		Level:              *level,
+		StatMode:           *statMode,
+		TestKeys:           *testKeys,
+		Workers:            *workers,
+		APIKeys:            apiKeys,
+		KeyPool:            util.NewKeyPool(apiKeys),
+		CheckpointInterval: *checkpointInterval,
+		MaxRetries:         *retries,
+		PathFirst:          *pathFirst,
+		MaxUsers:           *maxUsers,
+		MaxAPICalls:        *maxAPICalls,
+		MaxDuration:        *maxDuration,
+		FrontierMemory:     *frontierMemory,
+		Sampling:           worker.SamplingPolicy{TopN: *sample, By: sampleRanking, Seed: *sampleSeed},
+		Plan:               *plan,
+		Games:              gamesSource,
+		GameOverlay:        *gameOverlay,
+		GameFilter:         *gameFilter,
+		RateLimits: worker.PlanLimits{
+			Global: util.RateLimit{PerSecond: *rateLimit, PerDay: *dailyLimit},
+			PerKey: util.RateLimit{PerSecond: *keyRateLimit, PerDay: *keyDailyLimit},
+		},
+	}
+
+	var steamIDs []string
+	if *resume != "" {
+		// The steamIDs and level are taken from the interrupted crawl
+		steamIDs, config.Level, err = worker.GetResumeDetails(*resume)
+		config.Resume = true
+	} else if *refresh != "" {
+		if *maxAge <= 0 {
+			log.Fatal("-refresh needs -maxAge to know which cached friend lists are stale")
+		}
+		// The steamIDs and level are taken from the saved graph
+		steamIDs, config.Level, err = worker.GetRefreshDetails(*refresh)
+		config.Refresh = true
+	} else {
+		// Profile links and custom profile names are resolved to steamIDs
+		if len(flag.Args()) == 0 {
+			log.Fatal("no steamIDs given")
+		}
+		apiKey := ""
+		if len(apiKeys) > 0 {
+			apiKey = apiKeys[0]
+		}
+		steamIDs, err = util.ResolveSteamIDs(context.Background(), cntr, apiKey, flag.Args())
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	if len(os.Args) < 1 {
@@ -69,23 +197,72 @@ func main() {
 		return
 	}
 
-	// If two steamIDs are given and they are the same, treat this as a
-	// single user search
-	if len(steamIDs) == 2 && steamIDs[0] == steamIDs[1] {
-		steamIDs = []string{steamIDs[0]}
+	// Stopping the application mid crawl cancels the crawl so
+	// that it's checkpointed and can be resumed later
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	progressDone := make(chan struct{})
+	if *progress {
+		progressEvents := make(chan worker.ProgressEvent, 100)
+		config.Progress = progressEvents
+		go drawProgress(progressEvents, progressDone)
+		defer func() {
+			close(progressEvents)
+			<-progressDone
+		}()
+	} else {
+		close(progressDone)
 	}
 
-	switch len(steamIDs) {
-	case 1:
-		err := worker.CrawlOneUser(steamIDs[0], cntr, config)
-		if err != nil {
-			panic(err)
+	// Repeated steamIDs are ignored so the same user given
+	// twice is treated as a single user search
+	// A crawl that was stopped early says how to resume it in its error
+	err = worker.CrawlUsers(ctx, steamIDs, configuration.AppConfig.UrlMap, cntr, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("API keys:\n")
+	for _, stats := range config.KeyPool.Stats() {
+		fmt.Printf("\t%s\n", stats)
+	}
+}
+
+// printChangeHistory prints every friend list change, oldest first
+func printChangeHistory(history []worker.FriendListChange) {
+	if len(history) == 0 {
+		fmt.Println("No changes have been recorded")
+		return
+	}
+	for _, change := range history {
+		fmt.Println(change)
+		for _, friend := range change.Added {
+			fmt.Printf("\t+ %s (%s)\n", friend.Username, friend.Steamid)
 		}
-	case 2:
-		err := worker.CrawlTwoUsers(steamIDs[0], steamIDs[1], configuration.AppConfig.UrlMap, cntr, config)
-		if err != nil {
-			panic(err)
+		for _, friend := range change.Removed {
+			fmt.Printf("\t- %s (%s)\n", friend.Username, friend.Steamid)
+		}
+		for _, rename := range change.Renamed {
+			fmt.Printf("\t~ %s -> %s (%s)\n", rename.From, rename.To, rename.SteamID)
 		}
 	}
+}
 
+// drawProgress redraws a progress bar on the same line every time
+// an event comes in until the events channel is closed
+func drawProgress(events <-chan worker.ProgressEvent, done chan<- struct{}) {
+	defer close(done)
+	for event := range events {
+		fmt.Printf("\r\033[K%s", worker.FormatProgressBar(event, 30))
+		if event.Kind == worker.EventCrawlFinished {
+			fmt.Printf("\n")
+		}
+	}
 }
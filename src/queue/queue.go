@@ -0,0 +1,221 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+// DefaultMemoryLimit is how many items a Queue keeps in memory when no limit is given
+const DefaultMemoryLimit = 10000
+
+// Queue is a first in first out queue that keeps a bounded amount of items in memory.
+// Once its tail fills up the tail is spilled to a segment file on disk and segments are
+// read back in, oldest first, as the head is emptied. Items are stored as JSON so
+// anything pushed must be able to be marshalled and unmarshalled
+type Queue struct {
+	mutex sync.Mutex
+	// head holds the next items to be popped and tail holds the newest items
+	// pushed. Everything in between is in segment files on disk
+	head        [][]byte
+	tail        [][]byte
+	segmentSize int
+
+	parentDir    string
+	dir          string
+	firstSegment int
+	nextSegment  int
+	spilled      int
+	length       int
+}
+
+// New creates a queue that keeps at most memoryLimit items in memory. Segment files are
+// written to a temporary directory made inside dir, or the system's temporary directory
+// if dir is empty, the first time the queue spills. A memoryLimit of zero or less means
+// DefaultMemoryLimit
+func New(dir string, memoryLimit int) *Queue {
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultMemoryLimit
+	}
+	// The head and tail can each hold a full segment
+	segmentSize := memoryLimit / 2
+	if segmentSize < 1 {
+		segmentSize = 1
+	}
+	return &Queue{
+		segmentSize: segmentSize,
+		parentDir:   dir,
+	}
+}
+
+// Push adds an item to the back of the queue
+func (queue *Queue) Push(item interface{}) error {
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.tail = append(queue.tail, encoded)
+	queue.length++
+	if len(queue.tail) < queue.segmentSize {
+		return nil
+	}
+	// A full tail only has to go to disk if the head is in use
+	if len(queue.head) == 0 && queue.firstSegment == queue.nextSegment {
+		queue.head, queue.tail = queue.tail, make([][]byte, 0, queue.segmentSize)
+		return nil
+	}
+	return queue.spill()
+}
+
+// Pop removes the item at the front of the queue and unmarshals it into item.
+// It returns false if the queue is empty
+func (queue *Queue) Pop(item interface{}) (bool, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if len(queue.head) == 0 {
+		if queue.firstSegment < queue.nextSegment {
+			if err := queue.load(); err != nil {
+				return false, err
+			}
+		} else {
+			queue.head, queue.tail = queue.tail, queue.head[:0]
+		}
+	}
+	if len(queue.head) == 0 {
+		return false, nil
+	}
+
+	encoded := queue.head[0]
+	queue.head[0] = nil
+	queue.head = queue.head[1:]
+	queue.length--
+	if err := json.Unmarshal(encoded, item); err != nil {
+		return true, util.MakeErr(err)
+	}
+	return true, nil
+}
+
+// Len returns how many items are in the queue
+func (queue *Queue) Len() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.length
+}
+
+// Spilled returns how many items are currently on disk
+func (queue *Queue) Spilled() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.spilled
+}
+
+// Close removes every segment file. The queue must not be used afterwards
+func (queue *Queue) Close() error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.head, queue.tail = nil, nil
+	queue.length, queue.spilled = 0, 0
+	if queue.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(queue.dir)
+	queue.dir = ""
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	return nil
+}
+
+func (queue *Queue) segmentFileName(segment int) string {
+	return filepath.Join(queue.dir, fmt.Sprintf("segment-%08d.jsonl", segment))
+}
+
+// spill writes the tail out to a new segment file
+func (queue *Queue) spill() error {
+	if queue.dir == "" {
+		if queue.parentDir != "" {
+			if err := os.MkdirAll(queue.parentDir, 0755); err != nil {
+				return util.MakeErr(err)
+			}
+		}
+		dir, err := ioutil.TempDir(queue.parentDir, "frontier")
+		if err != nil {
+			return util.MakeErr(err)
+		}
+		queue.dir = dir
+	}
+
+	file, err := os.Create(queue.segmentFileName(queue.nextSegment))
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	writer := bufio.NewWriter(file)
+	for _, encoded := range queue.tail {
+		writer.Write(encoded)
+		writer.WriteByte('\n')
+	}
+	err = writer.Flush()
+	if err != nil {
+		file.Close()
+		return util.MakeErr(err)
+	}
+	err = file.Close()
+	if err != nil {
+		return util.MakeErr(err)
+	}
+
+	queue.nextSegment++
+	queue.spilled += len(queue.tail)
+	queue.tail = make([][]byte, 0, queue.segmentSize)
+	return nil
+}
+
+// load reads the oldest segment file into the head and removes it
+func (queue *Queue) load() error {
+	fileName := queue.segmentFileName(queue.firstSegment)
+	file, err := os.Open(fileName)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	defer file.Close()
+
+	head := make([][]byte, 0, queue.segmentSize)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+		}
+		if len(line) > 0 {
+			head = append(head, line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return util.MakeErr(err)
+		}
+	}
+
+	err = os.Remove(fileName)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	queue.firstSegment++
+	queue.spilled -= len(head)
+	queue.head = head
+	return nil
+}
@@ -0,0 +1,82 @@
+// +build service
+
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testItem struct {
+	ID   int
+	Name string
+}
+
+func TestQueueStaysInOrderWhenSpillingToDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queueTest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	queue := New(dir, 4)
+	defer queue.Close()
+
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, queue.Push(testItem{ID: i, Name: "user"}))
+	}
+	assert.Equal(t, 25, queue.Len())
+	assert.True(t, queue.Spilled() > 0)
+
+	for i := 0; i < 25; i++ {
+		item := testItem{}
+		ok, err := queue.Pop(&item)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, testItem{ID: i, Name: "user"}, item)
+
+		// Pushing while popping must keep items in order
+		if i < 5 {
+			assert.Nil(t, queue.Push(testItem{ID: 25 + i, Name: "user"}))
+		}
+	}
+	for i := 25; i < 30; i++ {
+		item := testItem{}
+		ok, err := queue.Pop(&item)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, i, item.ID)
+	}
+
+	ok, err := queue.Pop(&testItem{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 0, queue.Spilled())
+}
+
+func TestQueueOnlyTouchesDiskOnceMemoryIsFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queueTest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	queue := New(dir, 100)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, queue.Push(testItem{ID: i}))
+	}
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+	assert.Equal(t, 0, queue.Spilled())
+
+	for i := 50; i < 200; i++ {
+		assert.Nil(t, queue.Push(testItem{ID: i}))
+	}
+	files, err = ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+
+	assert.Nil(t, queue.Close())
+	files, err = ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/graphing"
 )
 
 func serveGraph(w http.ResponseWriter, req *http.Request) {
@@ -14,3 +15,9 @@ func serveGraph(w http.ResponseWriter, req *http.Request) {
 	finishedGraphLocation := fmt.Sprintf("%s/%s.html", configuration.AppConfig.FinishedGraphsLocation, path.Clean(vars["id"]))
 	http.ServeFile(w, req, finishedGraphLocation)
 }
+
+// serveReport serves the report page of a finished graph
+func serveReport(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	http.ServeFile(w, req, graphing.ReportPageLocation(path.Clean(vars["id"])))
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/steamFriendsGraphing/worker"
+)
+
+// maxProgressEvents is how many of a crawl's most
+// recent progress events are kept for clients to poll
+const maxProgressEvents = 500
+
+var (
+	// crawlProgress maps the graph identifier of every crawl that is running
+	// or finished within finishedProgressTTL to the progress it has made
+	crawlProgress      = make(map[string]*progressLog)
+	crawlProgressMutex sync.Mutex
+	// finishedProgressTTL is how long the progress of a finished crawl
+	// is kept for clients to poll before it's forgotten
+	finishedProgressTTL = 10 * time.Minute
+)
+
+// progressEntry is a progress event numbered so that clients
+// can ask for only the events they haven't seen yet
+type progressEntry struct {
+	Seq int `json:"seq"`
+	worker.ProgressEvent
+}
+
+// progressLog holds the most recent progress events of a crawl
+type progressLog struct {
+	mutex   sync.Mutex
+	events  []progressEntry
+	nextSeq int
+	running bool
+}
+
+// record reads events until the channel is closed
+// at which point the crawl is marked as finished
+func (tracker *progressLog) record(events <-chan worker.ProgressEvent) {
+	for event := range events {
+		tracker.mutex.Lock()
+		tracker.nextSeq++
+		tracker.events = append(tracker.events, progressEntry{Seq: tracker.nextSeq, ProgressEvent: event})
+		if len(tracker.events) > maxProgressEvents {
+			tracker.events = tracker.events[len(tracker.events)-maxProgressEvents:]
+		}
+		tracker.mutex.Unlock()
+	}
+	tracker.mutex.Lock()
+	tracker.running = false
+	tracker.mutex.Unlock()
+}
+
+// since returns every event kept with a sequence number after seq
+func (tracker *progressLog) since(seq int) ([]progressEntry, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	events := make([]progressEntry, 0)
+	for _, entry := range tracker.events {
+		if entry.Seq > seq {
+			events = append(events, entry)
+		}
+	}
+	return events, tracker.running
+}
+
+// trackProgress returns the channel a crawl publishes its progress on.
+// The channel must be closed once the crawl has finished
+func trackProgress(identifier string) chan worker.ProgressEvent {
+	events := make(chan worker.ProgressEvent, 100)
+	tracker := &progressLog{running: true}
+
+	crawlProgressMutex.Lock()
+	crawlProgress[identifier] = tracker
+	crawlProgressMutex.Unlock()
+
+	ttl := finishedProgressTTL
+	go func() {
+		tracker.record(events)
+		time.AfterFunc(ttl, func() {
+			forgetProgress(identifier, tracker)
+		})
+	}()
+	return events
+}
+
+// forgetProgress removes a finished crawl's progress unless
+// a newer crawl with the same identifier has replaced it
+func forgetProgress(identifier string, tracker *progressLog) {
+	crawlProgressMutex.Lock()
+	defer crawlProgressMutex.Unlock()
+	if crawlProgress[identifier] == tracker {
+		delete(crawlProgress, identifier)
+	}
+}
+
+// crawlProgressEvents returns the progress events of a crawl. Only events newer than
+// the since query parameter are returned so clients can poll for new events
+func crawlProgressEvents(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	since := 0
+	if sinceParam := req.URL.Query().Get("since"); sinceParam != "" {
+		var err error
+		since, err = strconv.Atoi(sinceParam)
+		if err != nil {
+			sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], "since must be a number")
+			return
+		}
+	}
+
+	graphIdentifier, err := worker.GraphIdentifier(strings.Split(vars["steamIDs"], ","))
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], "invalid steamIDs given")
+		return
+	}
+	crawlProgressMutex.Lock()
+	tracker, exists := crawlProgress[graphIdentifier]
+	crawlProgressMutex.Unlock()
+	if !exists {
+		sendErrorResponse(w, req, http.StatusNotFound, vars["startTime"], "no crawl has been started for the steamIDs given")
+		return
+	}
+
+	events, running := tracker.since(since)
+	res := progressResponse{
+		Running: running,
+		Events:  events,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+	LogCall(req, http.StatusOK, vars["startTime"], false)
+}
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -24,6 +26,14 @@ var (
 	// middlewareBlacklist indicates whether
 	// a url is to be ignored by the middleware
 	middlewareBlackList map[string]bool
+	// runningCrawls maps the graph identifier of every crawl
+	// in progress to the function that cancels it
+	runningCrawls      = make(map[string]context.CancelFunc)
+	runningCrawlsMutex sync.Mutex
+	// keyPool is shared by every crawl so the health
+	// of each API key is tracked across crawls
+	keyPool      *util.KeyPool
+	keyPoolMutex sync.Mutex
 )
 
 // SetController sets the controller used for all functions in the server module
@@ -55,6 +65,51 @@ func CrawlMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// getKeyPool returns the API key pool shared by all crawls,
+// reading in the API keys the first time it's called
+func getKeyPool() (*util.KeyPool, error) {
+	keyPoolMutex.Lock()
+	defer keyPoolMutex.Unlock()
+
+	if keyPool == nil {
+		apiKeys, err := util.GetAPIKeys(cntr)
+		if err != nil {
+			return nil, err
+		}
+		keyPool = util.NewKeyPool(apiKeys)
+	}
+	return keyPool, nil
+}
+
+// startCrawl runs a crawl in the background under its graph identifier. The crawl can be stopped
+// through the /cancel endpoint until it has finished and the events it publishes on progress are
+// served by /progress. Nothing is started if a crawl with the same identifier is still running
+func startCrawl(identifier string, crawlFunc func(ctx context.Context, progress chan<- worker.ProgressEvent) error) bool {
+	runningCrawlsMutex.Lock()
+	if _, running := runningCrawls[identifier]; running {
+		runningCrawlsMutex.Unlock()
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	runningCrawls[identifier] = cancel
+	runningCrawlsMutex.Unlock()
+	progress := trackProgress(identifier)
+
+	go func() {
+		err := crawlFunc(ctx, progress)
+		if err != nil {
+			logging.SpecialLog(cntr, "errorLog", err.Error())
+		}
+		close(progress)
+
+		runningCrawlsMutex.Lock()
+		delete(runningCrawls, identifier)
+		runningCrawlsMutex.Unlock()
+		cancel()
+	}()
+	return true
+}
+
 func statLookup(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	apiKeys, err := util.GetAPIKeys(cntr)
@@ -87,12 +142,13 @@ func crawl(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 
 	reqConfig, err := DecodeBody(req, vars)
-	if err != nil {
+	if err != nil || !reqConfig.SteamID0.IsValid() {
 		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], "invalid input")
 		return
 	}
+	steamID := reqConfig.SteamID0.String()
 
-	apiKeys, err := util.GetAPIKeys(cntr)
+	keyPool, err := getKeyPool()
 	util.CheckErr(err)
 
 	statMode := false
@@ -104,18 +160,26 @@ func crawl(w http.ResponseWriter, req *http.Request) {
 	workers, _ := strconv.Atoi(reqConfig.Workers)
 
 	crawlConfig := worker.CrawlerConfig{
-		Level:    level,
-		StatMode: statMode,
-		TestKeys: false,
-		Workers:  workers,
-		APIKeys:  apiKeys,
+		Level:      level,
+		StatMode:   statMode,
+		TestKeys:   false,
+		Workers:    workers,
+		KeyPool:    keyPool,
+		MaxRetries: worker.DefaultMaxRetries,
 	}
 
 	// fmt.Printf("%+v\n", crawlConfig)
 
-	go worker.CrawlOneUser(reqConfig.SteamID0, util.Controller{}, crawlConfig)
+	started := startCrawl(steamID, func(ctx context.Context, progress chan<- worker.ProgressEvent) error {
+		crawlConfig.Progress = progress
+		return worker.CrawlOneUser(ctx, steamID, cntr, crawlConfig)
+	})
+	if !started {
+		sendErrorResponse(w, req, http.StatusConflict, vars["startTime"], "a crawl is already running for the steamIDs given")
+		return
+	}
 
-	finishedGraphLocation := fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, configuration.AppConfig.UrlMap[reqConfig.SteamID0])
+	finishedGraphLocation := fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, configuration.AppConfig.UrlMap[steamID])
 
 	res := struct {
 		Body string
@@ -138,22 +202,41 @@ func crawlOne(w http.ResponseWriter, req *http.Request) {
 		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], err.Error())
 		return
 	}
+	reqConfig, err = resolveSteamIDs(req.Context(), reqConfig, vars)
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], err.Error())
+		return
+	}
 
-	apiKeys, err := util.GetAPIKeys(cntr)
+	keyPool, err := getKeyPool()
 	util.CheckErr(err)
 
 	crawlConfig := worker.CrawlerConfig{
-		Level:    reqConfig.Level,
-		StatMode: false,
-		TestKeys: false,
-		Workers:  4,
-		APIKeys:  apiKeys,
+		Level:      reqConfig.Level,
+		StatMode:   false,
+		TestKeys:   false,
+		Workers:    4,
+		KeyPool:    keyPool,
+		MaxRetries: worker.DefaultMaxRetries,
 	}
 
-	go worker.CrawlOneUser(reqConfig.SteamIDs[0], util.Controller{}, crawlConfig)
+	graphIdentifier, err := worker.GraphIdentifier(util.SteamIDStrings(reqConfig.SteamIDs))
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], "invalid steamIDs given")
+		return
+	}
+
+	started := startCrawl(graphIdentifier, func(ctx context.Context, progress chan<- worker.ProgressEvent) error {
+		crawlConfig.Progress = progress
+		return worker.CrawlUsers(ctx, util.SteamIDStrings(reqConfig.SteamIDs), configuration.AppConfig.UrlMap, cntr, crawlConfig)
+	})
+	if !started {
+		sendErrorResponse(w, req, http.StatusConflict, vars["startTime"], "a crawl is already running for the steamIDs given")
+		return
+	}
 
 	time.Sleep(10 * time.Millisecond)
-	finishedGraphLocation = fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, configuration.AppConfig.UrlMap[reqConfig.SteamIDs[0]])
+	finishedGraphLocation = fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, configuration.AppConfig.UrlMap[graphIdentifier])
 
 	res := struct {
 		Body string
@@ -167,6 +250,50 @@ func crawlOne(w http.ResponseWriter, req *http.Request) {
 	LogCall(req, http.StatusOK, vars["startTime"], false)
 }
 
+// cancelCrawl stops a crawl that is in progress. The crawl is
+// checkpointed so it can be resumed later
+func cancelCrawl(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	reqConfig, err := DecodeNewBody(req, vars)
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], err.Error())
+		return
+	}
+	reqConfig, err = resolveSteamIDs(req.Context(), reqConfig, vars)
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], err.Error())
+		return
+	}
+
+	// Crawls are keyed by their graph identifier so the
+	// steamIDs can be given in any order
+	graphIdentifier, err := worker.GraphIdentifier(util.SteamIDStrings(reqConfig.SteamIDs))
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], "invalid steamIDs given")
+		return
+	}
+	runningCrawlsMutex.Lock()
+	cancel, exists := runningCrawls[graphIdentifier]
+	runningCrawlsMutex.Unlock()
+	if !exists {
+		sendErrorResponse(w, req, http.StatusNotFound, vars["startTime"], "no crawl is running for the steamIDs given")
+		return
+	}
+	cancel()
+
+	res := struct {
+		Body string
+	}{
+		Body: "Crawl cancelled",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+	LogCall(req, http.StatusOK, vars["startTime"], false)
+}
+
 func status(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	res := statusResponse{
@@ -182,6 +309,68 @@ func status(w http.ResponseWriter, req *http.Request) {
 	LogCall(req, http.StatusOK, vars["startTime"], false)
 }
 
+// keyStats returns the usage and health of every API key
+func keyStats(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	pool, err := getKeyPool()
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusInternalServerError, vars["startTime"], "API keys could not be read")
+		logging.SpecialLog(cntr, "errorLog", err.Error())
+		return
+	}
+
+	res := keyStatsResponse{
+		Healthy: pool.Healthy(),
+		Keys:    pool.Stats(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+	LogCall(req, http.StatusOK, vars["startTime"], false)
+}
+
+// changeHistory lists the changes recorded to the friend lists of
+// either a single user or every user in a saved graph
+func changeHistory(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	changes, err := worker.ChangeHistory(cntr, vars["id"])
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], "no graph or user could be found for the ID given")
+		logging.SpecialLog(cntr, "errorLog", err.Error())
+		return
+	}
+
+	res := changesResponse{
+		ID:      vars["id"],
+		Changes: changes,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+	LogCall(req, http.StatusOK, vars["startTime"], false)
+}
+
+// sharedGames reports the games most shared within either a single
+// user's friends or every user in a saved graph
+func sharedGames(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	report, err := worker.GamesInCommon(cntr, vars["id"])
+	if err != nil {
+		sendErrorResponse(w, req, http.StatusBadRequest, vars["startTime"], "no graph or user could be found for the ID given")
+		logging.SpecialLog(cntr, "errorLog", err.Error())
+		return
+	}
+
+	res := gamesResponse{
+		ID:     vars["id"],
+		Report: report,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+	LogCall(req, http.StatusOK, vars["startTime"], false)
+}
+
 func home(w http.ResponseWriter, req *http.Request) {
 	http.ServeFile(w, req, filepath.Join(configuration.AppConfig.StaticDirectoryLocation, "index.html"))
 }
@@ -190,10 +379,16 @@ func setupRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/", home).Methods("GET")
 	r.HandleFunc("/graph/{id}", serveGraph)
+	r.HandleFunc("/graph/{id}/report", serveReport)
 	r.HandleFunc("/crawl", crawl).Methods("POST")
 	r.HandleFunc("/statlookup", statLookup).Methods("POST")
 	r.HandleFunc("/status", status).Methods("POST")
 	r.HandleFunc("/crawlOne", crawlOne).Methods("POST")
+	r.HandleFunc("/cancel", cancelCrawl).Methods("POST")
+	r.HandleFunc("/keys", keyStats).Methods("GET")
+	r.HandleFunc("/progress/{steamIDs}", crawlProgressEvents).Methods("GET")
+	r.HandleFunc("/changes/{id}", changeHistory).Methods("GET")
+	r.HandleFunc("/games/{id}", sharedGames).Methods("GET")
 	r.Use(CrawlMiddleware)
 
 	return r
@@ -3,14 +3,21 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/steamFriendsGraphing/util"
+	"github.com/steamFriendsGraphing/worker"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -61,7 +68,7 @@ func TestStatLookupWithExpectedUserStats(t *testing.T) {
 	file := createValidAPIKEYSFile()
 
 	mockController.On("Open", mock.AnythingOfType("string")).Return(file, nil)
-	mockController.On("CallPlayerSummaryAPI", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedGetPlayerSummaryUser, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedGetPlayerSummaryUser, nil)
 
 	urlVals, _ := url.ParseQuery("steamID0=testSteamID&statmode=true")
 	res := assert.HTTPBody(statLookup, "POST", "/statlookup", urlVals)
@@ -71,3 +78,98 @@ func TestStatLookupWithExpectedUserStats(t *testing.T) {
 
 	assert.Equal(t, expectedUserStats, resStruct)
 }
+
+func TestProgressLogOnlyReturnsNewerEvents(t *testing.T) {
+	events := trackProgress("76561198000000001")
+	events <- worker.ProgressEvent{Kind: worker.EventUserFetched}
+	events <- worker.ProgressEvent{Kind: worker.EventCrawlFinished}
+	close(events)
+
+	assert.Eventually(t, func() bool {
+		_, running := crawlProgress["76561198000000001"].since(0)
+		return !running
+	}, time.Second, time.Millisecond)
+
+	newEvents, _ := crawlProgress["76561198000000001"].since(1)
+	assert.Len(t, newEvents, 1)
+	assert.Equal(t, worker.EventCrawlFinished, newEvents[0].Kind)
+}
+
+func TestFinishedProgressLogIsForgottenAfterItsTTL(t *testing.T) {
+	defer func(ttl time.Duration) { finishedProgressTTL = ttl }(finishedProgressTTL)
+	finishedProgressTTL = time.Millisecond
+
+	events := trackProgress("76561198000000003")
+	events <- worker.ProgressEvent{Kind: worker.EventCrawlFinished}
+	close(events)
+
+	assert.Eventually(t, func() bool {
+		crawlProgressMutex.Lock()
+		defer crawlProgressMutex.Unlock()
+		_, exists := crawlProgress["76561198000000003"]
+		return !exists
+	}, time.Second, time.Millisecond)
+}
+
+func TestForgetProgressKeepsANewerCrawlsProgress(t *testing.T) {
+	finished := &progressLog{}
+	events := trackProgress("76561198000000004")
+	defer close(events)
+
+	forgetProgress("76561198000000004", finished)
+
+	crawlProgressMutex.Lock()
+	_, exists := crawlProgress["76561198000000004"]
+	crawlProgressMutex.Unlock()
+	assert.True(t, exists)
+}
+
+// blockingCrawl starts a crawl that runs until it's cancelled
+func blockingCrawl(identifier string) (bool, <-chan struct{}) {
+	stopped := make(chan struct{})
+	started := startCrawl(identifier, func(ctx context.Context, progress chan<- worker.ProgressEvent) error {
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	})
+	return started, stopped
+}
+
+func TestStartCrawlRejectsACrawlThatIsAlreadyRunning(t *testing.T) {
+	started, stopped := blockingCrawl("76561198000000001")
+	assert.True(t, started)
+	startedAgain, _ := blockingCrawl("76561198000000001")
+	assert.False(t, startedAgain)
+
+	runningCrawlsMutex.Lock()
+	cancel := runningCrawls["76561198000000001"]
+	runningCrawlsMutex.Unlock()
+	cancel()
+	<-stopped
+
+	assert.Eventually(t, func() bool {
+		runningCrawlsMutex.Lock()
+		defer runningCrawlsMutex.Unlock()
+		_, running := runningCrawls["76561198000000001"]
+		return !running
+	}, time.Second, time.Millisecond)
+}
+
+func TestCancelCrawlTakesTheSteamIDsInAnyOrder(t *testing.T) {
+	identifier, err := worker.GraphIdentifier([]string{"76561198000000001", "76561198000000002"})
+	assert.Nil(t, err)
+	started, stopped := blockingCrawl(identifier)
+	assert.True(t, started)
+
+	req := httptest.NewRequest("POST", "/cancel", strings.NewReader(`{"steamIDs": ["76561198000000002", "76561198000000001"]}`))
+	req = mux.SetURLVars(req, map[string]string{})
+	recorder := httptest.NewRecorder()
+	cancelCrawl(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("the crawl wasn't cancelled")
+	}
+}
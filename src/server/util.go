@@ -1,12 +1,16 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/steamFriendsGraphing/util"
+	"github.com/steamFriendsGraphing/worker"
 )
 
 type statusResponse struct {
@@ -14,17 +18,44 @@ type statusResponse struct {
 	Uptime time.Duration `json:"uptime"`
 }
 
+type keyStatsResponse struct {
+	Healthy int             `json:"healthy"`
+	Keys    []util.KeyStats `json:"keys"`
+}
+
+type progressResponse struct {
+	Running bool            `json:"running"`
+	Events  []progressEntry `json:"events"`
+}
+
+type changesResponse struct {
+	ID      string                    `json:"id"`
+	Changes []worker.FriendListChange `json:"changes"`
+}
+
+type gamesResponse struct {
+	ID     string             `json:"id"`
+	Report worker.GamesReport `json:"report"`
+}
+
+// maxSeedUsers is the most seed users a single crawl can be given
+const maxSeedUsers = 10
+
+// requestConfig.Inputs can be steamIDs in any format, profile links or
+// custom profile names. SteamIDs is filled in once they are resolved
 type requestConfig struct {
-	Level    int      `json:"level"`
-	SteamIDs []string `json:"steamIDs"`
+	Level    int            `json:"level"`
+	Inputs   []string       `json:"steamIDs"`
+	SteamIDs []util.SteamID `json:"-"`
 }
 
 type newConfig struct {
 	Level    string `json:"level"`
 	StatMode string `json:"statMode"`
 	Workers  string `json:"workers"`
-	SteamID0 string `json:"steamID0"`
-	SteamID1 string `json:"steamID1"`
+	// SteamID0 and SteamID1 can be given in any format util.ParseSteamID understands
+	SteamID0 util.SteamID `json:"steamID0"`
+	SteamID1 util.SteamID `json:"steamID1"`
 }
 
 // inMiddlewareBlackist checks if an endpoint is blacklisted from
@@ -89,8 +120,8 @@ func DecodeBody(r *http.Request, vars map[string]string) (newConfig, error) {
 	vars["level"] = inputConfig.Level
 	vars["statMode"] = inputConfig.StatMode
 	vars["workers"] = inputConfig.Workers
-	vars["steamID0"] = inputConfig.SteamID0
-	vars["steamID1"] = inputConfig.SteamID1
+	vars["steamID0"] = inputConfig.SteamID0.String()
+	vars["steamID1"] = inputConfig.SteamID1.String()
 	return inputConfig, nil
 }
 
@@ -105,14 +136,49 @@ func DecodeNewBody(r *http.Request, vars map[string]string) (requestConfig, erro
 
 	vars["level"] = strconv.Itoa(reqConfig.Level)
 
-	switch len(reqConfig.SteamIDs) {
-	case 1:
-		vars["steamID0"] = reqConfig.SteamIDs[0]
-	case 2:
-		vars["steamID1"] = reqConfig.SteamIDs[1]
-	default:
+	if len(reqConfig.Inputs) == 0 || len(reqConfig.Inputs) > maxSeedUsers {
 		return requestConfig{}, errors.New("invalid amount of steamIDs given")
 	}
+	for i, steamID := range reqConfig.Inputs {
+		vars[fmt.Sprintf("steamID%d", i)] = steamID
+	}
+
+	return reqConfig, nil
+}
+
+// resolveSteamIDs turns the profile links and custom profile names a request was given
+// into steamIDs and updates the request's steamID vars to match. An API key is only
+// taken from the key pool when a custom profile name isn't cached yet
+func resolveSteamIDs(ctx context.Context, reqConfig requestConfig, vars map[string]string) (requestConfig, error) {
+	apiKey := ""
+	var pool *util.KeyPool
+	if util.NeedsVanityLookup(reqConfig.Inputs) {
+		var err error
+		pool, err = getKeyPool()
+		if err != nil {
+			return reqConfig, err
+		}
+		apiKey, err = pool.Acquire(ctx)
+		if err != nil {
+			return reqConfig, err
+		}
+	}
 
+	steamIDs, err := util.ResolveSteamIDs(ctx, cntr, apiKey, reqConfig.Inputs)
+	if pool != nil {
+		pool.Report(apiKey, err)
+	}
+	if err != nil {
+		return reqConfig, err
+	}
+	reqConfig.SteamIDs = make([]util.SteamID, 0, len(steamIDs))
+	for i, steamID := range steamIDs {
+		id, err := util.ParseSteamID(steamID)
+		if err != nil {
+			return reqConfig, err
+		}
+		reqConfig.SteamIDs = append(reqConfig.SteamIDs, id)
+		vars[fmt.Sprintf("steamID%d", i)] = steamID
+	}
 	return reqConfig, nil
 }
@@ -2,23 +2,34 @@ package util
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	os "os"
+
+	"github.com/steamFriendsGraphing/configuration"
 )
 
-type Controller struct{}
+// Controller is the real implementation of ControllerInterface. Every call
+// to the Steam web API waits on Limiter first if one is set and is made
+// through HTTP, or a default HTTPClient if HTTP isn't set
+type Controller struct {
+	Limiter *RateLimiter
+	HTTP    *HTTPClient
+}
 
 // ControllerInterface defines all methods that are stubbed for
 // service testing due to their dependencies with networks and
 // filesystems
 type ControllerInterface interface {
-	CallPlayerSummaryAPI(steamID, apiKey string) (UserStatsStruct, error)
-	CallIsAPIKeyValidAPI(apiKeys string) (string, error)
-	CallGetFriendsListAPI(steamID, apiKey string) (FriendsStruct, error)
+	CallPlayerSummaryAPI(ctx context.Context, steamID, apiKey string) (UserStatsStruct, error)
+	CallIsAPIKeyValidAPI(ctx context.Context, apiKeys string) (string, error)
+	CallGetFriendsListAPI(ctx context.Context, steamID, apiKey string) (FriendsStruct, error)
+	CallResolveVanityURLAPI(ctx context.Context, vanityName, apiKey string) (ResolveVanityURLStruct, error)
+	CallGetOwnedGamesAPI(ctx context.Context, steamID, apiKey string) (GamesStruct, error)
+	CallGetRecentlyPlayedGamesAPI(ctx context.Context, steamID, apiKey string) (GamesStruct, error)
 
 	FileExists(steamID string) bool
 	Open(fileName string) (*os.File, error)
@@ -27,65 +38,124 @@ type ControllerInterface interface {
 	WriteGzip(file *os.File, content string) error
 }
 
+// get requests targetURL from the Steam web API, waiting on the Limiter for apiKey
+// before every attempt. Responses with any of finalStatuses aren't retried
+func (control Controller) get(ctx context.Context, targetURL, apiKey string, finalStatuses ...int) (int, []byte, error) {
+	httpClient := control.HTTP
+	if httpClient == nil {
+		httpClient = defaultHTTPClient
+	}
+	return httpClient.Get(ctx, targetURL, func() error { return control.Limiter.Wait(ctx, apiKey) }, finalStatuses...)
+}
+
 // CallPlayerSummaryAPI calls the Steam GetPlayerSummary API endpoint
-func (control Controller) CallPlayerSummaryAPI(steamID, apiKey string) (UserStatsStruct, error) {
+func (control Controller) CallPlayerSummaryAPI(ctx context.Context, steamID, apiKey string) (UserStatsStruct, error) {
 	var userStatsObj UserStatsStruct
-	targetURL := fmt.Sprintf("http://api.steampowered.com/ISteamUser/GetPlayerSummaries/v0002/?key=%s&steamids=%s",
-		apiKey, steamID)
-	res, err := GetAndRead(targetURL)
+	targetURL := fmt.Sprintf("%s?key=%s&steamids=%s", configuration.AppConfig.SteamAPIURL("ISteamUser/GetPlayerSummaries/v0002/"),
+		url.QueryEscape(apiKey), url.QueryEscape(steamID))
+	statusCode, body, err := control.get(ctx, targetURL, apiKey)
 	if err != nil {
+		return userStatsObj, err
+	}
+	if err := checkResponse(statusCode, body, apiKey); err != nil {
 		return userStatsObj, MakeErr(err)
 	}
 
-	json.Unmarshal(res, &userStatsObj)
-
+	err = json.Unmarshal(body, &userStatsObj)
+	if err != nil {
+		return userStatsObj, MakeErr(err)
+	}
 	return userStatsObj, nil
 }
 
 // CallIsAPIKeyValidAPI calls the Steam web API and it's response is used to
 // determine if the specified API key is valid
-func (control Controller) CallIsAPIKeyValidAPI(apiKey string) (string, error) {
-	targetURL := fmt.Sprintf("http://api.steampowered.com/ISteamUser/GetFriendList/v0001/?key=%s&steamid=76561198282036055&relationship=friend", url.QueryEscape(apiKey))
-	res, err := http.Get(targetURL)
+func (control Controller) CallIsAPIKeyValidAPI(ctx context.Context, apiKey string) (string, error) {
+	targetURL := fmt.Sprintf("%s?key=%s&steamid=76561198282036055&relationship=friend", configuration.AppConfig.SteamAPIURL("ISteamUser/GetFriendList/v0001/"), url.QueryEscape(apiKey))
+	_, body, err := control.get(ctx, targetURL, apiKey)
 	if err != nil {
-		return "", MakeErr(err)
+		return "", err
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
+	return string(body), nil
+}
+
+// CallResolveVanityURLAPI calls the Steam ResolveVanityURL API endpoint to
+// find the steamID of the account with the given custom profile name
+func (control Controller) CallResolveVanityURLAPI(ctx context.Context, vanityName, apiKey string) (ResolveVanityURLStruct, error) {
+	var resolved ResolveVanityURLStruct
+	targetURL := fmt.Sprintf("%s?key=%s&vanityurl=%s", configuration.AppConfig.SteamAPIURL("ISteamUser/ResolveVanityURL/v0001/"),
+		url.QueryEscape(apiKey), url.QueryEscape(vanityName))
+	statusCode, body, err := control.get(ctx, targetURL, apiKey)
 	if err != nil {
-		return "", MakeErr(err)
+		return resolved, err
+	}
+	if err := checkResponse(statusCode, body, apiKey); err != nil {
+		return resolved, MakeErr(err)
 	}
 
-	return string(body), nil
+	err = json.Unmarshal(body, &resolved)
+	if err != nil {
+		return resolved, MakeErr(err)
+	}
+	return resolved, nil
+}
+
+// CallGetOwnedGamesAPI calls the Steam GetOwnedGames API endpoint. Game
+// names and free to play games that have been played are included
+func (control Controller) CallGetOwnedGamesAPI(ctx context.Context, steamID, apiKey string) (GamesStruct, error) {
+	targetURL := fmt.Sprintf("%s?key=%s&steamid=%s&include_appinfo=1&include_played_free_games=1",
+		configuration.AppConfig.SteamAPIURL("IPlayerService/GetOwnedGames/v0001/"), url.QueryEscape(apiKey), url.QueryEscape(steamID))
+	return control.getGames(ctx, targetURL, apiKey)
+}
+
+// CallGetRecentlyPlayedGamesAPI calls the Steam GetRecentlyPlayedGames API
+// endpoint which gives the games played in the last two weeks
+func (control Controller) CallGetRecentlyPlayedGamesAPI(ctx context.Context, steamID, apiKey string) (GamesStruct, error) {
+	targetURL := fmt.Sprintf("%s?key=%s&steamid=%s",
+		configuration.AppConfig.SteamAPIURL("IPlayerService/GetRecentlyPlayedGames/v0001/"), url.QueryEscape(apiKey), url.QueryEscape(steamID))
+	return control.getGames(ctx, targetURL, apiKey)
+}
+
+func (control Controller) getGames(ctx context.Context, targetURL, apiKey string) (GamesStruct, error) {
+	var gamesObj GamesStruct
+	statusCode, body, err := control.get(ctx, targetURL, apiKey)
+	if err != nil {
+		return gamesObj, err
+	}
+	if err := checkResponse(statusCode, body, apiKey); err != nil {
+		return gamesObj, MakeErr(err)
+	}
+
+	err = json.Unmarshal(body, &gamesObj)
+	if err != nil {
+		return gamesObj, MakeErr(err)
+	}
+	return gamesObj, nil
 }
 
 // CallGetFriendsListAPI calls the Steam GetFriendList API endpoint and returns the response in
 // FriendsStruct format
-func (controller Controller) CallGetFriendsListAPI(steamID, apiKey string) (FriendsStruct, error) {
+func (controller Controller) CallGetFriendsListAPI(ctx context.Context, steamID, apiKey string) (FriendsStruct, error) {
 	var friendsObj FriendsStruct
-	targetURL := fmt.Sprintf("http://api.steampowered.com/ISteamUser/GetFriendList/v0001/?key=%s&steamid=%s&relationship=friend", url.QueryEscape(apiKey), url.QueryEscape(steamID))
-	res, err := http.Get(targetURL)
+	targetURL := fmt.Sprintf("%s?key=%s&steamid=%s&relationship=friend", configuration.AppConfig.SteamAPIURL("ISteamUser/GetFriendList/v0001/"), url.QueryEscape(apiKey), url.QueryEscape(steamID))
+	// The Steam web API answers with 500 Internal Server Error for steamIDs that
+	// don't belong to an account so it isn't retried. Other 5xx statuses still are
+	statusCode, body, err := controller.get(ctx, targetURL, apiKey, http.StatusInternalServerError)
 	if err != nil {
+		return friendsObj, err
+	}
+	if statusCode == http.StatusInternalServerError {
+		return friendsObj, MakeErr(fmt.Errorf("%w: invalid steamID %s given", ErrNotFound, steamID))
+	}
+	if err := checkResponse(statusCode, body, apiKey); err != nil {
 		return friendsObj, MakeErr(err)
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
+	err = json.Unmarshal(body, &friendsObj)
 	if err != nil {
 		return friendsObj, MakeErr(err)
 	}
-
-	if valid := IsValidAPIResponseForSteamId(string(body)); !valid {
-		return friendsObj, MakeErr(fmt.Errorf("invalid steamID %s given", steamID))
-	}
-
-	if valid := IsValidResponseForAPIKey(string(body)); !valid {
-		return friendsObj, MakeErr(fmt.Errorf("invalid api key: %s", apiKey))
-	}
-
-	json.Unmarshal(body, &friendsObj)
-
 	return friendsObj, nil
 }
 
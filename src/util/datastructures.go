@@ -1,17 +1,54 @@
 package util
 
+import "time"
+
 // FriendsStruct is exactly whats saved on file for any given user
 type FriendsStruct struct {
-	Username    string      `json:"username"`
-	FriendsList Friendslist `json:"friendslist"`
+	Username string `json:"username"`
+	// CommunityVisibilityState is the user's profile visibility,
+	// 0 if it was cached before visibility was recorded
+	CommunityVisibilityState int         `json:"communityvisibilitystate,omitempty"`
+	FriendsList              Friendslist `json:"friendslist"`
+	// FetchedAt is when the friend list was fetched from the Steam
+	// web API, zero if it was cached before fetch times were recorded
+	FetchedAt time.Time `json:"fetchedAt"`
+	// Games is nil until the user's games have been fetched by a games enrichment
+	Games *UserGames `json:"games,omitempty"`
+}
+
+// UserGames holds the games fetched for a user when enriching a crawl
+type UserGames struct {
+	// Source is the endpoint the games were fetched from, either
+	// "owned" for GetOwnedGames or "recent" for GetRecentlyPlayedGames
+	Source string `json:"source"`
+	// Private is set if the user hides their games, in which case Games is empty
+	Private   bool        `json:"private,omitempty"`
+	Games     []OwnedGame `json:"games"`
+	FetchedAt time.Time   `json:"fetchedAt"`
+}
+
+// Owns checks whether appID is one of the user's games
+func (userGames *UserGames) Owns(appID int) bool {
+	if userGames == nil {
+		return false
+	}
+	for _, game := range userGames.Games {
+		if game.AppID == appID {
+			return true
+		}
+	}
+	return false
 }
 
-// Friend holds details of a friend for a given user
+// Friend holds details of a friend for a given user. Steamid is kept as it was given
+// so that one malformed entry doesn't fail the decoding of the whole friend list
 type Friend struct {
 	Username     string `json:"username"`
 	Steamid      string `json:"steamid"`
 	Relationship string `json:"relationship"`
 	FriendSince  int    `json:"friend_since"`
+	// CommunityVisibilityState is taken from the friend's player summary
+	CommunityVisibilityState int `json:"communityvisibilitystate,omitempty"`
 }
 
 // FriensdList holds all friends for a given user
@@ -50,3 +87,52 @@ type Player struct {
 type Response struct {
 	Players []Player `json:"players"`
 }
+
+// ResolveVanityURLStruct is the response from the steam web
+// API for /ResolveVanityURL calls. Success is 1 when the name
+// was resolved and 42 when no account has that name
+type ResolveVanityURLStruct struct {
+	Response struct {
+		SteamID string `json:"steamid"`
+		Success int    `json:"success"`
+		Message string `json:"message"`
+	} `json:"response"`
+}
+
+// GamesStruct is the response from the steam web API for /GetOwnedGames
+// and /GetRecentlyPlayedGames calls. GetOwnedGames gives GameCount and
+// GetRecentlyPlayedGames gives TotalCount. Both are left out entirely
+// when the user's games are private
+type GamesStruct struct {
+	Response struct {
+		GameCount  *int        `json:"game_count,omitempty"`
+		TotalCount *int        `json:"total_count,omitempty"`
+		Games      []OwnedGame `json:"games,omitempty"`
+	} `json:"response"`
+}
+
+// IsPrivate checks whether the user's games were hidden from the response
+func (games GamesStruct) IsPrivate() bool {
+	return games.Response.GameCount == nil && games.Response.TotalCount == nil
+}
+
+// OwnedGame holds details of one of a user's games. Playtimes are in minutes
+type OwnedGame struct {
+	AppID           int    `json:"appid"`
+	Name            string `json:"name,omitempty"`
+	PlaytimeForever int    `json:"playtime_forever"`
+	Playtime2Weeks  int    `json:"playtime_2weeks,omitempty"`
+}
+
+// SkippedFriends maps each user whose friends were sampled to the
+// steamIDs of the friends that weren't followed when crawling them
+type SkippedFriends map[string][]string
+
+// Count returns how many friend links were skipped in total
+func (skipped SkippedFriends) Count() int {
+	count := 0
+	for _, friends := range skipped {
+		count += len(friends)
+	}
+	return count
+}
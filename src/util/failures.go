@@ -0,0 +1,33 @@
+package util
+
+// FailureKind is the kind of error that stopped
+// a user from being crawled
+type FailureKind string
+
+const (
+	FailureInvalidSteamID FailureKind = "invalid steamID"
+	FailurePrivateProfile FailureKind = "private profile"
+	FailureFriendsList    FailureKind = "friends list lookup"
+	FailurePlayerSummary  FailureKind = "player summary lookup"
+	FailureCache          FailureKind = "cache"
+	FailureUnknown        FailureKind = "unknown"
+)
+
+// CrawlFailure records a user that could not be crawled
+// even after being retried
+type CrawlFailure struct {
+	SteamID string      `json:"steamid"`
+	Level   int         `json:"level"`
+	Kind    FailureKind `json:"kind"`
+	Error   string      `json:"error"`
+	Retries int         `json:"retries"`
+}
+
+// CrawlFailures is the report of every user that failed during a crawl
+type CrawlFailures []CrawlFailure
+
+// IsRetryable determines whether a failure of this kind
+// could succeed if the user is crawled again
+func (kind FailureKind) IsRetryable() bool {
+	return kind != FailureInvalidSteamID && kind != FailurePrivateProfile
+}
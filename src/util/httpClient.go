@@ -0,0 +1,142 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/steamFriendsGraphing/configuration"
+)
+
+var (
+	// ErrPrivateProfile is returned when the Steam web API refuses
+	// to give out a friend list because the profile is private
+	ErrPrivateProfile = errors.New("private profile")
+	// ErrNotFound is returned when the Steam web API
+	// has no account for the steamID given
+	ErrNotFound = errors.New("not found")
+)
+
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// defaultHTTPClient is used by a Controller without its own HTTPClient
+var defaultHTTPClient = NewHTTPClient(configuration.DefaultSteamAPITimeout, configuration.DefaultSteamAPIRetries)
+
+// HTTPClient makes requests to the Steam web API. Requests that fail with a 5xx
+// status or a network error are retried with exponential backoff and jitter
+type HTTPClient struct {
+	client      *http.Client
+	maxRetries  int
+	baseBackoff time.Duration
+	sleep       func(context.Context, time.Duration) error
+}
+
+// NewHTTPClient creates a HTTPClient whose requests time out after timeout
+// and which retries a failed request up to maxRetries more times
+func NewHTTPClient(timeout time.Duration, maxRetries int) *HTTPClient {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	return &HTTPClient{
+		client:      &http.Client{Timeout: timeout},
+		maxRetries:  maxRetries,
+		baseBackoff: defaultRetryBackoff,
+		sleep:       sleepContext,
+	}
+}
+
+// Get requests targetURL and returns the status code and body of the response. beforeAttempt
+// is called before every attempt so each retry can wait on a rate limiter and the request is
+// given up on if it returns an error. A response with a 5xx status is only returned once every
+// retry has failed the same way, unless the status is one of finalStatuses. Retrying stops as
+// soon as ctx is cancelled
+func (httpClient *HTTPClient) Get(ctx context.Context, targetURL string, beforeAttempt func() error, finalStatuses ...int) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return 0, nil, MakeErr(err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= httpClient.maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := httpClient.sleep(ctx, httpClient.backoff(attempt)); err != nil {
+				return 0, nil, MakeErr(err)
+			}
+		}
+		if beforeAttempt != nil {
+			if err := beforeAttempt(); err != nil {
+				return 0, nil, MakeErr(err)
+			}
+		}
+
+		res, err := httpClient.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, nil, MakeErr(ctx.Err())
+			}
+			lastErr = err
+			continue
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if res.StatusCode >= http.StatusInternalServerError && attempt < httpClient.maxRetries && !isFinalStatus(res.StatusCode, finalStatuses) {
+			continue
+		}
+		return res.StatusCode, body, nil
+	}
+	return 0, nil, MakeErr(fmt.Errorf("request failed after %d attempts: %w", httpClient.maxRetries+1, lastErr))
+}
+
+func isFinalStatus(statusCode int, finalStatuses []int) bool {
+	for _, finalStatus := range finalStatuses {
+		if statusCode == finalStatus {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff is how long to wait before the given retry. It doubles with each retry
+// and is jittered between half and all of that so clients don't retry in lockstep
+func (httpClient *HTTPClient) backoff(retry int) time.Duration {
+	backoff := httpClient.baseBackoff << uint(retry-1)
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// checkResponse turns a response from the Steam web API that wasn't
+// successful into an error that can be checked with errors.Is
+func checkResponse(statusCode int, body []byte, apiKey string) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", ErrRateLimited, RedactKey(apiKey))
+	case statusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrInvalidKey, RedactKey(apiKey))
+	case statusCode == http.StatusUnauthorized:
+		return ErrPrivateProfile
+	case statusCode == http.StatusNotFound:
+		return ErrNotFound
+	case statusCode != http.StatusOK:
+		return fmt.Errorf("steam web API responded with %d %s: %s", statusCode, http.StatusText(statusCode), truncateBody(body))
+	}
+	return nil
+}
+
+// truncateBody shortens a response body so it can be put in an error
+func truncateBody(body []byte) string {
+	const maxLength = 100
+	if len(body) > maxLength {
+		return string(body[:maxLength]) + "..."
+	}
+	return string(body)
+}
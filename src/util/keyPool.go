@@ -0,0 +1,218 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRateLimited is returned when the Steam web API
+	// responds with 429 Too Many Requests for an API key
+	ErrRateLimited = errors.New("rate limited")
+	// ErrInvalidKey is returned when the Steam web API
+	// rejects an API key as forbidden or revoked
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrNoValidKeys is returned by a KeyPool once
+	// every one of its keys has been quarantined
+	ErrNoValidKeys = errors.New("every API key has been quarantined")
+)
+
+const (
+	defaultKeyBackoff    = 5 * time.Second
+	defaultMaxKeyBackoff = 10 * time.Minute
+)
+
+// KeyStatus is the health of a single API key in a KeyPool
+type KeyStatus string
+
+const (
+	KeyHealthy     KeyStatus = "healthy"
+	KeyThrottled   KeyStatus = "throttled"
+	KeyQuarantined KeyStatus = "quarantined"
+)
+
+// KeyStats holds the usage and health of a single API key. The key
+// itself is redacted so that stats can be printed or served safely
+type KeyStats struct {
+	Key            string    `json:"key"`
+	Status         KeyStatus `json:"status"`
+	Uses           int       `json:"uses"`
+	Successes      int       `json:"successes"`
+	Failures       int       `json:"failures"`
+	RateLimited    int       `json:"rateLimited"`
+	ThrottledUntil time.Time `json:"throttledUntil"`
+}
+
+func (stats KeyStats) String() string {
+	status := string(stats.Status)
+	if stats.Status == KeyThrottled {
+		status += fmt.Sprintf(" until %s", stats.ThrottledUntil.Format("15:04:05"))
+	}
+	return fmt.Sprintf("%s\t%s\tuses: %d successes: %d failures: %d rate limited: %d",
+		stats.Key, status, stats.Uses, stats.Successes, stats.Failures, stats.RateLimited)
+}
+
+type keyState struct {
+	key         string
+	stats       KeyStats
+	quarantined bool
+	// throttles is the amount of times in a row this key has been
+	// rate limited and is used to back off the key exponentially
+	throttles      int
+	throttledUntil time.Time
+}
+
+// KeyPool hands out API keys round robin while keeping track of the health
+// of each key. A key that is rate limited is backed off exponentially and a
+// key that is rejected is quarantined so it is never handed out again
+type KeyPool struct {
+	mutex  sync.Mutex
+	keys   []*keyState
+	cursor int
+
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	now         func() time.Time
+}
+
+// NewKeyPool creates a KeyPool with every given API key marked as healthy
+func NewKeyPool(apiKeys []string) *KeyPool {
+	pool := &KeyPool{
+		baseBackoff: defaultKeyBackoff,
+		maxBackoff:  defaultMaxKeyBackoff,
+		now:         time.Now,
+	}
+	for _, apiKey := range apiKeys {
+		pool.keys = append(pool.keys, &keyState{
+			key:   apiKey,
+			stats: KeyStats{Key: RedactKey(apiKey), Status: KeyHealthy},
+		})
+	}
+	return pool
+}
+
+// Acquire returns the next healthy API key. If every key is currently throttled
+// it waits until the first one is available again or ctx is cancelled
+func (pool *KeyPool) Acquire(ctx context.Context) (string, error) {
+	for {
+		apiKey, wait, err := pool.next()
+		if err != nil || wait == 0 {
+			return apiKey, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// next returns the next available key or how long until one is available
+func (pool *KeyPool) next() (string, time.Duration, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	now := pool.now()
+	var wait time.Duration
+	for i := 0; i < len(pool.keys); i++ {
+		state := pool.keys[(pool.cursor+i)%len(pool.keys)]
+		if state.quarantined {
+			continue
+		}
+		if untilAvailable := state.throttledUntil.Sub(now); untilAvailable > 0 {
+			if wait == 0 || untilAvailable < wait {
+				wait = untilAvailable
+			}
+			continue
+		}
+
+		pool.cursor = (pool.cursor + i + 1) % len(pool.keys)
+		state.stats.Uses++
+		return state.key, 0, nil
+	}
+	if wait == 0 {
+		return "", 0, ErrNoValidKeys
+	}
+	return "", wait, nil
+}
+
+// Report records the outcome of a call made with an API key. A rate limited key
+// is throttled, a rejected key is quarantined and a success resets the backoff
+func (pool *KeyPool) Report(apiKey string, err error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for _, state := range pool.keys {
+		if state.key != apiKey {
+			continue
+		}
+		switch {
+		case err == nil:
+			state.stats.Successes++
+			state.throttles = 0
+		case errors.Is(err, ErrInvalidKey):
+			state.stats.Failures++
+			state.quarantined = true
+		case errors.Is(err, ErrRateLimited):
+			state.stats.RateLimited++
+			backoff := pool.baseBackoff << uint(state.throttles)
+			if backoff > pool.maxBackoff || backoff <= 0 {
+				backoff = pool.maxBackoff
+			}
+			state.throttles++
+			state.throttledUntil = pool.now().Add(backoff)
+		default:
+			// Errors that aren't the key's fault don't affect its health
+			state.stats.Failures++
+		}
+		return
+	}
+}
+
+// Healthy returns how many keys can currently be handed out
+func (pool *KeyPool) Healthy() int {
+	healthy := 0
+	for _, stats := range pool.Stats() {
+		if stats.Status == KeyHealthy {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// Stats returns the usage and health of every key in the pool
+func (pool *KeyPool) Stats() []KeyStats {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	now := pool.now()
+	allStats := make([]KeyStats, 0, len(pool.keys))
+	for _, state := range pool.keys {
+		stats := state.stats
+		switch {
+		case state.quarantined:
+			stats.Status = KeyQuarantined
+		case state.throttledUntil.After(now):
+			stats.Status = KeyThrottled
+			stats.ThrottledUntil = state.throttledUntil
+		default:
+			stats.Status = KeyHealthy
+		}
+		allStats = append(allStats, stats)
+	}
+	return allStats
+}
+
+// RedactKey hides all but the last four characters of an API key
+func RedactKey(apiKey string) string {
+	if len(apiKey) <= 4 {
+		return "****"
+	}
+	return "****" + apiKey[len(apiKey)-4:]
+}
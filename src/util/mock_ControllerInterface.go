@@ -3,6 +3,7 @@
 package util
 
 import (
+	context "context"
 	os "os"
 
 	mock "github.com/stretchr/testify/mock"
@@ -13,20 +14,20 @@ type MockControllerInterface struct {
 	mock.Mock
 }
 
-// CallGetFriendsListAPI provides a mock function with given fields: steamID, apiKey
-func (_m *MockControllerInterface) CallGetFriendsListAPI(steamID string, apiKey string) (FriendsStruct, error) {
-	ret := _m.Called(steamID, apiKey)
+// CallGetFriendsListAPI provides a mock function with given fields: ctx, steamID, apiKey
+func (_m *MockControllerInterface) CallGetFriendsListAPI(ctx context.Context, steamID string, apiKey string) (FriendsStruct, error) {
+	ret := _m.Called(ctx, steamID, apiKey)
 
 	var r0 FriendsStruct
-	if rf, ok := ret.Get(0).(func(string, string) FriendsStruct); ok {
-		r0 = rf(steamID, apiKey)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) FriendsStruct); ok {
+		r0 = rf(ctx, steamID, apiKey)
 	} else {
 		r0 = ret.Get(0).(FriendsStruct)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(steamID, apiKey)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, steamID, apiKey)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -34,20 +35,62 @@ func (_m *MockControllerInterface) CallGetFriendsListAPI(steamID string, apiKey
 	return r0, r1
 }
 
-// CallIsAPIKeyValidAPI provides a mock function with given fields: apiKeys
-func (_m *MockControllerInterface) CallIsAPIKeyValidAPI(apiKeys string) (string, error) {
-	ret := _m.Called(apiKeys)
+// CallGetOwnedGamesAPI provides a mock function with given fields: ctx, steamID, apiKey
+func (_m *MockControllerInterface) CallGetOwnedGamesAPI(ctx context.Context, steamID string, apiKey string) (GamesStruct, error) {
+	ret := _m.Called(ctx, steamID, apiKey)
+
+	var r0 GamesStruct
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) GamesStruct); ok {
+		r0 = rf(ctx, steamID, apiKey)
+	} else {
+		r0 = ret.Get(0).(GamesStruct)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, steamID, apiKey)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CallGetRecentlyPlayedGamesAPI provides a mock function with given fields: ctx, steamID, apiKey
+func (_m *MockControllerInterface) CallGetRecentlyPlayedGamesAPI(ctx context.Context, steamID string, apiKey string) (GamesStruct, error) {
+	ret := _m.Called(ctx, steamID, apiKey)
+
+	var r0 GamesStruct
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) GamesStruct); ok {
+		r0 = rf(ctx, steamID, apiKey)
+	} else {
+		r0 = ret.Get(0).(GamesStruct)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, steamID, apiKey)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CallIsAPIKeyValidAPI provides a mock function with given fields: ctx, apiKeys
+func (_m *MockControllerInterface) CallIsAPIKeyValidAPI(ctx context.Context, apiKeys string) (string, error) {
+	ret := _m.Called(ctx, apiKeys)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string) string); ok {
-		r0 = rf(apiKeys)
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, apiKeys)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(apiKeys)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, apiKeys)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -55,20 +98,41 @@ func (_m *MockControllerInterface) CallIsAPIKeyValidAPI(apiKeys string) (string,
 	return r0, r1
 }
 
-// CallPlayerSummaryAPI provides a mock function with given fields: steamID, apiKey
-func (_m *MockControllerInterface) CallPlayerSummaryAPI(steamID string, apiKey string) (UserStatsStruct, error) {
-	ret := _m.Called(steamID, apiKey)
+// CallPlayerSummaryAPI provides a mock function with given fields: ctx, steamID, apiKey
+func (_m *MockControllerInterface) CallPlayerSummaryAPI(ctx context.Context, steamID string, apiKey string) (UserStatsStruct, error) {
+	ret := _m.Called(ctx, steamID, apiKey)
 
 	var r0 UserStatsStruct
-	if rf, ok := ret.Get(0).(func(string, string) UserStatsStruct); ok {
-		r0 = rf(steamID, apiKey)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) UserStatsStruct); ok {
+		r0 = rf(ctx, steamID, apiKey)
 	} else {
 		r0 = ret.Get(0).(UserStatsStruct)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(steamID, apiKey)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, steamID, apiKey)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CallResolveVanityURLAPI provides a mock function with given fields: ctx, vanityName, apiKey
+func (_m *MockControllerInterface) CallResolveVanityURLAPI(ctx context.Context, vanityName string, apiKey string) (ResolveVanityURLStruct, error) {
+	ret := _m.Called(ctx, vanityName, apiKey)
+
+	var r0 ResolveVanityURLStruct
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) ResolveVanityURLStruct); ok {
+		r0 = rf(ctx, vanityName, apiKey)
+	} else {
+		r0 = ret.Get(0).(ResolveVanityURLStruct)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, vanityName, apiKey)
 	} else {
 		r1 = ret.Error(1)
 	}
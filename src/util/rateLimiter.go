@@ -0,0 +1,155 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit is the rate requests can be made at. A limit
+// of zero means that dimension isn't limited
+type RateLimit struct {
+	PerSecond float64
+	PerDay    int
+}
+
+// tokenBucket holds up to capacity tokens and is refilled at refillRate tokens
+// per second. Tokens are always taken straight away, leaving the bucket in debt
+// if it's empty, so that callers queue up behind each other in the order they came
+type tokenBucket struct {
+	capacity   float64
+	tokens     float64
+	refillRate float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(capacity, refillRate float64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		capacity:   capacity,
+		tokens:     capacity,
+		refillRate: refillRate,
+		lastRefill: now,
+	}
+}
+
+// take takes a token and returns how long the caller
+// must wait before that token is actually available
+func (bucket *tokenBucket) take(now time.Time) time.Duration {
+	if elapsed := now.Sub(bucket.lastRefill); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * bucket.refillRate
+		if bucket.tokens > bucket.capacity {
+			bucket.tokens = bucket.capacity
+		}
+		bucket.lastRefill = now
+	}
+
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.refillRate * float64(time.Second))
+}
+
+// limitBuckets are the per second and per day buckets for one RateLimit
+type limitBuckets []*tokenBucket
+
+func newLimitBuckets(limit RateLimit, now time.Time) limitBuckets {
+	buckets := make(limitBuckets, 0, 2)
+	if limit.PerSecond > 0 {
+		burst := limit.PerSecond
+		if burst < 1 {
+			burst = 1
+		}
+		buckets = append(buckets, newTokenBucket(burst, limit.PerSecond, now))
+	}
+	if limit.PerDay > 0 {
+		buckets = append(buckets, newTokenBucket(float64(limit.PerDay), float64(limit.PerDay)/(24*time.Hour).Seconds(), now))
+	}
+	return buckets
+}
+
+func (buckets limitBuckets) take(now time.Time) time.Duration {
+	wait := time.Duration(0)
+	for _, bucket := range buckets {
+		if bucketWait := bucket.take(now); bucketWait > wait {
+			wait = bucketWait
+		}
+	}
+	return wait
+}
+
+// RateLimiter limits the rate of calls made to the Steam web API both
+// across all API keys and for each individual key. Calls are delayed
+// rather than refused so a crawl slows down instead of failing.
+// Daily limits start full each time the application is started
+type RateLimiter struct {
+	mutex       sync.Mutex
+	globalLimit RateLimit
+	keyLimit    RateLimit
+	// Buckets are made the first time they're needed
+	global limitBuckets
+	keys   map[string]limitBuckets
+
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// NewRateLimiter creates a RateLimiter with a global limit shared by
+// all calls and a limit applied separately to each API key
+func NewRateLimiter(globalLimit, keyLimit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		globalLimit: globalLimit,
+		keyLimit:    keyLimit,
+		keys:        make(map[string]limitBuckets),
+		now:         time.Now,
+		sleep:       sleepContext,
+	}
+}
+
+// Wait blocks until a call can be made with the given API key or ctx is cancelled, in
+// which case ctx's error is returned. A nil RateLimiter never blocks so a Controller
+// doesn't need one
+func (limiter *RateLimiter) Wait(ctx context.Context, apiKey string) error {
+	if limiter == nil {
+		return ctx.Err()
+	}
+	if wait := limiter.reserve(apiKey); wait > 0 {
+		return limiter.sleep(ctx, wait)
+	}
+	return ctx.Err()
+}
+
+// reserve takes a token from the global buckets and the API key's buckets
+// and returns how long the caller must wait before making its call
+func (limiter *RateLimiter) reserve(apiKey string) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	if limiter.global == nil {
+		limiter.global = newLimitBuckets(limiter.globalLimit, now)
+	}
+	keyBuckets, exists := limiter.keys[apiKey]
+	if !exists {
+		keyBuckets = newLimitBuckets(limiter.keyLimit, now)
+		limiter.keys[apiKey] = keyBuckets
+	}
+
+	wait := limiter.global.take(now)
+	if keyWait := keyBuckets.take(now); keyWait > wait {
+		wait = keyWait
+	}
+	return wait
+}
+
+// sleepContext sleeps for duration unless ctx is cancelled first
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,222 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/steamFriendsGraphing/configuration"
+)
+
+// vanitySuccess is the success code ResolveVanityURL gives when the name was found
+const vanitySuccess = 1
+
+// vanityNameRegex matches the names Steam allows for custom profile URLs
+var vanityNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{2,32}$`)
+
+// vanityCache holds the custom profile names already resolved to steamIDs. It's loaded
+// from configuration.AppConfig.VanityURLsLocation the first time it's needed and is
+// written back whenever a new name is resolved. Names that couldn't be resolved are
+// only remembered in memory since someone could take the name later on
+var vanityCache = struct {
+	sync.Mutex
+	location   string
+	names      map[string]string
+	unresolved map[string]bool
+}{}
+
+// ParseSteamInput works out what a user has given as a steamID. The input can be a
+// steamID in any format ParseSteamID understands, a steamcommunity.com/profiles/<steamID>
+// or steamcommunity.com/id/<name> link or just a custom profile name. The steamID is
+// given as a SteamID64. Custom profile names can be all digits or look like a friend
+// code so for an account ID or friend code both steamID and vanityName are returned
+// and the name should be looked up first
+func ParseSteamInput(input string) (steamID string, vanityName string, err error) {
+	input = strings.TrimSpace(input)
+	if strings.Contains(strings.ToLower(input), "steamcommunity.com/") {
+		return parseProfileURL(input)
+	}
+	if id, err := ParseSteamID(input); err == nil {
+		if vanityNameRegex.MatchString(input) && !steamID64Regex.MatchString(input) {
+			return id.String(), input, nil
+		}
+		return id.String(), "", nil
+	}
+	if vanityNameRegex.MatchString(input) {
+		return "", input, nil
+	}
+	return "", "", MakeErr(fmt.Errorf("%q is not a steamID, profile link or custom profile name", input))
+}
+
+// parseProfileURL takes the steamID or custom profile name out of a profile link
+func parseProfileURL(input string) (string, string, error) {
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
+	}
+	profileURL, err := url.Parse(input)
+	if err != nil {
+		return "", "", MakeErr(err)
+	}
+	segments := strings.Split(strings.Trim(profileURL.Path, "/"), "/")
+	if len(segments) >= 2 {
+		switch {
+		case segments[0] == "profiles" && steamID64Regex.MatchString(segments[1]):
+			if id, err := ParseSteamID(segments[1]); err == nil {
+				return id.String(), "", nil
+			}
+		case segments[0] == "id" && vanityNameRegex.MatchString(segments[1]):
+			return "", segments[1], nil
+		}
+	}
+	return "", "", MakeErr(fmt.Errorf("%q is not a link to a steam profile", input))
+}
+
+// NeedsVanityLookup checks whether resolving the inputs would call the Steam web
+// API, meaning there's a custom profile name among them that isn't cached yet
+func NeedsVanityLookup(inputs []string) bool {
+	for _, input := range inputs {
+		_, vanityName, err := ParseSteamInput(input)
+		if err != nil || vanityName == "" {
+			continue
+		}
+		if _, cached := lookupVanityName(vanityName); !cached {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveSteamIDs resolves every input to a steamID, see ResolveSteamID
+func ResolveSteamIDs(ctx context.Context, cntr ControllerInterface, apiKey string, inputs []string) ([]string, error) {
+	steamIDs := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		steamID, err := ResolveSteamID(ctx, cntr, apiKey, input)
+		if err != nil {
+			return nil, err
+		}
+		steamIDs = append(steamIDs, steamID)
+	}
+	return steamIDs, nil
+}
+
+// ResolveSteamID turns a steamID, profile link or custom profile name into a steamID.
+// Custom profile names are resolved through the Steam web API the first time they're
+// seen and are cached after that so looking them up again costs nothing. An account ID
+// or friend code is only taken as a steamID if no account has it as its custom name
+func ResolveSteamID(ctx context.Context, cntr ControllerInterface, apiKey, input string) (string, error) {
+	steamID, vanityName, err := ParseSteamInput(input)
+	if err != nil || vanityName == "" {
+		return steamID, err
+	}
+	// noMatch falls back to the steamID the input parsed as if there was one
+	noMatch := func() (string, error) {
+		if steamID != "" {
+			return steamID, nil
+		}
+		return "", MakeErr(fmt.Errorf("%w: no account has the custom profile name %s", ErrNotFound, vanityName))
+	}
+	if cachedSteamID, cached := lookupVanityName(vanityName); cached {
+		if cachedSteamID == "" {
+			return noMatch()
+		}
+		return cachedSteamID, nil
+	}
+
+	resolved, err := cntr.CallResolveVanityURLAPI(ctx, vanityName, apiKey)
+	if err != nil {
+		return "", err
+	}
+	if resolved.Response.Success != vanitySuccess || !IsValidFormatSteamID(resolved.Response.SteamID) {
+		rememberVanityName(vanityName, "")
+		return noMatch()
+	}
+	err = rememberVanityName(vanityName, resolved.Response.SteamID)
+	if err != nil {
+		return "", err
+	}
+	return resolved.Response.SteamID, nil
+}
+
+// lookupVanityName finds a custom profile name in the cache. A cached
+// name with an empty steamID is one that couldn't be resolved
+func lookupVanityName(vanityName string) (string, bool) {
+	vanityCache.Lock()
+	defer vanityCache.Unlock()
+	loadVanityCache()
+
+	key := strings.ToLower(vanityName)
+	if vanityCache.unresolved[key] {
+		return "", true
+	}
+	steamID, cached := vanityCache.names[key]
+	return steamID, cached
+}
+
+// rememberVanityName adds a resolved custom profile name to the cache. Custom
+// profile names aren't case sensitive so they're cached in lower case
+func rememberVanityName(vanityName, steamID string) error {
+	vanityCache.Lock()
+	defer vanityCache.Unlock()
+	loadVanityCache()
+
+	key := strings.ToLower(vanityName)
+	if steamID == "" {
+		vanityCache.unresolved[key] = true
+		return nil
+	}
+	delete(vanityCache.unresolved, key)
+	vanityCache.names[key] = steamID
+
+	if vanityCache.location == "" {
+		return nil
+	}
+	jsonObj, err := json.MarshalIndent(vanityCache.names, "", "\t")
+	if err != nil {
+		return MakeErr(err)
+	}
+	err = os.MkdirAll(filepath.Dir(vanityCache.location), 0755)
+	if err != nil {
+		return MakeErr(err)
+	}
+	err = ioutil.WriteFile(vanityCache.location, jsonObj, 0644)
+	if err != nil {
+		return MakeErr(err)
+	}
+	return nil
+}
+
+// loadVanityCache reads in the cached custom profile names if they haven't
+// been read in yet or the cache's location has changed. The caller must
+// hold the vanityCache lock
+func loadVanityCache() {
+	location := configuration.AppConfig.VanityURLsLocation
+	if vanityCache.names != nil && vanityCache.location == location {
+		return
+	}
+	vanityCache.location = location
+	vanityCache.names = make(map[string]string)
+	vanityCache.unresolved = make(map[string]bool)
+	if location == "" {
+		return
+	}
+
+	content, err := ioutil.ReadFile(location)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &vanityCache.names)
+	}
+	// A cache that can't be read is started over rather than failing every lookup
+	if err != nil {
+		vanityCache.names = make(map[string]string)
+	}
+}
@@ -0,0 +1,213 @@
+package util
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidSteamID is returned when input can't be parsed as a steamID
+var ErrInvalidSteamID = errors.New("invalid steamID")
+
+const (
+	// steamID64Base is the SteamID64 of account ID 0, an individual
+	// account in the public universe. Every user's SteamID64 is
+	// their account ID added on to it
+	steamID64Base = 76561197960265728
+	// friendCodeAlphabet is the base 32 alphabet friend codes are written in
+	friendCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// friendCodeHashPrefix is "CSGO", which is mixed into the
+	// account ID to work out a friend code's check bits
+	friendCodeHashPrefix = 0x4353474F
+)
+
+var (
+	steamID64Regex  = regexp.MustCompile(`^[0-9]{17}$`)
+	steamID2Regex   = regexp.MustCompile(`^STEAM_[0-5]:([01]):([0-9]{1,10})$`)
+	steamID3Regex   = regexp.MustCompile(`^(?:\[U:1:([0-9]{1,10})\]|U:1:([0-9]{1,10}))$`)
+	accountIDRegex  = regexp.MustCompile(`^[0-9]{1,10}$`)
+	friendCodeRegex = regexp.MustCompile(`^(AAAA-)?[A-HJ-NP-Z2-9]{5}-[A-HJ-NP-Z2-9]{4}$`)
+)
+
+// SteamID identifies an individual Steam account. It's held as a SteamID64 and
+// can be parsed from or converted to any of the other formats Steam shows
+type SteamID uint64
+
+// NewSteamID returns the SteamID of an individual account given its 32 bit account ID
+func NewSteamID(accountID uint32) SteamID {
+	return SteamID(steamID64Base + uint64(accountID))
+}
+
+// ParseSteamID parses a SteamID64 (76561197960287930), a SteamID2 (STEAM_0:0:11101),
+// a SteamID3 ([U:1:22202]), a 32 bit account ID (22202) or a friend code (SUCVS-FADA)
+func ParseSteamID(input string) (SteamID, error) {
+	input = strings.TrimSpace(input)
+	switch {
+	case steamID64Regex.MatchString(input):
+		id, err := strconv.ParseUint(input, 10, 64)
+		if err == nil && SteamID(id).IsValid() {
+			return SteamID(id), nil
+		}
+	case steamID2Regex.MatchString(input):
+		parts := steamID2Regex.FindStringSubmatch(input)
+		authServer, _ := strconv.ParseUint(parts[1], 10, 64)
+		accountNumber, err := strconv.ParseUint(parts[2], 10, 31)
+		if err == nil {
+			return parsedAccountID(accountNumber*2 + authServer)
+		}
+	case steamID3Regex.MatchString(input):
+		// The account ID is in the first group if the brackets were given and the second if not
+		parts := steamID3Regex.FindStringSubmatch(input)
+		accountID, err := strconv.ParseUint(parts[1]+parts[2], 10, 32)
+		if err == nil {
+			return parsedAccountID(accountID)
+		}
+	case accountIDRegex.MatchString(input):
+		accountID, err := strconv.ParseUint(input, 10, 32)
+		if err == nil {
+			return parsedAccountID(accountID)
+		}
+	case friendCodeRegex.MatchString(strings.ToUpper(input)):
+		return parseFriendCode(strings.ToUpper(input))
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidSteamID, input)
+}
+
+// parsedAccountID turns a parsed account ID into a SteamID. Account ID 0 isn't a real account
+func parsedAccountID(accountID uint64) (SteamID, error) {
+	if accountID == 0 || accountID > 0xFFFFFFFF {
+		return 0, fmt.Errorf("%w: account ID %d is out of range", ErrInvalidSteamID, accountID)
+	}
+	return NewSteamID(uint32(accountID)), nil
+}
+
+// IsValid checks whether the SteamID belongs to an individual account in the public universe
+func (id SteamID) IsValid() bool {
+	return uint64(id)>>32 == steamID64Base>>32 && id.AccountID() != 0
+}
+
+// AccountID returns the 32 bit account ID, also shown as the friend code in the Steam client
+func (id SteamID) AccountID() uint32 {
+	return uint32(id)
+}
+
+// String returns the SteamID64
+func (id SteamID) String() string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// SteamIDStrings returns the SteamID64 of each SteamID
+func SteamIDStrings(ids []SteamID) []string {
+	steamIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		steamIDs = append(steamIDs, id.String())
+	}
+	return steamIDs
+}
+
+// SteamID2 returns the SteamID in the STEAM_0:X:Y format
+func (id SteamID) SteamID2() string {
+	return fmt.Sprintf("STEAM_0:%d:%d", id.AccountID()&1, id.AccountID()>>1)
+}
+
+// SteamID3 returns the SteamID in the [U:1:N] format
+func (id SteamID) SteamID3() string {
+	return fmt.Sprintf("[U:1:%d]", id.AccountID())
+}
+
+// FriendCode returns the friend code of the account, such as SUCVS-FADA. Each
+// nibble of the account ID is stored along with a check bit taken from an MD5
+// hash of it and the result is written out in base 32
+func (id SteamID) FriendCode() string {
+	hash := friendCodeHash(id.AccountID())
+	accountID := uint64(id.AccountID())
+	var encoded uint64
+	for i := uint(0); i < 8; i++ {
+		nibble := accountID & 0xF
+		accountID >>= 4
+		shifted := encoded<<4 | nibble
+		encoded = (encoded>>28)<<32 | shifted
+		encoded = (encoded>>31)<<32 | shifted<<1 | uint64(hash>>i)&1
+	}
+	encoded = bits.ReverseBytes64(encoded)
+
+	code := strings.Builder{}
+	for i := 0; i < 13; i++ {
+		if i == 4 || i == 9 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(friendCodeAlphabet[encoded&31])
+		encoded >>= 5
+	}
+	return strings.TrimPrefix(code.String(), "AAAA-")
+}
+
+// parseFriendCode reverses FriendCode, checking the code's check bits
+func parseFriendCode(code string) (SteamID, error) {
+	if !strings.HasPrefix(code, "AAAA-") {
+		code = "AAAA-" + code
+	}
+	var encoded uint64
+	for i, char := range strings.Replace(code, "-", "", -1) {
+		encoded |= uint64(strings.IndexRune(friendCodeAlphabet, char)) << (5 * uint(i))
+	}
+	encoded = bits.ReverseBytes64(encoded)
+
+	var accountID uint64
+	for i := 0; i < 8; i++ {
+		encoded >>= 1
+		accountID = accountID<<4 | encoded&0xF
+		encoded >>= 4
+	}
+	id, err := parsedAccountID(accountID)
+	if err != nil || id.FriendCode() != strings.TrimPrefix(code, "AAAA-") {
+		return 0, fmt.Errorf("%w: %q is not a valid friend code", ErrInvalidSteamID, code)
+	}
+	return id, nil
+}
+
+// friendCodeHash gives the check bits of an account's friend code
+func friendCodeHash(accountID uint32) uint32 {
+	input := make([]byte, 8)
+	binary.LittleEndian.PutUint64(input, uint64(friendCodeHashPrefix)<<32|uint64(accountID))
+	hash := md5.Sum(input)
+	return binary.LittleEndian.Uint32(hash[:4])
+}
+
+// MarshalJSON writes the SteamID as a SteamID64 string, the same way the Steam
+// web API does. The zero SteamID is written as an empty string
+func (id SteamID) MarshalJSON() ([]byte, error) {
+	if id == 0 {
+		return json.Marshal("")
+	}
+	return json.Marshal(id.String())
+}
+
+// UnmarshalJSON reads a SteamID given as a string in any format ParseSteamID
+// understands or as a number. An empty string or zero leaves the SteamID as zero
+func (id *SteamID) UnmarshalJSON(data []byte) error {
+	var input string
+	if err := json.Unmarshal(data, &input); err != nil {
+		var number uint64
+		if json.Unmarshal(data, &number) != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidSteamID, data)
+		}
+		input = strconv.FormatUint(number, 10)
+	}
+	if input == "" || input == "0" {
+		*id = 0
+		return nil
+	}
+	parsed, err := ParseSteamID(input)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -23,7 +24,7 @@ var (
 
 // GetPlayerSummary gets a player summary through the Steam web API
 func GetPlayerSummary(cntr ControllerInterface, steamID, apiKey string) (Player, error) {
-	userStatsObj, err := cntr.CallPlayerSummaryAPI(steamID, apiKey)
+	userStatsObj, err := cntr.CallPlayerSummaryAPI(context.Background(), steamID, apiKey)
 	if err != nil {
 		return Player{}, err
 	}
@@ -92,14 +93,24 @@ func ThrowErr(err error) {
 func MakeErr(err error, msg ...string) error {
 	_, file, line, _ := runtime.Caller(1)
 	path, _ := os.Getwd()
-	return fmt.Errorf("%s:%d %s %s", strings.TrimPrefix(file, path), line, msg, err)
+	return fmt.Errorf("%s:%d %s %w", strings.TrimPrefix(file, path), line, msg, err)
 }
 
-// IsValidFormatSteamID runs a simple regex check to see if the
-// steamID is in the valid format before calling the API
+// CommunityVisibilityPublic is the communityvisibilitystate
+// given by the Steam web API for public profiles
+const CommunityVisibilityPublic = 3
+
+// IsPrivateProfile checks if a communityvisibilitystate belongs to a profile whose
+// friends list can't be read. A state of 0 means the visibility isn't known
+func IsPrivateProfile(communityVisibilityState int) bool {
+	return communityVisibilityState != 0 && communityVisibilityState != CommunityVisibilityPublic
+}
+
+// IsValidFormatSteamID checks that steamID is the SteamID64 of
+// an individual account before calling the API with it
 func IsValidFormatSteamID(steamID string) bool {
-	match, _ := regexp.MatchString("([0-9]){17}", steamID)
-	return match
+	id, err := ParseSteamID(steamID)
+	return err == nil && id.String() == steamID
 }
 
 // IsValidAPIResponseForSteamId checks if a steamID is valid based
@@ -120,7 +131,7 @@ func IsValidResponseForAPIKey(body string) bool {
 // calling the Steam web API with each key
 func CheckAPIKeys(cntr ControllerInterface, apiKeys []string) error {
 	for i, apiKey := range apiKeys {
-		response, err := cntr.CallIsAPIKeyValidAPI(apiKey)
+		response, err := cntr.CallIsAPIKeyValidAPI(context.Background(), apiKey)
 		if err != nil {
 			return err
 		}
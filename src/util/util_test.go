@@ -3,12 +3,19 @@
 package util
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/steamFriendsGraphing/configuration"
 	"github.com/stretchr/testify/assert"
@@ -51,7 +58,7 @@ func TestGetPlayerSummary(t *testing.T) {
 	mockController := &MockControllerInterface{}
 
 	expectedSteamID := expectedUserStats.Steamid
-	mockController.On("CallPlayerSummaryAPI", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedGetPlayerSummaryUser, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedGetPlayerSummaryUser, nil)
 
 	receivedUserDetails, _ := GetPlayerSummary(mockController, expectedSteamID, "test API key")
 
@@ -62,7 +69,7 @@ func TestGetPlayerSummaryWithInvalidAPIResponse(t *testing.T) {
 	mockController := &MockControllerInterface{}
 	var emptyUserSummary UserStatsStruct
 	apiResponseErr := errors.New("U done goofed")
-	mockController.On("CallPlayerSummaryAPI", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(emptyUserSummary, apiResponseErr)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(emptyUserSummary, apiResponseErr)
 
 	receivedUserDetails, err := GetPlayerSummary(mockController, "example steamID", "test API key")
 
@@ -73,7 +80,7 @@ func TestGetPlayerSummaryWithInvalidAPIResponse(t *testing.T) {
 func TestCheckAPIKeys(t *testing.T) {
 	mockController := &MockControllerInterface{}
 
-	mockController.On("CallIsAPIKeyValidAPI", mock.AnythingOfType("string")).Return("valid response", nil)
+	mockController.On("CallIsAPIKeyValidAPI", mock.Anything, mock.AnythingOfType("string")).Return("valid response", nil)
 	mockController.On("IsValidResponseForAPIKey", mock.AnythingOfType("string")).Return(true)
 
 	apiKeysToBeChecked := []string{
@@ -89,7 +96,7 @@ func TestValidGetUserDetails(t *testing.T) {
 	mockController := &MockControllerInterface{}
 	steamID := "search steamID"
 
-	mockController.On("CallPlayerSummaryAPI", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedGetPlayerSummaryUser, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedGetPlayerSummaryUser, nil)
 	receivedUser, _ := GetUserDetails(mockController, "example API key", steamID)
 
 	assert.NotNil(t, receivedUser, "expect to receive mocked user")
@@ -105,7 +112,7 @@ func TestGetUserDetailsForNonExistantUser(t *testing.T) {
 		},
 	}
 
-	mockController.On("CallPlayerSummaryAPI", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedUserResponse, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedUserResponse, nil)
 	_, err := GetUserDetails(mockController, "example API key", steamID)
 
 	assert.NotNil(t, err, "expect error to be returned when receiving 0 users")
@@ -117,7 +124,7 @@ func TestGetUsernameValidFormatSteamID(t *testing.T) {
 	steamID := "76561197960287930"
 
 	expectedUsername := expectedUserStats.Personaname
-	mockController.On("CallPlayerSummaryAPI", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedGetPlayerSummaryUser, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedGetPlayerSummaryUser, nil)
 
 	receivedUsername, err := GetUsername(mockController, apiKeys[0], steamID)
 	assert.Nil(t, err, fmt.Sprintf("can't get username for user: %s using key: %s", steamID, apiKeys[0]))
@@ -129,7 +136,7 @@ func TestGetUsernameWithInvalidFormatSteamID(t *testing.T) {
 	apiKeys := []string{"test API key"}
 	steamID := "invalid format SteamID"
 
-	mockController.On("CallPlayerSummaryAPI", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedUserStats, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(expectedUserStats, nil)
 
 	_, err := GetUsername(mockController, apiKeys[0], steamID)
 	assert.NotNil(t, err, "didn't throw error for GetUsername call with invalid steamID: ", steamID)
@@ -161,6 +168,13 @@ func TestIsValidResponseForAPIKey(t *testing.T) {
 	assert.True(t, isValid, "invalid steamID given for valid response")
 }
 
+func TestIsPrivateProfile(t *testing.T) {
+	assert.True(t, IsPrivateProfile(1))
+	assert.False(t, IsPrivateProfile(CommunityVisibilityPublic))
+	// Users cached before visibility was recorded are treated as public
+	assert.False(t, IsPrivateProfile(0))
+}
+
 func TestExtractSteamIDs(t *testing.T) {
 	steamIDs := []string{"76561198090461077", "76561198130544932"}
 	IDs, err := ExtractSteamIDs(steamIDs)
@@ -190,6 +204,113 @@ func TestIsValidFormatSteamIDWithInValidSteamID(t *testing.T) {
 	assert.False(t, isValid, fmt.Sprintf("expect to receive false for steamID: %s", invalidSteamID))
 }
 
+func TestParseSteamIDAcceptsEveryFormat(t *testing.T) {
+	expected := SteamID(76561197960287930)
+	for _, input := range []string{
+		"76561197960287930",
+		"STEAM_0:0:11101",
+		"STEAM_1:0:11101",
+		"[U:1:22202]",
+		"U:1:22202",
+		"22202",
+		"SUCVS-FADA",
+		"sucvs-fada",
+		"AAAA-SUCVS-FADA",
+		" 76561197960287930 ",
+	} {
+		id, err := ParseSteamID(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, expected, id, input)
+	}
+}
+
+func TestParseSteamIDRejectsInvalidInput(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"0",
+		"eeeeeeeee",
+		"765611980871696001",
+		"76561198087169600/",
+		"https://steamcommunity.com/profiles/76561198087169600",
+		"10000000000000000",
+		"STEAM_0:2:11101",
+		"[U:1:0]",
+		"U:1:22202]",
+		"[U:1:22202",
+		"[G:1:22202]",
+		"4294967296",
+		"SUCVS-FADB",
+	} {
+		_, err := ParseSteamID(input)
+		assert.True(t, errors.Is(err, ErrInvalidSteamID), input)
+	}
+}
+
+func TestSteamIDConversions(t *testing.T) {
+	id := NewSteamID(22202)
+
+	assert.Equal(t, "76561197960287930", id.String())
+	assert.Equal(t, uint32(22202), id.AccountID())
+	assert.Equal(t, "STEAM_0:0:11101", id.SteamID2())
+	assert.Equal(t, "[U:1:22202]", id.SteamID3())
+	assert.Equal(t, "SUCVS-FADA", id.FriendCode())
+	assert.True(t, id.IsValid())
+	assert.False(t, SteamID(0).IsValid())
+
+	// Every format should parse back to the same steamID
+	for _, input := range []string{id.SteamID2(), id.SteamID3(), id.FriendCode()} {
+		parsed, err := ParseSteamID(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, id, parsed, input)
+	}
+}
+
+func TestSteamIDJSONRoundTrip(t *testing.T) {
+	type account struct {
+		Steamid SteamID `json:"steamid"`
+	}
+	original := account{Steamid: 76561198087169600}
+	jsonObj, err := json.Marshal(original)
+	assert.Nil(t, err)
+	assert.Contains(t, string(jsonObj), `"steamid":"76561198087169600"`)
+
+	decoded := account{}
+	assert.Nil(t, json.Unmarshal(jsonObj, &decoded))
+	assert.Equal(t, original.Steamid, decoded.Steamid)
+
+	for input, expected := range map[string]SteamID{
+		`{"steamid":76561198087169600}`: 76561198087169600,
+		`{"steamid":"[U:1:22202]"}`:     76561197960287930,
+		`{"steamid":""}`:                0,
+		`{"steamid":"0"}`:               0,
+		`{"steamid":0}`:                 0,
+	} {
+		decoded = account{}
+		assert.Nil(t, json.Unmarshal([]byte(input), &decoded), input)
+		assert.Equal(t, expected, decoded.Steamid, input)
+	}
+	assert.NotNil(t, json.Unmarshal([]byte(`{"steamid":"not a steamID"}`), &decoded))
+}
+
+func TestZeroSteamIDJSONRoundTrip(t *testing.T) {
+	jsonObj, err := json.Marshal(SteamID(0))
+	assert.Nil(t, err)
+	assert.Equal(t, `""`, string(jsonObj))
+
+	decoded := SteamID(1)
+	assert.Nil(t, json.Unmarshal(jsonObj, &decoded))
+	assert.Equal(t, SteamID(0), decoded)
+}
+
+func TestFriendsListWithAMalformedSteamIDStillDecodes(t *testing.T) {
+	input := `{"friendslist":{"friends":[{"steamid":"76561198087169600"},{"steamid":"not a steamID"}]}}`
+
+	friends := FriendsStruct{}
+	assert.Nil(t, json.Unmarshal([]byte(input), &friends))
+	assert.Len(t, friends.FriendsList.Friends, 2)
+	assert.Equal(t, "76561198087169600", friends.FriendsList.Friends[0].Steamid)
+}
+
 func TestGetAndRead(t *testing.T) {
 	testURL := "https://pastebin.com/"
 	_, err := GetAndRead(testURL)
@@ -295,3 +416,351 @@ func TestGetAPIKeysWithNonExistantAPIKeysFile(t *testing.T) {
 func TestGivenOneSteamIDForSortingOneSteamIsReturned(t *testing.T) {
 
 }
+
+func TestKeyPoolHandsOutKeysRoundRobin(t *testing.T) {
+	pool := NewKeyPool([]string{"apiKey1", "apiKey2"})
+
+	for _, expectedKey := range []string{"apiKey1", "apiKey2", "apiKey1"} {
+		apiKey, err := pool.Acquire(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, expectedKey, apiKey)
+	}
+}
+
+func TestKeyPoolBacksOffRateLimitedKeys(t *testing.T) {
+	now := time.Now()
+	pool := NewKeyPool([]string{"apiKey1", "apiKey2"})
+	pool.now = func() time.Time { return now }
+
+	pool.Report("apiKey1", fmt.Errorf("%w: apiKey1", ErrRateLimited))
+	pool.Report("apiKey1", fmt.Errorf("%w: apiKey1", ErrRateLimited))
+
+	stats := pool.Stats()
+	assert.Equal(t, KeyThrottled, stats[0].Status)
+	assert.Equal(t, now.Add(2*defaultKeyBackoff), stats[0].ThrottledUntil)
+	assert.Equal(t, 2, stats[0].RateLimited)
+	assert.Equal(t, 1, pool.Healthy())
+
+	apiKey, err := pool.Acquire(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "apiKey2", apiKey)
+
+	now = now.Add(2 * defaultKeyBackoff)
+	pool.Report("apiKey1", nil)
+	assert.Equal(t, KeyHealthy, pool.Stats()[0].Status)
+	assert.Equal(t, 0, pool.keys[0].throttles)
+}
+
+func TestKeyPoolQuarantinesInvalidKeys(t *testing.T) {
+	pool := NewKeyPool([]string{"apiKey1"})
+
+	pool.Report("apiKey1", MakeErr(fmt.Errorf("%w: apiKey1", ErrInvalidKey)))
+
+	assert.Equal(t, KeyQuarantined, pool.Stats()[0].Status)
+	assert.Equal(t, "****Key1", pool.Stats()[0].Key)
+	_, err := pool.Acquire(context.Background())
+	assert.True(t, errors.Is(err, ErrNoValidKeys))
+}
+
+func TestKeyPoolAcquireStopsWhenContextIsCancelled(t *testing.T) {
+	pool := NewKeyPool([]string{"apiKey1"})
+	pool.Report("apiKey1", ErrRateLimited)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := pool.Acquire(ctx)
+
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestRateLimiterDelaysCallsOverThePerSecondLimit(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(RateLimit{PerSecond: 2}, RateLimit{})
+	limiter.now = func() time.Time { return now }
+
+	assert.Equal(t, time.Duration(0), limiter.reserve("apiKey1"))
+	assert.Equal(t, time.Duration(0), limiter.reserve("apiKey2"))
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve("apiKey1"))
+	assert.Equal(t, time.Second, limiter.reserve("apiKey2"))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve("apiKey1"))
+}
+
+func TestRateLimiterLimitsEachKeySeparately(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(RateLimit{}, RateLimit{PerSecond: 1, PerDay: 2})
+	limiter.now = func() time.Time { return now }
+
+	assert.Equal(t, time.Duration(0), limiter.reserve("apiKey1"))
+	assert.Equal(t, time.Duration(0), limiter.reserve("apiKey2"))
+	assert.Equal(t, time.Second, limiter.reserve("apiKey1"))
+
+	// The daily limit is used up so the next call has
+	// to wait for half a day's worth of refill
+	now = now.Add(time.Minute)
+	assert.Equal(t, 12*time.Hour-time.Minute, limiter.reserve("apiKey1").Round(time.Second))
+}
+
+func TestRateLimiterWaitSleepsForTheReservedTime(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(RateLimit{PerSecond: 1}, RateLimit{})
+	limiter.now = func() time.Time { return now }
+	slept := make([]time.Duration, 0)
+	limiter.sleep = func(ctx context.Context, duration time.Duration) error {
+		slept = append(slept, duration)
+		return nil
+	}
+
+	assert.Nil(t, limiter.Wait(context.Background(), "apiKey1"))
+	assert.Nil(t, limiter.Wait(context.Background(), "apiKey1"))
+	var nilLimiter *RateLimiter
+	assert.Nil(t, nilLimiter.Wait(context.Background(), "apiKey1"))
+
+	assert.Equal(t, []time.Duration{time.Second}, slept)
+}
+
+func TestRateLimiterWaitStopsWhenCancelled(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{}, RateLimit{PerDay: 1})
+	assert.Nil(t, limiter.Wait(context.Background(), "apiKey1"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The daily limit is used up so without the context
+	// being cancelled this would wait for a whole day
+	err := limiter.Wait(ctx, "apiKey1")
+
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestHTTPClientRetriesServerErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"response":{}}`))
+	}))
+	defer server.Close()
+
+	httpClient := NewHTTPClient(time.Second, 2)
+	slept := []time.Duration{}
+	httpClient.sleep = func(ctx context.Context, wait time.Duration) error {
+		slept = append(slept, wait)
+		return nil
+	}
+	beforeAttempts := 0
+
+	statusCode, body, err := httpClient.Get(context.Background(), server.URL, func() error {
+		beforeAttempts++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, `{"response":{}}`, string(body))
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 3, beforeAttempts)
+	// Backoff doubles with each retry and is jittered between half and all of it
+	assert.Len(t, slept, 2)
+	assert.True(t, slept[0] >= defaultRetryBackoff/2 && slept[0] <= defaultRetryBackoff)
+	assert.True(t, slept[1] >= defaultRetryBackoff && slept[1] <= 2*defaultRetryBackoff)
+}
+
+func TestHTTPClientGivesUpOnServerErrorsAfterRetrying(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	httpClient := NewHTTPClient(time.Second, 1)
+	httpClient.sleep = func(context.Context, time.Duration) error { return nil }
+	statusCode, _, err := httpClient.Get(context.Background(), server.URL, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, statusCode)
+	assert.Equal(t, 2, attempts)
+
+	// Final statuses are returned without retrying
+	attempts = 0
+	statusCode, _, err = httpClient.Get(context.Background(), server.URL, nil, http.StatusInternalServerError)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, statusCode)
+	assert.Equal(t, 1, attempts)
+
+	// Network errors are returned once every attempt has failed
+	server.Close()
+	_, _, err = httpClient.Get(context.Background(), server.URL, nil)
+	assert.NotNil(t, err)
+}
+
+func TestHTTPClientStopsRetryingWhenCancelled(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	httpClient := NewHTTPClient(time.Second, 5)
+	httpClient.sleep = func(ctx context.Context, wait time.Duration) error {
+		cancel()
+		return sleepContext(ctx, wait)
+	}
+	_, _, err := httpClient.Get(ctx, server.URL, nil)
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestCheckResponseReturnsSentinelErrors(t *testing.T) {
+	assert.Nil(t, checkResponse(http.StatusOK, nil, "apiKey"))
+	assert.True(t, errors.Is(checkResponse(http.StatusTooManyRequests, nil, "apiKey"), ErrRateLimited))
+	assert.True(t, errors.Is(checkResponse(http.StatusForbidden, nil, "apiKey"), ErrInvalidKey))
+	assert.True(t, errors.Is(checkResponse(http.StatusUnauthorized, nil, "apiKey"), ErrPrivateProfile))
+	assert.True(t, errors.Is(checkResponse(http.StatusNotFound, nil, "apiKey"), ErrNotFound))
+	assert.NotNil(t, checkResponse(http.StatusServiceUnavailable, []byte("down"), "apiKey"))
+	// API keys are never put in errors in full
+	assert.NotContains(t, checkResponse(http.StatusForbidden, nil, "secretAPIKey").Error(), "secretAPIKey")
+}
+
+func TestControllerCallsTheConfiguredSteamAPI(t *testing.T) {
+	requestedPaths := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPaths = append(requestedPaths, r.URL.Path)
+		if r.URL.Query().Get("steamid") == "76561198130544932" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"friendslist":{"friends":[{"steamid":"76561198130544932","relationship":"friend"}]}}`))
+	}))
+	defer server.Close()
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.SteamAPIScheme = "http"
+	configuration.AppConfig.SteamAPIHost = strings.TrimPrefix(server.URL, "http://") + "/mirror"
+
+	cntr := Controller{HTTP: NewHTTPClient(time.Second, 0)}
+	friends, err := cntr.CallGetFriendsListAPI(context.Background(), "76561198282036055", "apiKey")
+	assert.Nil(t, err)
+	assert.Len(t, friends.FriendsList.Friends, 1)
+	assert.Equal(t, []string{"/mirror/ISteamUser/GetFriendList/v0001/"}, requestedPaths)
+
+	_, err = cntr.CallGetFriendsListAPI(context.Background(), "76561198130544932", "apiKey")
+	assert.True(t, errors.Is(err, ErrPrivateProfile))
+}
+
+func TestControllerDoesNotRetryFriendListsOfUnknownSteamIDs(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.SteamAPIScheme = "http"
+	configuration.AppConfig.SteamAPIHost = strings.TrimPrefix(server.URL, "http://")
+
+	cntr := Controller{HTTP: NewHTTPClient(time.Second, 3)}
+	_, err := cntr.CallGetFriendsListAPI(context.Background(), "76561198282036055", "apiKey")
+
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestParseSteamInputAcceptsIDsLinksAndCustomNames(t *testing.T) {
+	for input, expected := range map[string][2]string{
+		"76561198090461077": {"76561198090461077", ""},
+		"https://steamcommunity.com/profiles/76561198090461077/": {"76561198090461077", ""},
+		"steamcommunity.com/profiles/76561198090461077":          {"76561198090461077", ""},
+		"http://steamcommunity.com/id/gabelogannewell":           {"", "gabelogannewell"},
+		" www.steamcommunity.com/id/some_name/games ":            {"", "some_name"},
+		"some-name":       {"", "some-name"},
+		"STEAM_0:0:11101": {"76561197960287930", ""},
+		"[U:1:22202]":     {"76561197960287930", ""},
+		"7":               {"76561197960265735", ""},
+		// Account IDs and friend codes could also be custom profile names
+		"SUCVS-FADA": {"76561197960287930", "SUCVS-FADA"},
+		"22202":      {"76561197960287930", "22202"},
+	} {
+		steamID, vanityName, err := ParseSteamInput(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, expected[0], steamID, input)
+		assert.Equal(t, expected[1], vanityName, input)
+	}
+
+	for _, input := range []string{"", "a", "not a name", "https://steamcommunity.com/groups/something", "https://steamcommunity.com/profiles/123"} {
+		_, _, err := ParseSteamInput(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestResolveSteamIDCachesCustomNames(t *testing.T) {
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.VanityURLsLocation = filepath.Join(t.TempDir(), "vanityURLs.json")
+
+	resolved := ResolveVanityURLStruct{}
+	resolved.Response.SteamID = "76561197960287930"
+	resolved.Response.Success = 1
+	noMatch := ResolveVanityURLStruct{}
+	noMatch.Response.Success = 42
+	mockController := &MockControllerInterface{}
+	mockController.On("CallResolveVanityURLAPI", mock.Anything, "gabelogannewell", "apiKey").Return(resolved, nil)
+	mockController.On("CallResolveVanityURLAPI", mock.Anything, "nobody", "apiKey").Return(noMatch, nil)
+
+	assert.True(t, NeedsVanityLookup([]string{"76561198090461077", "gabelogannewell"}))
+	steamIDs, err := ResolveSteamIDs(context.Background(), mockController, "apiKey", []string{"76561198090461077", "https://steamcommunity.com/id/gabelogannewell/"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"76561198090461077", "76561197960287930"}, steamIDs)
+
+	// Later lookups of the same name in any case come from the cache
+	steamID, err := ResolveSteamID(context.Background(), mockController, "apiKey", "GabeLoganNewell")
+	assert.Nil(t, err)
+	assert.Equal(t, "76561197960287930", steamID)
+	assert.False(t, NeedsVanityLookup([]string{"gabelogannewell"}))
+	mockController.AssertNumberOfCalls(t, "CallResolveVanityURLAPI", 1)
+
+	for i := 0; i < 2; i++ {
+		_, err = ResolveSteamID(context.Background(), mockController, "apiKey", "nobody")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+	mockController.AssertNumberOfCalls(t, "CallResolveVanityURLAPI", 2)
+
+	content, err := ioutil.ReadFile(configuration.AppConfig.VanityURLsLocation)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), `"gabelogannewell": "76561197960287930"`)
+	assert.NotContains(t, string(content), "nobody")
+}
+
+func TestResolveSteamIDLooksUpNumbersAsCustomNamesFirst(t *testing.T) {
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.VanityURLsLocation = filepath.Join(t.TempDir(), "vanityURLs.json")
+
+	resolved := ResolveVanityURLStruct{}
+	resolved.Response.SteamID = "76561198090461077"
+	resolved.Response.Success = 1
+	noMatch := ResolveVanityURLStruct{}
+	noMatch.Response.Success = 42
+	mockController := &MockControllerInterface{}
+	mockController.On("CallResolveVanityURLAPI", mock.Anything, "1234567", "apiKey").Return(resolved, nil)
+	mockController.On("CallResolveVanityURLAPI", mock.Anything, "22202", "apiKey").Return(noMatch, nil)
+
+	assert.True(t, NeedsVanityLookup([]string{"1234567"}))
+	steamID, err := ResolveSteamID(context.Background(), mockController, "apiKey", "1234567")
+	assert.Nil(t, err)
+	assert.Equal(t, "76561198090461077", steamID)
+
+	// A number no account has as its custom name is taken as an account ID, cached or not
+	for i := 0; i < 2; i++ {
+		steamID, err = ResolveSteamID(context.Background(), mockController, "apiKey", "22202")
+		assert.Nil(t, err)
+		assert.Equal(t, "76561197960287930", steamID)
+	}
+	mockController.AssertNumberOfCalls(t, "CallResolveVanityURLAPI", 2)
+}
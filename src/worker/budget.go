@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+// countingController counts every call made to the Steam web API so that
+// a crawl can be stopped once its API call budget runs out. Calls are also
+// counted per API key for the crawl's stats
+type countingController struct {
+	util.ControllerInterface
+	mutex  sync.Mutex
+	calls  int
+	perKey map[string]int
+}
+
+func (cntr *countingController) count(apiKey string) {
+	cntr.mutex.Lock()
+	defer cntr.mutex.Unlock()
+	cntr.calls++
+	if cntr.perKey == nil {
+		cntr.perKey = make(map[string]int)
+	}
+	cntr.perKey[util.RedactKey(apiKey)]++
+}
+
+func (cntr *countingController) CallPlayerSummaryAPI(ctx context.Context, steamID, apiKey string) (util.UserStatsStruct, error) {
+	cntr.count(apiKey)
+	return cntr.ControllerInterface.CallPlayerSummaryAPI(ctx, steamID, apiKey)
+}
+
+func (cntr *countingController) CallIsAPIKeyValidAPI(ctx context.Context, apiKey string) (string, error) {
+	cntr.count(apiKey)
+	return cntr.ControllerInterface.CallIsAPIKeyValidAPI(ctx, apiKey)
+}
+
+func (cntr *countingController) CallGetFriendsListAPI(ctx context.Context, steamID, apiKey string) (util.FriendsStruct, error) {
+	cntr.count(apiKey)
+	return cntr.ControllerInterface.CallGetFriendsListAPI(ctx, steamID, apiKey)
+}
+
+func (cntr *countingController) CallGetOwnedGamesAPI(ctx context.Context, steamID, apiKey string) (util.GamesStruct, error) {
+	cntr.count(apiKey)
+	return cntr.ControllerInterface.CallGetOwnedGamesAPI(ctx, steamID, apiKey)
+}
+
+func (cntr *countingController) CallGetRecentlyPlayedGamesAPI(ctx context.Context, steamID, apiKey string) (util.GamesStruct, error) {
+	cntr.count(apiKey)
+	return cntr.ControllerInterface.CallGetRecentlyPlayedGamesAPI(ctx, steamID, apiKey)
+}
+
+func (cntr *countingController) apiCalls() int {
+	cntr.mutex.Lock()
+	defer cntr.mutex.Unlock()
+	return cntr.calls
+}
+
+// apiCallsPerKey returns how many calls were made with each API key.
+// The keys are redacted so the counts can be saved safely
+func (cntr *countingController) apiCallsPerKey() map[string]int {
+	cntr.mutex.Lock()
+	defer cntr.mutex.Unlock()
+	perKey := make(map[string]int, len(cntr.perKey))
+	for apiKey, calls := range cntr.perKey {
+		perKey[apiKey] = calls
+	}
+	return perKey
+}
+
+// CrawlBudget tracks how much of a crawl's budgets have been used up. Sharing one
+// between the crawls of several seed users makes their budgets cover all of them
+// together instead of each crawl separately
+type CrawlBudget struct {
+	mutex        sync.Mutex
+	startedAt    time.Time
+	usersCrawled int
+	apiCalls     int
+}
+
+// NewCrawlBudget creates a CrawlBudget whose duration budget starts now
+func NewCrawlBudget() *CrawlBudget {
+	return &CrawlBudget{startedAt: time.Now()}
+}
+
+// used returns how many users and API calls crawls sharing the budget have used up
+func (budget *CrawlBudget) used() (usersCrawled, apiCalls int) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	return budget.usersCrawled, budget.apiCalls
+}
+
+// spend records the users and API calls a finished crawl used up
+func (budget *CrawlBudget) spend(usersCrawled, apiCalls int) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.usersCrawled += usersCrawled
+	budget.apiCalls += apiCalls
+}
+
+// timeLeft returns how much of the max duration budget is left
+func (budget *CrawlBudget) timeLeft(maxDuration time.Duration) time.Duration {
+	return time.Until(budget.startedAt.Add(maxDuration))
+}
+
+// exceededBudget returns which budget has run out given how many users have been
+// crawled and how many API calls have been made. It's empty if none have run out
+func (cfg CrawlerConfig) exceededBudget(usersCrawled, apiCalls int) string {
+	if cfg.MaxUsers > 0 && usersCrawled >= cfg.MaxUsers {
+		return fmt.Sprintf("the max users budget of %d was reached", cfg.MaxUsers)
+	}
+	if cfg.MaxAPICalls > 0 && apiCalls >= cfg.MaxAPICalls {
+		return fmt.Sprintf("the max API calls budget of %d was reached", cfg.MaxAPICalls)
+	}
+	return ""
+}
+
+// canDispatch reports whether another job can be handed out without going over the
+// max users budget once the jobs the workers are in the middle of have finished
+func (cfg CrawlerConfig) canDispatch(usersCrawled, inFlight int) bool {
+	return cfg.MaxUsers <= 0 || usersCrawled+inFlight < cfg.MaxUsers
+}
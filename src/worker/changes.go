@@ -0,0 +1,250 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/logging"
+	"github.com/steamFriendsGraphing/util"
+)
+
+// changeHistoryMutex stops two crawls appending to the same history at once
+var changeHistoryMutex sync.Mutex
+
+// FriendListChange is what changed in a user's friend list
+// between the cached fetch and a fresh fetch replacing it
+type FriendListChange struct {
+	SteamID  string `json:"steamID"`
+	Username string `json:"username"`
+	// PreviousFetch is when the replaced friend list was fetched,
+	// zero if it was cached before fetch times were recorded
+	PreviousFetch time.Time     `json:"previousFetch"`
+	DetectedAt    time.Time     `json:"detectedAt"`
+	Added         []util.Friend `json:"added,omitempty"`
+	Removed       []util.Friend `json:"removed,omitempty"`
+	// Renamed holds the user and any of their friends
+	// whose personaname changed between the fetches
+	Renamed []Rename `json:"renamed,omitempty"`
+}
+
+// Rename is a change of personaname
+type Rename struct {
+	SteamID string `json:"steamID"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// IsEmpty checks whether nothing changed
+func (change FriendListChange) IsEmpty() bool {
+	return len(change.Added) == 0 && len(change.Removed) == 0 && len(change.Renamed) == 0
+}
+
+// String gives a one line summary of the change
+func (change FriendListChange) String() string {
+	return fmt.Sprintf("%s %s (%s): %d added, %d removed, %d renamed",
+		change.DetectedAt.Format("2006-01-02 15:04"), change.SteamID, change.Username,
+		len(change.Added), len(change.Removed), len(change.Renamed))
+}
+
+// diffFriendLists works out the friends added, removed and renamed between two fetches
+// of a user's friend list. Renames are only recorded when both names are known
+func diffFriendLists(steamID string, previous, current util.FriendsStruct) FriendListChange {
+	change := FriendListChange{
+		SteamID:       steamID,
+		Username:      current.Username,
+		PreviousFetch: previous.FetchedAt,
+		DetectedAt:    time.Now(),
+	}
+	if previous.Username != "" && current.Username != "" && previous.Username != current.Username {
+		change.Renamed = append(change.Renamed, Rename{SteamID: steamID, From: previous.Username, To: current.Username})
+	}
+
+	previousFriends := make(map[string]util.Friend, len(previous.FriendsList.Friends))
+	for _, friend := range previous.FriendsList.Friends {
+		previousFriends[friend.Steamid] = friend
+	}
+	currentFriends := make(map[string]bool, len(current.FriendsList.Friends))
+	for _, friend := range current.FriendsList.Friends {
+		currentFriends[friend.Steamid] = true
+		previousFriend, existed := previousFriends[friend.Steamid]
+		if !existed {
+			change.Added = append(change.Added, friend)
+			continue
+		}
+		if previousFriend.Username != "" && friend.Username != "" && previousFriend.Username != friend.Username {
+			change.Renamed = append(change.Renamed, Rename{SteamID: friend.Steamid, From: previousFriend.Username, To: friend.Username})
+		}
+	}
+	for _, friend := range previous.FriendsList.Friends {
+		if !currentFriends[friend.Steamid] {
+			change.Removed = append(change.Removed, friend)
+		}
+	}
+	return change
+}
+
+// recordFriendListChanges compares a freshly fetched friend list against the one cached
+// for the user and appends anything that changed to their change history. Failures are
+// logged rather than returned so they never fail the crawl
+func recordFriendListChanges(cntr util.ControllerInterface, steamID string, current util.FriendsStruct) {
+	previous, err := GetCache(cntr, steamID)
+	if err != nil {
+		logging.SpecialLog(cntr, "errorLog", err.Error())
+		return
+	}
+	change := diffFriendLists(steamID, previous, current)
+	if change.IsEmpty() {
+		return
+	}
+	err = AppendChangeHistory(change)
+	if err != nil {
+		logging.SpecialLog(cntr, "errorLog", err.Error())
+	}
+}
+
+// changeHistoryFileName is where the change history of a user is kept
+func changeHistoryFileName(steamID string) string {
+	return filepath.Join(configuration.AppConfig.ChangesLocation, fmt.Sprintf("%s.jsonl", steamID))
+}
+
+// AppendChangeHistory adds a change to the end of the user's change history
+func AppendChangeHistory(change FriendListChange) error {
+	changesFolder := configuration.AppConfig.ChangesLocation
+	if changesFolder == "" {
+		return util.MakeErr(errors.New("configuration.AppConfig.ChangesLocation was not initialised before attempting to record a change"))
+	}
+	jsonObj, err := json.Marshal(change)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+
+	changeHistoryMutex.Lock()
+	defer changeHistoryMutex.Unlock()
+	err = os.MkdirAll(changesFolder, 0755)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	file, err := os.OpenFile(changeHistoryFileName(change.SteamID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	_, err = file.Write(append(jsonObj, '\n'))
+	if err != nil {
+		file.Close()
+		return util.MakeErr(err)
+	}
+	err = file.Close()
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	return nil
+}
+
+// LoadChangeHistory loads every change recorded for a user, oldest first.
+// A user with no recorded changes has an empty history
+func LoadChangeHistory(steamID string) ([]FriendListChange, error) {
+	history := make([]FriendListChange, 0)
+	file, err := os.Open(changeHistoryFileName(steamID))
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return history, util.MakeErr(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		change := FriendListChange{}
+		err = json.Unmarshal(scanner.Bytes(), &change)
+		if err != nil {
+			return history, util.MakeErr(err)
+		}
+		history = append(history, change)
+	}
+	if err := scanner.Err(); err != nil {
+		return history, util.MakeErr(err)
+	}
+	return history, nil
+}
+
+// LoadGraphChangeHistory loads the change history of every user in a saved graph, oldest first
+func LoadGraphChangeHistory(cntr util.ControllerInterface, graphID string) ([]FriendListChange, error) {
+	visited, err := graphUsers(cntr, graphID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]FriendListChange, 0)
+	for steamID := range visited {
+		userHistory, err := LoadChangeHistory(steamID)
+		if err != nil {
+			return history, err
+		}
+		history = append(history, userHistory...)
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		if history[i].DetectedAt.Equal(history[j].DetectedAt) {
+			return history[i].SteamID < history[j].SteamID
+		}
+		return history[i].DetectedAt.Before(history[j].DetectedAt)
+	})
+	return history, nil
+}
+
+// graphUsers finds every user in a saved graph by walking the cached friend
+// lists out from the graph's seed users up to the level it was crawled to
+func graphUsers(cntr util.ControllerInterface, graphID string) (map[string]bool, error) {
+	steamIDs, levelCap, err := GetRefreshDetails(graphID)
+	if err != nil {
+		return nil, err
+	}
+
+	visited := make(map[string]bool)
+	level := steamIDs
+	for depth := 1; len(level) > 0 && depth <= levelCap; depth++ {
+		nextLevel := []string{}
+		for _, steamID := range level {
+			if visited[steamID] {
+				continue
+			}
+			visited[steamID] = true
+			if depth == levelCap {
+				continue
+			}
+			friends, err := GetCache(cntr, steamID)
+			if err != nil {
+				continue
+			}
+			for _, friend := range friends.FriendsList.Friends {
+				nextLevel = append(nextLevel, friend.Steamid)
+			}
+		}
+		level = nextLevel
+	}
+	return visited, nil
+}
+
+// ChangeHistory loads the change history of either a saved graph, given its
+// ID, or a single user, given their steamID
+func ChangeHistory(cntr util.ControllerInterface, id string) ([]FriendListChange, error) {
+	if _, err := crawlIdentifier(id); err == nil {
+		return LoadGraphChangeHistory(cntr, id)
+	}
+	if !util.IsValidFormatSteamID(id) {
+		return nil, util.MakeErr(fmt.Errorf("%s is neither a graph ID nor a steamID", id))
+	}
+	return LoadChangeHistory(id)
+}
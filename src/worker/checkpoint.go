@@ -0,0 +1,314 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/util"
+)
+
+// defaultCheckpointInterval is used when no checkpoint interval
+// is given in the CrawlerConfig
+const defaultCheckpointInterval = 30 * time.Second
+
+// Checkpoint is a snapshot of an in progress crawl. ControlFunc saves one
+// to disk at regular intervals so that a crawl that dies part way through
+// can be picked back up from where it stopped
+type Checkpoint struct {
+	CrawlID  string `json:"crawlID"`
+	SteamID  string `json:"steamID"`
+	LevelCap int    `json:"levelCap"`
+	// Frontier holds every job that was queued or being processed
+	// when the checkpoint was taken. API keys are not saved
+	Frontier []JobsStruct `json:"frontier"`
+	// Visited maps every user that has been crawled to the
+	// level they were crawled at
+	Visited map[string]int `json:"visited"`
+	// Seen maps every user that has been queued to the
+	// lowest level they were queued at
+	Seen              map[string]int `json:"seen"`
+	DuplicatesAvoided int            `json:"duplicatesAvoided"`
+	PrivateFriends    int            `json:"privateFriends"`
+	FriendsPerLevel   map[int]int    `json:"friendsPerLevel"`
+	TotalFriends      int            `json:"totalFriends"`
+	ReachableFriends  int            `json:"reachableFriends"`
+	// Skipped holds the friends of each user that
+	// were left out by the sampling policy
+	Skipped util.SkippedFriends `json:"skipped,omitempty"`
+	// Failures holds the users that have already
+	// failed and won't be retried on resume
+	Failures util.CrawlFailures `json:"failures"`
+	SavedAt  int64              `json:"savedAt"`
+}
+
+// crawlState is the live state of a crawl kept by ControlFunc. Jobs are
+// tracked from when they are queued up until their result comes back
+// so that a checkpoint can be taken at any point
+type crawlState struct {
+	Checkpoint
+	pending      map[JobsStruct]int
+	pendingCount int
+	// levelPending is how many jobs are pending on each level
+	levelPending map[int]int
+}
+
+func newCrawlState(crawlID, steamID string, levelCap int) *crawlState {
+	state := &crawlState{
+		Checkpoint: Checkpoint{
+			CrawlID:         crawlID,
+			SteamID:         steamID,
+			LevelCap:        levelCap,
+			Visited:         make(map[string]int),
+			Seen:            make(map[string]int),
+			FriendsPerLevel: make(map[int]int),
+			Skipped:         make(util.SkippedFriends),
+		},
+		pending:      make(map[JobsStruct]int),
+		levelPending: make(map[int]int),
+	}
+	state.Seen[steamID] = 1
+	state.Frontier = []JobsStruct{
+		{
+			OriginalTargetUserSteamID: toSteamID(steamID),
+			Level:                     1,
+			CurrentTargetSteamID:      toSteamID(steamID),
+		},
+	}
+	state.FriendsPerLevel[1]++
+	return state
+}
+
+// initCrawlState returns the state a crawl should start with. If a resume was
+// requested and a checkpoint exists for this user the crawl continues from it
+func initCrawlState(cfg CrawlerConfig, steamID string) (*crawlState, error) {
+	if cfg.Resume && cfg.CrawlID != "" {
+		checkpoint, exists, err := LoadCheckpoint(cfg.CrawlID, steamID)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			state := &crawlState{
+				Checkpoint:   checkpoint,
+				pending:      make(map[JobsStruct]int),
+				levelPending: make(map[int]int),
+			}
+			return state, nil
+		}
+	}
+	return newCrawlState(cfg.CrawlID, steamID, cfg.Level), nil
+}
+
+// add marks a job as queued
+func (state *crawlState) add(job JobsStruct) {
+	state.pending[job]++
+	state.pendingCount++
+	state.levelPending[job.Level]++
+}
+
+// done marks a job as no longer queued
+func (state *crawlState) done(job JobsStruct) {
+	if state.pending[job] > 0 {
+		state.pending[job]--
+		state.pendingCount--
+		state.levelPending[job.Level]--
+		if state.pending[job] == 0 {
+			delete(state.pending, job)
+		}
+	}
+}
+
+// markSeen records that a user has been reached at the given level. It returns
+// false if the user was already queued at that level or lower and so shouldn't be
+// queued again. A user reached at a lower level is queued again so that their
+// friends are crawled to the full depth
+func (state *crawlState) markSeen(job JobsStruct) bool {
+	if level, seen := state.Seen[job.CurrentTargetSteamID.String()]; seen && level <= job.Level {
+		state.DuplicatesAvoided++
+		return false
+	}
+	state.Seen[job.CurrentTargetSteamID.String()] = job.Level
+	return true
+}
+
+// finish marks a job as processed and records the user as visited
+func (state *crawlState) finish(job JobsStruct) {
+	state.done(job)
+	if level, visited := state.Visited[job.CurrentTargetSteamID.String()]; !visited || job.Level < level {
+		state.Visited[job.CurrentTargetSteamID.String()] = job.Level
+	}
+}
+
+// recordResult records a user that was crawled along with every friend they have. The
+// friends within levelCap that haven't been seen yet are returned to be crawled next
+func (state *crawlState) recordResult(result jobResult, levelCap int) []JobsStruct {
+	state.finish(result.job)
+	state.PrivateFriends += len(result.privateFriends)
+	for _, friend := range result.privateFriends {
+		state.TotalFriends++
+		state.FriendsPerLevel[friend.Level]++
+	}
+	if len(result.skippedFriends) > 0 {
+		state.Skipped[result.job.CurrentTargetSteamID.String()] = result.skippedFriends
+		state.TotalFriends += len(result.skippedFriends)
+		state.FriendsPerLevel[result.job.Level+1] += len(result.skippedFriends)
+	}
+	newJobs := make([]JobsStruct, 0)
+	for _, friend := range result.friends {
+		state.TotalFriends++
+		state.FriendsPerLevel[friend.Level]++
+
+		if friend.Level <= levelCap {
+			state.ReachableFriends++
+			if state.markSeen(friend) {
+				newJobs = append(newJobs, friend)
+			}
+		}
+	}
+	return newJobs
+}
+
+// checkpoint takes a snapshot of the current crawl state
+func (state *crawlState) checkpoint() Checkpoint {
+	checkpoint := state.Checkpoint
+	checkpoint.Frontier = make([]JobsStruct, 0, state.pendingCount)
+	for job, count := range state.pending {
+		job.APIKey = ""
+		for i := 0; i < count; i++ {
+			checkpoint.Frontier = append(checkpoint.Frontier, job)
+		}
+	}
+	checkpoint.SavedAt = time.Now().Unix()
+	return checkpoint
+}
+
+func checkpointFileName(crawlID, steamID string) string {
+	return filepath.Join(configuration.AppConfig.CheckpointsLocation, fmt.Sprintf("%s_%s.json", crawlID, steamID))
+}
+
+// SaveCheckpoint writes a checkpoint to disk. The checkpoint is written to a
+// temporary file first so a crash mid write never corrupts the last good one
+func SaveCheckpoint(checkpoint Checkpoint) error {
+	checkpointsFolder := configuration.AppConfig.CheckpointsLocation
+	if checkpointsFolder == "" {
+		return util.MakeErr(errors.New("configuration.AppConfig.CheckpointsLocation was not initialised before attempting to save checkpoint"))
+	}
+	if checkpoint.CrawlID == "" {
+		return util.MakeErr(errors.New("cannot save a checkpoint without a crawl ID"))
+	}
+	err := os.MkdirAll(checkpointsFolder, 0755)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+
+	jsonObj, err := json.Marshal(checkpoint)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	fileName := checkpointFileName(checkpoint.CrawlID, checkpoint.SteamID)
+	err = ioutil.WriteFile(fileName+".tmp", jsonObj, 0644)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	err = os.Rename(fileName+".tmp", fileName)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	return nil
+}
+
+// LoadCheckpoint loads the checkpoint saved for a given user under a crawl ID.
+// The returned bool is false if no checkpoint has been saved
+func LoadCheckpoint(crawlID, steamID string) (Checkpoint, bool, error) {
+	checkpoint := Checkpoint{}
+	content, err := ioutil.ReadFile(checkpointFileName(crawlID, steamID))
+	if os.IsNotExist(err) {
+		return checkpoint, false, nil
+	}
+	if err != nil {
+		return checkpoint, false, util.MakeErr(err)
+	}
+	err = json.Unmarshal(content, &checkpoint)
+	if err != nil {
+		return checkpoint, false, util.MakeErr(err)
+	}
+	if checkpoint.Visited == nil {
+		checkpoint.Visited = make(map[string]int)
+	}
+	if checkpoint.Seen == nil {
+		checkpoint.Seen = make(map[string]int)
+		for _, job := range checkpoint.Frontier {
+			checkpoint.Seen[job.CurrentTargetSteamID.String()] = job.Level
+		}
+	}
+	if checkpoint.FriendsPerLevel == nil {
+		checkpoint.FriendsPerLevel = make(map[int]int)
+	}
+	if checkpoint.Skipped == nil {
+		checkpoint.Skipped = make(util.SkippedFriends)
+	}
+	return checkpoint, true, nil
+}
+
+// LoadCheckpoints loads every checkpoint saved under a crawl ID
+func LoadCheckpoints(crawlID string) ([]Checkpoint, error) {
+	checkpoints := make([]Checkpoint, 0)
+	fileNames, err := filepath.Glob(checkpointFileName(crawlID, "*"))
+	if err != nil {
+		return checkpoints, util.MakeErr(err)
+	}
+	for _, fileName := range fileNames {
+		steamID := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(fileName), crawlID+"_"), ".json")
+		checkpoint, exists, err := LoadCheckpoint(crawlID, steamID)
+		if err != nil {
+			return checkpoints, err
+		}
+		if exists {
+			checkpoints = append(checkpoints, checkpoint)
+		}
+	}
+	return checkpoints, nil
+}
+
+// RemoveCheckpoint deletes the checkpoint for a user once their crawl has finished
+func RemoveCheckpoint(crawlID, steamID string) error {
+	err := os.Remove(checkpointFileName(crawlID, steamID))
+	if err != nil && !os.IsNotExist(err) {
+		return util.MakeErr(err)
+	}
+	return nil
+}
+
+// GetResumeDetails finds the steamIDs and level of a crawl that was
+// interrupted so it can be started again with -resume
+func GetResumeDetails(crawlID string) ([]string, int, error) {
+	identifier, err := crawlIdentifier(crawlID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	checkpoints, err := LoadCheckpoints(crawlID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(checkpoints) == 0 {
+		return nil, 0, fmt.Errorf("no checkpoint has been saved for crawl %s", crawlID)
+	}
+	return strings.Split(identifier, ","), checkpoints[0].LevelCap, nil
+}
+
+// crawlIdentifier finds the steamIDs identifier a crawl ID was generated for
+func crawlIdentifier(crawlID string) (string, error) {
+	for key, val := range configuration.AppConfig.UrlMap {
+		if val == crawlID {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("no crawl with ID %s exists", crawlID)
+}
@@ -1,6 +1,8 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/go-echarts/go-echarts/charts"
@@ -9,73 +11,114 @@ import (
 	"github.com/steamFriendsGraphing/util"
 )
 
-// CrawlOneUser crawls a single user and generates a graph the specified users friend network
-func CrawlOneUser(steamID string, cntr util.ControllerInterface, config CrawlerConfig) error {
+// CrawlOneUser crawls a single user and generates a graph the specified users friend network.
+// If ctx is cancelled the crawl is checkpointed and no graph is generated. If a budget stops
+// the crawl the partial graph is generated with a note saying which budget stopped it
+func CrawlOneUser(ctx context.Context, steamID string, cntr util.ControllerInterface, config CrawlerConfig) error {
+	if config.Plan {
+		fmt.Print(PlanCrawl(cntr, config, []string{steamID}))
+		return nil
+	}
 	finishedGraphLocation := ""
+	var failures util.CrawlFailures
+	stoppedBy := ""
 
 	userHasBeenGraphedBefore := util.IsKeyInUrlMap(steamID)
-	if !userHasBeenGraphedBefore || configuration.AppConfig.AlwaysCrawl {
-		GenerateURL(steamID)
+	if !userHasBeenGraphedBefore || configuration.AppConfig.AlwaysCrawl || config.Resume || config.Refresh {
+		// A resumed or refreshed crawl carries on under the ID it was first given
+		if !config.Resume && !config.Refresh {
+			GenerateURL(steamID)
+		}
+		config.CrawlID = configuration.AppConfig.UrlMap[steamID]
+
+		crawlResult, err := InitCrawling(ctx, cntr, config, steamID)
+		if err != nil {
+			return err
+		}
+		printCrawlSummary(crawlResult)
+		failures = crawlResult.Failures
+		stoppedBy = crawlResult.StoppedBy
+		err = enrichCrawl(ctx, cntr, config, crawlResult)
+		if err != nil {
+			return err
+		}
 
-		InitCrawling(cntr, config, steamID)
-		gData, err := graphing.InitGraphing(cntr, config.Level, config.Workers, steamID)
+		gData, err := graphing.InitGraphing(ctx, cntr, config.Level, config.Workers, steamID, crawlResult.Skipped)
 		if err != nil {
 			return err
 		}
+		gData.Note = partialGraphNote(stoppedBy)
+		applyGameOverlay(cntr, config, gData)
 
 		finishedGraphLocation = fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, configuration.AppConfig.UrlMap[steamID])
 		err = gData.Render(finishedGraphLocation)
 		if err != nil {
 			return err
 		}
+		err = SaveCrawlStats(configuration.AppConfig.UrlMap[steamID], []CrawlStats{crawlResult.Stats})
+		if err != nil {
+			return err
+		}
+		// A user that was graphed before keeps the report page of the crawl that graphed them
+		err = graphing.GenerateReportPage(cntr, configuration.AppConfig.UrlMap[steamID], failures, gData.Note)
+		if err != nil {
+			return err
+		}
 	}
 
 	finishedGraphLocation = fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, configuration.AppConfig.UrlMap[steamID])
 	// fmt.Printf("Saved as %s.html\n", finishedGraphLocation)
 
-	graphing.GenerateGraphPage(cntr, configuration.AppConfig.UrlMap[steamID])
 	return nil
 }
 
-// CrawlOneUser crawls two users and generates a unified graph of their friend networks if possible
-func CrawlTwoUsers(steamID1, steamID2 string, urlMapping map[string]string, cntr util.ControllerInterface, config CrawlerConfig) error {
+// CrawlUsers crawls any number of seed users and generates a graph of their friend networks.
+// Repeated steamIDs are ignored so one user given twice is graphed as a single user
+func CrawlUsers(ctx context.Context, steamIDs []string, urlMapping map[string]string, cntr util.ControllerInterface, config CrawlerConfig) error {
+	steamIDs = dedupeSteamIDs(steamIDs)
+	switch len(steamIDs) {
+	case 0:
+		return util.MakeErr(errors.New("no steamIDs were given to crawl"))
+	case 1:
+		return CrawlOneUser(ctx, steamIDs[0], cntr, config)
+	case 2:
+		return CrawlTwoUsers(ctx, steamIDs[0], steamIDs[1], urlMapping, cntr, config)
+	}
+	return CrawlManyUsers(ctx, steamIDs, urlMapping, cntr, config)
+}
+
+// CrawlTwoUsers crawls two users and generates a unified graph of their friend networks if possible.
+// If ctx is cancelled the crawl is checkpointed and no graph is generated
+func CrawlTwoUsers(ctx context.Context, steamID1, steamID2 string, urlMapping map[string]string, cntr util.ControllerInterface, config CrawlerConfig) error {
+	if config.Plan {
+		fmt.Print(PlanCrawl(cntr, config, []string{steamID1, steamID2}))
+		return nil
+	}
 	steamIDsIdentifier, err := getSteamIDsIdentifier([]string{steamID1, steamID2}, urlMapping)
 	if err != nil {
 		return err
 	}
-	finishedGraphLocation := ""
-
-	if usersHaveBeenGraphedBefore := util.IsKeyInUrlMap(steamIDsIdentifier); !usersHaveBeenGraphedBefore {
-		GenerateURL(steamIDsIdentifier)
-		finishedGraphLocation = fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, urlMapping[steamIDsIdentifier])
+	// Both crawls share their player summaries so that
+	// friends in common are only summarised once
+	if config.Profiles == nil {
+		config.Profiles = NewProfileStore()
+	}
 
-		InitCrawling(cntr, config, steamID1)
-		InitCrawling(cntr, config, steamID2)
+	if config.PathFirst {
+		return crawlPathFirst(ctx, steamID1, steamID2, steamIDsIdentifier, cntr, config)
+	}
 
-		StartUserGraphData, err := graphing.InitGraphing(cntr, config.Level, config.Workers, steamID1)
-		if err != nil {
-			return err
+	if usersHaveBeenGraphedBefore := util.IsKeyInUrlMap(steamIDsIdentifier); !usersHaveBeenGraphedBefore || config.Resume || config.Refresh {
+		if !config.Resume && !config.Refresh {
+			GenerateURL(steamIDsIdentifier)
 		}
-		EndUserGraphData, err := graphing.InitGraphing(cntr, config.Level, config.Workers, steamID2)
+		finishedGraphLocation := fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, urlMapping[steamIDsIdentifier])
+		config.CrawlID = configuration.AppConfig.UrlMap[steamIDsIdentifier]
+
+		graphData, allStats, failures, err := crawlSeeds(ctx, []string{steamID1, steamID2}, cntr, config)
 		if err != nil {
 			return err
 		}
-
-		graph := charts.NewGraph()
-		allNodes := graphing.MergeNodes(StartUserGraphData.Nodes, EndUserGraphData.Nodes)
-
-		allDijkstraGraph, allUsersMap := graphing.MergeDijkstraGraphs(StartUserGraphData.DijkstraGraph, EndUserGraphData.DijkstraGraph, StartUserGraphData.UsersMap, EndUserGraphData.UsersMap)
-
-		graphData := &graphing.GraphData{
-			Nodes:        allNodes,
-			Links:        append(StartUserGraphData.Links, EndUserGraphData.Links...),
-			EchartsGraph: graph,
-
-			ApplyDijkstra: true,
-			UsersMap:      allUsersMap,
-			DijkstraGraph: allDijkstraGraph,
-		}
-		newNodes := make([]charts.GraphNode, 0)
 		bestPath, aPathExists := graphData.GetDijkstraPath(steamID1, steamID2)
 
 		if aPathExists {
@@ -83,33 +126,244 @@ func CrawlTwoUsers(steamID1, steamID2 string, urlMapping map[string]string, cntr
 			for _, username := range bestPath {
 				fmt.Printf("%s -> ", username)
 			}
-
 			fmt.Printf("\n")
-			foundNode := false
-
-			if len(bestPath) != 0 {
-				for _, username := range graphData.Nodes {
-					for _, pathUsername := range bestPath {
-						if username.Name == pathUsername {
-							specColor := charts.ItemStyleOpts{Color: "#38413A"}
-							newNodes = append(newNodes, charts.GraphNode{Name: pathUsername, ItemStyle: specColor})
-							foundNode = true
-							break
-						}
-					}
-					if !foundNode {
-						newNodes = append(newNodes, charts.GraphNode{Name: username.Name})
-					}
-					foundNode = false
+
+			onPath := make(map[string]bool)
+			for _, pathUsername := range bestPath {
+				onPath[pathUsername] = true
+			}
+			for i, node := range graphData.Nodes {
+				if onPath[node.Name] {
+					graphData.Nodes[i].ItemStyle = charts.ItemStyleOpts{Color: "#38413A"}
 				}
 			}
 		}
 
-		graphData.Nodes = newNodes
-		graphData.Render(finishedGraphLocation)
+		err = graphData.Render(finishedGraphLocation)
+		if err != nil {
+			return err
+		}
+		err = SaveCrawlStats(urlMapping[steamIDsIdentifier], allStats)
+		if err != nil {
+			return err
+		}
+		return graphing.GenerateReportPage(cntr, urlMapping[steamIDsIdentifier], failures, graphData.Note)
 	}
+	return nil
+}
 
-	finishedGraphLocation = fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, urlMapping[steamIDsIdentifier])
-	// fmt.Printf("Saved as %s.html\n", finishedGraphLocation)
+// CrawlManyUsers crawls three or more seed users and generates a unified graph of their friend
+// networks. Each seed user is given their own color and friends they share are highlighted.
+// If ctx is cancelled the crawl is checkpointed and no graph is generated
+func CrawlManyUsers(ctx context.Context, steamIDs []string, urlMapping map[string]string, cntr util.ControllerInterface, config CrawlerConfig) error {
+	if config.Plan {
+		fmt.Print(PlanCrawl(cntr, config, steamIDs))
+		return nil
+	}
+	steamIDsIdentifier, err := getSteamIDsIdentifier(steamIDs, urlMapping)
+	if err != nil {
+		return err
+	}
+	// Every crawl shares their player summaries so that
+	// friends in common are only summarised once
+	if config.Profiles == nil {
+		config.Profiles = NewProfileStore()
+	}
+
+	if usersHaveBeenGraphedBefore := util.IsKeyInUrlMap(steamIDsIdentifier); usersHaveBeenGraphedBefore && !config.Resume && !config.Refresh {
+		return nil
+	}
+	if !config.Resume && !config.Refresh {
+		GenerateURL(steamIDsIdentifier)
+	}
+	config.CrawlID = configuration.AppConfig.UrlMap[steamIDsIdentifier]
+
+	graphData, allStats, failures, err := crawlSeeds(ctx, steamIDs, cntr, config)
+	if err != nil {
+		return err
+	}
+	finishedGraphLocation := fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, configuration.AppConfig.UrlMap[steamIDsIdentifier])
+	err = graphData.Render(finishedGraphLocation)
+	if err != nil {
+		return err
+	}
+	err = SaveCrawlStats(configuration.AppConfig.UrlMap[steamIDsIdentifier], allStats)
+	if err != nil {
+		return err
+	}
+	return graphing.GenerateReportPage(cntr, configuration.AppConfig.UrlMap[steamIDsIdentifier], failures, graphData.Note)
+}
+
+// crawlSeeds crawls every seed user, graphs each of their friend networks
+// and merges them into one graph. The stats of each crawl are returned in
+// the order the seed users were given along with every crawl's failures
+func crawlSeeds(ctx context.Context, steamIDs []string, cntr util.ControllerInterface, config CrawlerConfig) (*graphing.GraphData, []CrawlStats, util.CrawlFailures, error) {
+	// The budgets cover every seed together rather than each one
+	if config.Budget == nil {
+		config.Budget = NewCrawlBudget()
+	}
+	stoppedBy := ""
+	allStats := make([]CrawlStats, 0, len(steamIDs))
+	allSkipped := make([]util.SkippedFriends, 0, len(steamIDs))
+	failures := util.CrawlFailures{}
+	for _, steamID := range steamIDs {
+		crawlResult, err := InitCrawling(ctx, cntr, config, steamID)
+		if err != nil {
+			return nil, allStats, failures, err
+		}
+		printCrawlSummary(crawlResult)
+		err = enrichCrawl(ctx, cntr, config, crawlResult)
+		if err != nil {
+			return nil, allStats, failures, err
+		}
+		allStats = append(allStats, crawlResult.Stats)
+		allSkipped = append(allSkipped, crawlResult.Skipped)
+		failures = append(failures, crawlResult.Failures...)
+		if stoppedBy == "" {
+			stoppedBy = crawlResult.StoppedBy
+		}
+	}
+
+	graphs := make([]*graphing.GraphData, 0, len(steamIDs))
+	for i, steamID := range steamIDs {
+		// Seeds the budgets ran out before are left out of the graph
+		if !graphing.CacheExists(steamID) {
+			continue
+		}
+		gData, err := graphing.InitGraphing(ctx, cntr, config.Level, config.Workers, steamID, allSkipped[i])
+		if err != nil {
+			return nil, allStats, failures, err
+		}
+		graphs = append(graphs, gData)
+	}
+	graphData := graphing.MergeGraphs(graphs...)
+	graphData.Note = partialGraphNote(stoppedBy)
+	applyGameOverlay(cntr, config, graphData)
+	return graphData, allStats, failures, nil
+}
+
+// enrichCrawl enriches every user a crawl reached with their games and prints
+// the games most shared between them. Nothing is done unless config.Games is set
+func enrichCrawl(ctx context.Context, cntr util.ControllerInterface, config CrawlerConfig, crawlResult CrawlResult) error {
+	if config.Games == "" {
+		return nil
+	}
+	steamIDs := make([]string, 0, len(crawlResult.Crawled))
+	for steamID := range crawlResult.Crawled {
+		steamIDs = append(steamIDs, steamID)
+	}
+	enrichment, err := EnrichGames(ctx, cntr, config, steamIDs)
+	if err != nil {
+		return err
+	}
+	fmt.Println(enrichment)
+	fmt.Print(FormatGamesReport(SharedGames(cntr, steamIDs), 10))
 	return nil
 }
+
+// applyGameOverlay highlights the users who have the game config.GameOverlay, if one was given
+func applyGameOverlay(cntr util.ControllerInterface, config CrawlerConfig, gData *graphing.GraphData) {
+	if config.GameOverlay == 0 {
+		return
+	}
+	owners := gData.ApplyGameOverlay(gameOverlay(cntr, config, gData))
+	fmt.Printf("%d of the graphed users have app %d\n", owners, config.GameOverlay)
+}
+
+// partialGraphNote is the note shown on a graph whose crawl was stopped by a budget
+func partialGraphNote(stoppedBy string) string {
+	if stoppedBy == "" {
+		return ""
+	}
+	return fmt.Sprintf("Partial graph, the crawl stopped early as %s", stoppedBy)
+}
+
+// printCrawlSummary prints how many users were crawled
+// and the reason behind every user that failed
+func printCrawlSummary(result CrawlResult) {
+	fmt.Printf("Crawled %d users for %s, %d failed, %d duplicates avoided, %d private friends left uncrawled\n",
+		len(result.Crawled), result.SteamID, len(result.Failures), result.DuplicatesAvoided, result.PrivateFriends)
+	fmt.Printf("Cache hit ratio %.0f%%, %d API calls, p90 fetch latency %s\n",
+		result.Stats.CacheHitRatio*100, result.Stats.APICalls, result.Stats.FetchLatency.P90)
+	if result.Stats.StaleRefetched > 0 {
+		fmt.Printf("Refetched %d users whose cached friend lists were stale\n", result.Stats.StaleRefetched)
+	}
+	if skipped := result.Skipped.Count(); skipped > 0 {
+		fmt.Printf("Friend sampling left %d friends of %d users uncrawled\n", skipped, len(result.Skipped))
+	}
+	if result.StoppedBy != "" {
+		fmt.Printf("Stopped early as %s. %d users were left uncrawled\n", result.StoppedBy, len(result.Frontier))
+	}
+	for _, failure := range result.Failures {
+		fmt.Printf("\t%s (level %d) %s after %d retries: %s\n", failure.SteamID, failure.Level, failure.Kind, failure.Retries, failure.Error)
+	}
+}
+
+// crawlPathFirst searches for the shortest paths between two users
+// and graphs only the users along those paths
+func crawlPathFirst(ctx context.Context, steamID1, steamID2, steamIDsIdentifier string, cntr util.ControllerInterface, config CrawlerConfig) error {
+	pathResult, err := FindPaths(ctx, cntr, config, steamID1, steamID2)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Crawled %d users searching for a path, %d failed\n", pathResult.UsersCrawled, len(pathResult.Failures))
+	if pathResult.StoppedBy != "" {
+		fmt.Printf("Stopped early as %s\n", pathResult.StoppedBy)
+	}
+	if len(pathResult.Paths) == 0 {
+		fmt.Printf("No path within %d levels of either user was found\n", config.Level)
+	} else {
+		fmt.Printf("Found %d shortest paths:\n", len(pathResult.Paths))
+	}
+	for _, path := range pathResult.Paths {
+		fmt.Printf("\t%s\n", FormatPath(path, pathResult.Usernames))
+	}
+
+	if !util.IsKeyInUrlMap(steamIDsIdentifier) {
+		GenerateURL(steamIDsIdentifier)
+	}
+	graphID := configuration.AppConfig.UrlMap[steamIDsIdentifier]
+	graphData := &graphing.GraphData{
+		EchartsGraph: charts.NewGraph(),
+		Note:         partialGraphNote(pathResult.StoppedBy),
+	}
+	nodeNames := make(map[string]string)
+	usedNames := make(map[string]bool)
+	// Both users are graphed even when no path between them was found
+	paths := append([][]string{{steamID1}, {steamID2}}, pathResult.Paths...)
+	for _, path := range paths {
+		for i, steamID := range path {
+			if _, exists := nodeNames[steamID]; !exists {
+				// Node names must be unique so a steamID is used
+				// for users without a username or with a taken one
+				name := pathResult.Usernames[steamID]
+				if name == "" {
+					name = steamID
+				} else if usedNames[name] {
+					name = fmt.Sprintf("%s (%s)", name, steamID)
+				}
+				nodeNames[steamID] = name
+				usedNames[name] = true
+
+				node := charts.GraphNode{Name: name}
+				if steamID == steamID1 || steamID == steamID2 {
+					node.ItemStyle = charts.ItemStyleOpts{Color: "#000000"}
+				}
+				graphData.Nodes = append(graphData.Nodes, node)
+			}
+			if i > 0 {
+				graphData.Links = append(graphData.Links, charts.GraphLink{Source: nodeNames[path[i-1]], Target: nodeNames[steamID]})
+			}
+		}
+	}
+	finishedGraphLocation := fmt.Sprintf("%s/%s", configuration.AppConfig.FinishedGraphsLocation, graphID)
+	err = graphData.Render(finishedGraphLocation)
+	if err != nil {
+		return err
+	}
+	err = SaveCrawlStats(graphID, pathResult.Stats)
+	if err != nil {
+		return err
+	}
+	return graphing.GenerateReportPage(cntr, graphID, pathResult.Failures, graphData.Note)
+}
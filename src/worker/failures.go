@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"errors"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+// DefaultMaxRetries is how many times a user is retried before
+// they're given up on and added to the crawl's failures report
+const DefaultMaxRetries = 2
+
+// crawlError tags an error returned while crawling
+// a user with the kind of failure it was
+type crawlError struct {
+	kind util.FailureKind
+	err  error
+}
+
+func newCrawlError(kind util.FailureKind, err error) error {
+	return &crawlError{kind: kind, err: err}
+}
+
+func (cErr *crawlError) Error() string {
+	return cErr.err.Error()
+}
+
+func (cErr *crawlError) Unwrap() error {
+	return cErr.err
+}
+
+// failureKind works out what kind of failure an error returned by GetFriends was
+func failureKind(err error) util.FailureKind {
+	var cErr *crawlError
+	if errors.As(err, &cErr) {
+		return cErr.kind
+	}
+	return util.FailureUnknown
+}
+
+// friendsListFailureKind works out what kind of failure an error returned
+// by the Steam web API when looking up a friends list was. Accounts that
+// don't exist or are private are never retried
+func friendsListFailureKind(err error) util.FailureKind {
+	switch {
+	case errors.Is(err, util.ErrNotFound):
+		return util.FailureInvalidSteamID
+	case errors.Is(err, util.ErrPrivateProfile):
+		return util.FailurePrivateProfile
+	}
+	return util.FailureFriendsList
+}
+
+// newCrawlFailure creates the failure report entry for a job that failed
+func newCrawlFailure(job JobsStruct, err error) util.CrawlFailure {
+	return util.CrawlFailure{
+		SteamID: job.CurrentTargetSteamID.String(),
+		Level:   job.Level,
+		Kind:    failureKind(err),
+		Error:   err.Error(),
+		Retries: job.Retries,
+	}
+}
@@ -0,0 +1,350 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/graphing"
+	"github.com/steamFriendsGraphing/logging"
+	"github.com/steamFriendsGraphing/util"
+)
+
+// GamesSource is where the games of crawled users are fetched from when enriching a crawl
+type GamesSource string
+
+const (
+	// GamesOwned fetches every game a user owns
+	GamesOwned GamesSource = "owned"
+	// GamesRecent fetches the games a user played in the last two weeks
+	GamesRecent GamesSource = "recent"
+)
+
+// ParseGamesSource checks that a games source given on the command line is
+// valid. An empty source is allowed and means crawls aren't enriched
+func ParseGamesSource(source string) (GamesSource, error) {
+	switch GamesSource(source) {
+	case "", GamesOwned, GamesRecent:
+		return GamesSource(source), nil
+	}
+	return "", fmt.Errorf("invalid games source %q given. must be %s or %s", source, GamesOwned, GamesRecent)
+}
+
+// GamesEnrichment counts what happened to each user when enriching a crawl with their games
+type GamesEnrichment struct {
+	Fetched int
+	// Cached users already had fresh games from the same source in their cache entry
+	Cached int
+	// Private users hide their profile or their games
+	Private int
+	Failed  int
+}
+
+// String gives a one line summary of the enrichment
+func (enrichment GamesEnrichment) String() string {
+	return fmt.Sprintf("Fetched games for %d users, %d were cached, %d were private and %d failed",
+		enrichment.Fetched, enrichment.Cached, enrichment.Private, enrichment.Failed)
+}
+
+// gamesOutcome is what happened when enriching a single user
+type gamesOutcome int
+
+const (
+	gamesFetched gamesOutcome = iota
+	gamesCached
+	gamesPrivate
+)
+
+// EnrichGames fetches the games of every given user from the source in config.Games and
+// stores them with the user's cache entry. Users whose cache entry already holds games
+// from the same source that haven't gone stale are skipped, as are users with private
+// profiles. Users that can't be enriched are logged and counted rather than failing the
+// enrichment, unless there are no working API keys left or ctx is cancelled
+func EnrichGames(ctx context.Context, cntr util.ControllerInterface, config CrawlerConfig, steamIDs []string) (GamesEnrichment, error) {
+	enrichment := GamesEnrichment{}
+	if config.Games == "" {
+		return enrichment, nil
+	}
+	keyPool := config.KeyPool
+	if keyPool == nil {
+		keyPool = util.NewKeyPool(config.APIKeys)
+	}
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan string)
+	var mutex sync.Mutex
+	var enrichErr error
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for steamID := range jobs {
+				outcome, err := enrichUserGames(ctx, cntr, keyPool, config.Games, steamID)
+				mutex.Lock()
+				switch {
+				case err != nil && (errors.Is(err, util.ErrNoValidKeys) || ctx.Err() != nil):
+					if enrichErr == nil {
+						enrichErr = err
+					}
+				case err != nil:
+					enrichment.Failed++
+					logging.SpecialLog(cntr, "errorLog", err.Error())
+				case outcome == gamesCached:
+					enrichment.Cached++
+				case outcome == gamesPrivate:
+					enrichment.Private++
+				default:
+					enrichment.Fetched++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	sorted := append([]string{}, steamIDs...)
+	sort.Strings(sorted)
+	for _, steamID := range sorted {
+		mutex.Lock()
+		stopped := enrichErr != nil
+		mutex.Unlock()
+		if stopped {
+			break
+		}
+		select {
+		case jobs <- steamID:
+		case <-ctx.Done():
+			mutex.Lock()
+			if enrichErr == nil {
+				enrichErr = ctx.Err()
+			}
+			mutex.Unlock()
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return enrichment, enrichErr
+}
+
+// enrichUserGames fetches and caches the games of a single user
+func enrichUserGames(ctx context.Context, cntr util.ControllerInterface, keyPool *util.KeyPool, source GamesSource, steamID string) (gamesOutcome, error) {
+	friendsObj, err := GetCache(cntr, steamID)
+	if err != nil {
+		return gamesFetched, err
+	}
+	if util.IsPrivateProfile(friendsObj.CommunityVisibilityState) {
+		return gamesPrivate, nil
+	}
+	if hasFreshGames(friendsObj.Games, source) {
+		return gamesCached, nil
+	}
+
+	apiKey, err := keyPool.Acquire(ctx)
+	if err != nil {
+		return gamesFetched, err
+	}
+	var gamesObj util.GamesStruct
+	if source == GamesRecent {
+		gamesObj, err = cntr.CallGetRecentlyPlayedGamesAPI(ctx, steamID, apiKey)
+	} else {
+		gamesObj, err = cntr.CallGetOwnedGamesAPI(ctx, steamID, apiKey)
+	}
+	keyPool.Report(apiKey, err)
+	if err != nil {
+		return gamesFetched, err
+	}
+
+	friendsObj.Games = &util.UserGames{
+		Source:    string(source),
+		Private:   gamesObj.IsPrivate(),
+		Games:     gamesObj.Response.Games,
+		FetchedAt: time.Now(),
+	}
+	if friendsObj.Games.Games == nil {
+		friendsObj.Games.Games = []util.OwnedGame{}
+	}
+	err = WriteToFile(cntr, apiKey, steamID, friendsObj)
+	if err != nil {
+		return gamesFetched, err
+	}
+	if friendsObj.Games.Private {
+		return gamesPrivate, nil
+	}
+	return gamesFetched, nil
+}
+
+// hasFreshGames checks whether cached games came from source and can still be used.
+// Cached games go stale after configuration.AppConfig.MaxCacheAge the same as friend lists
+func hasFreshGames(games *util.UserGames, source GamesSource) bool {
+	if games == nil || games.Source != string(source) || configuration.AppConfig.IgnoreCache {
+		return false
+	}
+	maxAge := configuration.AppConfig.MaxCacheAge
+	return maxAge <= 0 || time.Since(games.FetchedAt) <= maxAge
+}
+
+// cachedGames returns the games stored in a user's cache entry so they
+// can be carried over when the user's friend list is fetched again
+func cachedGames(cntr util.ControllerInterface, steamID string) *util.UserGames {
+	friendsObj, err := GetCache(cntr, steamID)
+	if err != nil {
+		return nil
+	}
+	return friendsObj.Games
+}
+
+// SharedGame is a game that several users in a network have
+type SharedGame struct {
+	AppID int    `json:"appID"`
+	Name  string `json:"name"`
+	// Owners is how many users have the game
+	Owners int `json:"owners"`
+	// Playtime is how many minutes the owners have played it for in total
+	Playtime int `json:"playtime"`
+}
+
+// GamesReport is how the games of the users in a network overlap
+type GamesReport struct {
+	Users int `json:"users"`
+	// WithGames is how many of the users' games are known. The
+	// rest are private or haven't been enriched with their games
+	WithGames int `json:"withGames"`
+	Private   int `json:"private"`
+	// Games holds every game at least two users have, most shared first
+	Games []SharedGame `json:"games"`
+}
+
+// SharedGames works out which games the given users have in common from their cache entries
+func SharedGames(cntr util.ControllerInterface, steamIDs []string) GamesReport {
+	report := GamesReport{Users: len(steamIDs), Games: make([]SharedGame, 0)}
+	games := make(map[int]*SharedGame)
+	for _, steamID := range steamIDs {
+		friendsObj, err := GetCache(cntr, steamID)
+		if err != nil || friendsObj.Games == nil {
+			continue
+		}
+		if friendsObj.Games.Private {
+			report.Private++
+			continue
+		}
+		report.WithGames++
+		for _, game := range friendsObj.Games.Games {
+			shared, exists := games[game.AppID]
+			if !exists {
+				shared = &SharedGame{AppID: game.AppID}
+				games[game.AppID] = shared
+			}
+			if shared.Name == "" {
+				shared.Name = game.Name
+			}
+			shared.Owners++
+			shared.Playtime += game.PlaytimeForever
+		}
+	}
+
+	for _, game := range games {
+		if game.Owners >= 2 {
+			report.Games = append(report.Games, *game)
+		}
+	}
+	sort.Slice(report.Games, func(i, j int) bool {
+		if report.Games[i].Owners != report.Games[j].Owners {
+			return report.Games[i].Owners > report.Games[j].Owners
+		}
+		if report.Games[i].Playtime != report.Games[j].Playtime {
+			return report.Games[i].Playtime > report.Games[j].Playtime
+		}
+		return report.Games[i].AppID < report.Games[j].AppID
+	})
+	return report
+}
+
+// GamesInCommon reports the games shared within either a saved graph, given its ID,
+// or a single user's friend network, given their steamID, from the cache
+func GamesInCommon(cntr util.ControllerInterface, id string) (GamesReport, error) {
+	users := make(map[string]bool)
+	if _, err := crawlIdentifier(id); err == nil {
+		graphUsers, err := graphUsers(cntr, id)
+		if err != nil {
+			return GamesReport{}, err
+		}
+		users = graphUsers
+	} else {
+		if !util.IsValidFormatSteamID(id) {
+			return GamesReport{}, util.MakeErr(fmt.Errorf("%s is neither a graph ID nor a steamID", id))
+		}
+		friendsObj, err := GetCache(cntr, id)
+		if err != nil {
+			return GamesReport{}, err
+		}
+		users[id] = true
+		for _, friend := range friendsObj.FriendsList.Friends {
+			users[friend.Steamid] = true
+		}
+	}
+
+	steamIDs := make([]string, 0, len(users))
+	for steamID := range users {
+		steamIDs = append(steamIDs, steamID)
+	}
+	return SharedGames(cntr, steamIDs), nil
+}
+
+// FormatGamesReport lists the top games of a report, at most limit of them
+func FormatGamesReport(report GamesReport, limit int) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Games are known for %d of %d users, %d hide their games\n", report.WithGames, report.Users, report.Private)
+	if len(report.Games) == 0 {
+		builder.WriteString("No games are shared between them\n")
+		return builder.String()
+	}
+	for i, game := range report.Games {
+		if i == limit {
+			break
+		}
+		name := game.Name
+		if name == "" {
+			name = fmt.Sprintf("app %d", game.AppID)
+		}
+		fmt.Fprintf(&builder, "%3d. %s (%d): %d users, %.1f hours played\n", i+1, name, game.AppID, game.Owners, float64(game.Playtime)/60)
+	}
+	return builder.String()
+}
+
+// gameOverlay works out which users in a graph have the game config.GameOverlay
+func gameOverlay(cntr util.ControllerInterface, config CrawlerConfig, gData *graphing.GraphData) graphing.GameOverlay {
+	overlay := graphing.GameOverlay{
+		AppID:  config.GameOverlay,
+		Owners: make(map[string]bool),
+		Filter: config.GameFilter,
+	}
+	name := ""
+	for _, steamID := range gData.NodeSteamIDs {
+		friendsObj, err := GetCache(cntr, steamID)
+		if err != nil || !friendsObj.Games.Owns(config.GameOverlay) {
+			continue
+		}
+		overlay.Owners[steamID] = true
+		for _, game := range friendsObj.Games.Games {
+			if game.AppID == config.GameOverlay && game.Name != "" {
+				name = game.Name
+			}
+		}
+	}
+	if name == "" {
+		name = fmt.Sprintf("app %d", config.GameOverlay)
+	}
+	overlay.Label = fmt.Sprintf("Has %s", name)
+	if config.Games == GamesRecent {
+		overlay.Label = fmt.Sprintf("Recently played %s", name)
+	}
+	return overlay
+}
@@ -0,0 +1,333 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+// maxShortestPaths caps how many shortest paths are returned as
+// dense friend groups can have a huge amount of equally short paths
+const maxShortestPaths = 10
+
+// PathResult is returned by FindPaths once the two users'
+// friend networks have met or can't be grown any further
+type PathResult struct {
+	// Paths holds the shortest paths found between the two users. Each
+	// path is a list of steamIDs starting with the first user
+	Paths [][]string
+	// Usernames maps every steamID in Paths to its username
+	Usernames map[string]string
+	// UsersCrawled is how many friend lists had to be fetched
+	UsersCrawled int
+	Failures     util.CrawlFailures
+	// StoppedBy is which budget ran out if the search was stopped early
+	StoppedBy string
+	// Stats holds the crawl stats of each user's side of the search
+	Stats []CrawlStats
+}
+
+// searchSide is one half of a bidirectional search. It keeps track of
+// how far every user reached from its seed is and which users they were
+// reached from so that every shortest path can be rebuilt
+type searchSide struct {
+	seed     string
+	depth    int
+	frontier []string
+	dist     map[string]int
+	parents  map[string][]string
+
+	// state, recorder and apiCounter are what the side's crawl stats are built from
+	state      *crawlState
+	recorder   *statsRecorder
+	apiCounter *countingController
+}
+
+func newSearchSide(steamID string, cntr util.ControllerInterface, levelCap int) *searchSide {
+	return &searchSide{
+		seed:       steamID,
+		frontier:   []string{steamID},
+		dist:       map[string]int{steamID: 0},
+		parents:    make(map[string][]string),
+		state:      newCrawlState("", steamID, levelCap),
+		recorder:   newStatsRecorder(),
+		apiCounter: &countingController{ControllerInterface: cntr},
+	}
+}
+
+// addFriend records that friend was reached from steamID at the side's current
+// depth. It returns true if this is the first time friend has been reached
+func (side *searchSide) addFriend(steamID, friend string) bool {
+	dist, seen := side.dist[friend]
+	if !seen {
+		side.dist[friend] = side.depth
+		side.parents[friend] = []string{steamID}
+		return true
+	}
+	if dist == side.depth {
+		side.parents[friend] = append(side.parents[friend], steamID)
+	}
+	return false
+}
+
+// pathsTo returns up to limit shortest paths from the seed to a given user
+func (side *searchSide) pathsTo(steamID string, limit int) [][]string {
+	if steamID == side.seed {
+		return [][]string{{steamID}}
+	}
+	paths := make([][]string, 0)
+	for _, parent := range side.parents[steamID] {
+		for _, path := range side.pathsTo(parent, limit-len(paths)) {
+			paths = append(paths, append(append([]string{}, path...), steamID))
+			if len(paths) >= limit {
+				return paths
+			}
+		}
+	}
+	return paths
+}
+
+// FindPaths finds the shortest paths between two users without crawling either of them fully.
+// Both users' friend networks are grown one level at a time, always growing the side with the
+// smaller frontier, until the two meet. Each side is grown at most cfg.Level - 1 levels.
+// Failed users are retried and the budgets apply just as they do to a full crawl. Friends
+// left out by sampling or with private profiles can be where the two sides meet but their
+// friends aren't crawled. Searches aren't checkpointed, a stopped search starts over when
+// run again but the friend lists it fetched are already cached by then
+func FindPaths(ctx context.Context, cntr util.ControllerInterface, cfg CrawlerConfig, steamID1, steamID2 string) (PathResult, error) {
+	pathResult := PathResult{Usernames: make(map[string]string)}
+	if steamID1 == steamID2 {
+		pathResult.Paths = [][]string{{steamID1}}
+		return pathResult, nil
+	}
+
+	workConfig, err := InitWorkerConfig(cfg.Level, cfg.Workers)
+	if err != nil {
+		return pathResult, err
+	}
+	workConfig.KeyPool = cfg.KeyPool
+	if workConfig.KeyPool == nil {
+		workConfig.KeyPool = util.NewKeyPool(cfg.APIKeys)
+	}
+	workConfig.Sampling = cfg.Sampling
+	if cfg.Profiles != nil {
+		workConfig.Profiles = cfg.Profiles
+	}
+
+	sides := [2]*searchSide{newSearchSide(steamID1, cntr, cfg.Level), newSearchSide(steamID2, cntr, cfg.Level)}
+	budget := cfg.Budget
+	if budget == nil {
+		budget = NewCrawlBudget()
+	}
+	usedUsers, usedAPICalls := budget.used()
+	usersCrawled := func() int {
+		return usedUsers + len(sides[0].state.Visited) + len(sides[1].state.Visited)
+	}
+	exceededBudget := func() string {
+		return cfg.exceededBudget(usersCrawled(), usedAPICalls+sides[0].apiCounter.apiCalls()+sides[1].apiCounter.apiCalls())
+	}
+	var durationBudget <-chan time.Time
+	if cfg.MaxDuration > 0 {
+		durationTimer := time.NewTimer(budget.timeLeft(cfg.MaxDuration))
+		defer durationTimer.Stop()
+		durationBudget = durationTimer.C
+	}
+	stoppedBy := exceededBudget()
+
+	// finish builds the result of a search that wasn't cut short by an error
+	finish := func() PathResult {
+		complete := stoppedBy == ""
+		for _, side := range sides {
+			budget.spend(len(side.state.Visited), side.apiCounter.apiCalls())
+			pathResult.Stats = append(pathResult.Stats, side.recorder.stats(side.state, side.apiCounter, workConfig.Profiles, complete))
+		}
+		pathResult.StoppedBy = stoppedBy
+		return pathResult
+	}
+
+	// recordFriends records a crawled user's friends on a side
+	// and returns the ones reached for the first time
+	recordFriends := func(side *searchSide, result jobResult) []string {
+		side.state.recordResult(result, cfg.Level)
+		for steamID, username := range result.usernames {
+			pathResult.Usernames[steamID] = username
+		}
+		steamID := result.job.CurrentTargetSteamID.String()
+		for _, friend := range result.privateFriends {
+			side.addFriend(steamID, friend.CurrentTargetSteamID.String())
+		}
+		for _, friend := range result.skippedFriends {
+			side.addFriend(steamID, friend)
+		}
+		newFrontier := make([]string, 0)
+		for _, friend := range result.friends {
+			if side.addFriend(steamID, friend.CurrentTargetSteamID.String()) {
+				newFrontier = append(newFrontier, friend.CurrentTargetSteamID.String())
+			}
+		}
+		return newFrontier
+	}
+
+	// crawlLevel fetches the friends of everyone on a side's frontier and returns
+	// the next frontier. Its workers count API calls towards the side's stats
+	crawlLevel := func(side *searchSide) ([]string, error) {
+		jobs := make(chan JobsStruct)
+		results := make(chan jobResult, workConfig.WorkerAmount)
+		workersCtx, stopWorkers := context.WithCancel(ctx)
+		// Results still on their way back are thrown away so no worker is left blocked
+		defer func() {
+			stopWorkers()
+			go func() {
+				workConfig.Wg.Wait()
+				close(results)
+			}()
+			for range results {
+			}
+		}()
+		workConfig.Wg.Add(workConfig.WorkerAmount)
+		for i := 0; i < workConfig.WorkerAmount; i++ {
+			go Worker(workersCtx, side.apiCounter, jobs, results, workConfig)
+		}
+
+		queued := make([]JobsStruct, 0, len(side.frontier))
+		for _, steamID := range side.frontier {
+			queued = append(queued, JobsStruct{Level: side.depth + 1, OriginalTargetUserSteamID: toSteamID(side.seed), CurrentTargetSteamID: toSteamID(steamID)})
+		}
+		side.depth++
+
+		newFrontier := make([]string, 0)
+		inFlight := 0
+		for (len(queued) > 0 || inFlight > 0) && stoppedBy == "" {
+			// Sending on a nil channel blocks forever so jobs are only handed
+			// out while there's one waiting and it fits in the max users budget
+			var jobsOut chan<- JobsStruct
+			var nextJob JobsStruct
+			if len(queued) > 0 && cfg.canDispatch(usersCrawled(), inFlight) {
+				jobsOut, nextJob = jobs, queued[0]
+			}
+
+			select {
+			case jobsOut <- nextJob:
+				queued = queued[1:]
+				inFlight++
+
+			case <-ctx.Done():
+				return newFrontier, ctx.Err()
+
+			case <-durationBudget:
+				stoppedBy = fmt.Sprintf("the max duration budget of %s ran out", cfg.MaxDuration)
+
+			case result := <-results:
+				inFlight--
+				side.recorder.record(result)
+				if errors.Is(result.err, util.ErrNoValidKeys) {
+					return newFrontier, result.err
+				}
+				if result.err != nil {
+					// A failed user is retried with the next API key until they run out of retries
+					if failureKind(result.err).IsRetryable() && result.job.Retries < cfg.MaxRetries {
+						result.job.Retries++
+						queued = append(queued, result.job)
+						break
+					}
+					pathResult.UsersCrawled++
+					failure := newCrawlFailure(result.job, result.err)
+					side.state.Failures = append(side.state.Failures, failure)
+					pathResult.Failures = append(pathResult.Failures, failure)
+					break
+				}
+				pathResult.UsersCrawled++
+				newFrontier = append(newFrontier, recordFriends(side, result)...)
+				stoppedBy = exceededBudget()
+			}
+		}
+		return newFrontier, nil
+	}
+
+	maxDepth := cfg.Level - 1
+	for stoppedBy == "" {
+		// The side with the smaller frontier is grown as it costs the fewest API calls
+		var side, other *searchSide
+		for i, candidate := range sides {
+			if candidate.depth >= maxDepth || len(candidate.frontier) == 0 {
+				continue
+			}
+			if side == nil || len(candidate.frontier) < len(side.frontier) {
+				side, other = candidate, sides[1-i]
+			}
+		}
+		// Neither side can be grown any further so there is no path
+		if side == nil {
+			break
+		}
+
+		newFrontier, err := crawlLevel(side)
+		if err != nil {
+			return pathResult, err
+		}
+
+		// A level cut short by a budget can still have
+		// reached where the two sides meet
+		meetingPoints := make([]string, 0)
+		shortest := -1
+		for steamID, dist := range side.dist {
+			otherDist, reached := other.dist[steamID]
+			if dist != side.depth || !reached {
+				continue
+			}
+			if total := dist + otherDist; shortest == -1 || total < shortest {
+				shortest = total
+				meetingPoints = []string{steamID}
+			} else if total == shortest {
+				meetingPoints = append(meetingPoints, steamID)
+			}
+		}
+
+		if len(meetingPoints) > 0 {
+			pathResult.Paths = joinPaths(sides[0], sides[1], meetingPoints)
+			break
+		}
+		side.frontier = newFrontier
+	}
+	return finish(), nil
+}
+
+// joinPaths joins the paths from both seeds to each meeting point
+// into paths going from the first seed to the second
+func joinPaths(first, second *searchSide, meetingPoints []string) [][]string {
+	paths := make([][]string, 0)
+	for _, meetingPoint := range meetingPoints {
+		for _, firstHalf := range first.pathsTo(meetingPoint, maxShortestPaths) {
+			for _, secondHalf := range second.pathsTo(meetingPoint, maxShortestPaths) {
+				path := append([]string{}, firstHalf...)
+				for i := len(secondHalf) - 2; i >= 0; i-- {
+					path = append(path, secondHalf[i])
+				}
+				paths = append(paths, path)
+				if len(paths) >= maxShortestPaths {
+					return paths
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// FormatPath formats a path using usernames where they're known
+func FormatPath(path []string, usernames map[string]string) string {
+	formatted := ""
+	for i, steamID := range path {
+		if i > 0 {
+			formatted += " -> "
+		}
+		if username := usernames[steamID]; username != "" {
+			formatted += fmt.Sprintf("%s[%s]", username, steamID)
+		} else {
+			formatted += steamID
+		}
+	}
+	return formatted
+}
@@ -0,0 +1,242 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+const (
+	// defaultFriendsPerUser is assumed when no cached friend
+	// lists are found to sample friend counts from
+	defaultFriendsPerUser = 60
+	// defaultCallLatency is assumed for each call to the
+	// Steam web API when no latency is given
+	defaultCallLatency = 300 * time.Millisecond
+)
+
+// PlanLimits are the limits a crawl plan works out its wall time with
+type PlanLimits struct {
+	Global util.RateLimit
+	PerKey util.RateLimit
+	// CallLatency is how long each call to the Steam web API is
+	// expected to take. Zero means defaultCallLatency
+	CallLatency time.Duration
+}
+
+// LevelPlan is the estimate for a single level of a crawl. KnownUsers are users
+// whose steamIDs are known from cached friend lists while EstimatedUsers are
+// extrapolated from the friend counts of users that aren't cached
+type LevelPlan struct {
+	Level          int     `json:"level"`
+	KnownUsers     int     `json:"knownUsers"`
+	Cached         int     `json:"cached"`
+	Uncached       int     `json:"uncached"`
+	EstimatedUsers float64 `json:"estimatedUsers"`
+	// Fetches is how many users on the level are expected to be fetched
+	Fetches float64 `json:"fetches"`
+}
+
+// CrawlPlan is an estimate of what a crawl would cost, worked
+// out from the cache alone without making any network calls
+type CrawlPlan struct {
+	SteamIDs []string    `json:"steamIDs"`
+	LevelCap int         `json:"levelCap"`
+	Levels   []LevelPlan `json:"levels"`
+
+	// FriendsPerUser and FollowedPerUser are the average friends and friends
+	// followed by the sampling policy of the cached users that were sampled
+	FriendsPerUser  float64 `json:"friendsPerUser"`
+	FollowedPerUser float64 `json:"followedPerUser"`
+	SampledUsers    int     `json:"sampledUsers"`
+
+	FriendListCalls    int `json:"friendListCalls"`
+	PlayerSummaryCalls int `json:"playerSummaryCalls"`
+	APICalls           int `json:"apiCalls"`
+	Keys               int `json:"keys"`
+	CallsPerKey        int `json:"callsPerKey"`
+	// KeyQuotaUsed is the share of each key's daily limit
+	// the crawl would use, zero if keys have no daily limit
+	KeyQuotaUsed float64       `json:"keyQuotaUsed"`
+	WallTime     time.Duration `json:"wallTime"`
+}
+
+// PlanCrawl estimates how many API calls and how long a crawl of the given seed users
+// would take. The cache is walked out from the seeds level by level and users whose
+// friend lists aren't cached are extrapolated from the friend counts that are
+func PlanCrawl(cntr util.ControllerInterface, config CrawlerConfig, steamIDs []string) CrawlPlan {
+	plan := CrawlPlan{
+		SteamIDs: steamIDs,
+		LevelCap: config.Level,
+		Keys:     len(config.APIKeys),
+	}
+	if config.KeyPool != nil {
+		plan.Keys = len(config.KeyPool.Stats())
+	}
+
+	visited := make(map[string]bool)
+	known := dedupeSteamIDs(steamIDs)
+	estimated := 0.0
+	friendsSeen, followedSeen := 0, 0
+	// followedEdges and newUsers are used to work out the share of
+	// followed friends that haven't already been seen on a lower level
+	followedEdges, newUsers := 0, 0
+
+	for level := 1; level <= config.Level && (len(known) > 0 || estimated > 0); level++ {
+		levelPlan := LevelPlan{Level: level, EstimatedUsers: estimated}
+		nextKnown := []string{}
+		for _, steamID := range known {
+			if visited[steamID] {
+				continue
+			}
+			visited[steamID] = true
+			levelPlan.KnownUsers++
+
+			cached, _ := cacheState(cntr, steamID)
+			if !cached {
+				levelPlan.Uncached++
+				continue
+			}
+			friendsObj, err := GetCache(cntr, steamID)
+			if err != nil {
+				levelPlan.Uncached++
+				continue
+			}
+			levelPlan.Cached++
+
+			publicFriends := make([]util.Friend, 0, len(friendsObj.FriendsList.Friends))
+			for _, friend := range friendsObj.FriendsList.Friends {
+				if !util.IsPrivateProfile(friend.CommunityVisibilityState) {
+					publicFriends = append(publicFriends, friend)
+				}
+			}
+			followed, _ := config.Sampling.sample(cntr, steamID, publicFriends)
+			plan.SampledUsers++
+			friendsSeen += len(friendsObj.FriendsList.Friends)
+			followedSeen += len(followed)
+
+			if level < config.Level {
+				for _, friend := range followed {
+					followedEdges++
+					if !visited[friend.Steamid] {
+						newUsers++
+						nextKnown = append(nextKnown, friend.Steamid)
+					}
+				}
+			}
+		}
+
+		// Users that aren't known are assumed to be cached
+		// as often as the known users on the same level
+		cachedShare := 0.0
+		if levelPlan.KnownUsers > 0 {
+			cachedShare = float64(levelPlan.Cached) / float64(levelPlan.KnownUsers)
+		}
+		levelPlan.Fetches = float64(levelPlan.Uncached) + estimated*(1-cachedShare)
+		plan.Levels = append(plan.Levels, levelPlan)
+
+		// The friends of users whose friend lists aren't cached can only be estimated
+		plan.FriendsPerUser, plan.FollowedPerUser = averageFriends(plan.SampledUsers, friendsSeen, followedSeen, config.Sampling)
+		estimated = (float64(levelPlan.Uncached) + estimated) * plan.FollowedPerUser * newUserShare(followedEdges, newUsers)
+		known = nextKnown
+	}
+
+	fetches := 0.0
+	for _, levelPlan := range plan.Levels {
+		fetches += levelPlan.Fetches
+	}
+	plan.FriendListCalls = int(math.Ceil(fetches))
+	// Every fetched user is summarised along with their friends. Friends
+	// already summarised earlier in the crawl aren't summarised again
+	summarised := fetches * (1 + plan.FriendsPerUser*newUserShare(followedEdges, newUsers))
+	plan.PlayerSummaryCalls = int(math.Ceil(summarised / maxSummariesPerCall))
+	plan.APICalls = plan.FriendListCalls + plan.PlayerSummaryCalls
+	if plan.Keys > 0 {
+		plan.CallsPerKey = int(math.Ceil(float64(plan.APICalls) / float64(plan.Keys)))
+	}
+	if config.RateLimits.PerKey.PerDay > 0 {
+		plan.KeyQuotaUsed = float64(plan.CallsPerKey) / float64(config.RateLimits.PerKey.PerDay)
+	}
+	plan.WallTime = estimateWallTime(plan.APICalls, plan.Keys, config.Workers, config.RateLimits)
+	return plan
+}
+
+// averageFriends works out the average friends and friends followed per user
+// from the users sampled, falling back to defaultFriendsPerUser without any
+func averageFriends(sampledUsers, friendsSeen, followedSeen int, sampling SamplingPolicy) (float64, float64) {
+	if sampledUsers == 0 {
+		followed := float64(defaultFriendsPerUser)
+		if sampling.TopN > 0 && sampling.TopN < defaultFriendsPerUser {
+			followed = float64(sampling.TopN)
+		}
+		return defaultFriendsPerUser, followed
+	}
+	return float64(friendsSeen) / float64(sampledUsers), float64(followedSeen) / float64(sampledUsers)
+}
+
+// newUserShare is the share of followed friends that hadn't been seen before,
+// all of them if no friend lists were cached to work it out from
+func newUserShare(followedEdges, newUsers int) float64 {
+	if followedEdges == 0 {
+		return 1
+	}
+	return float64(newUsers) / float64(followedEdges)
+}
+
+// estimateWallTime works out how long the given calls take at the slowest
+// of the rate limits and how fast the workers can make calls. Once a daily
+// limit runs out the rest of the calls wait for the next day
+func estimateWallTime(calls, keys, workers int, limits PlanLimits) time.Duration {
+	if calls == 0 {
+		return 0
+	}
+	latency := limits.CallLatency
+	if latency <= 0 {
+		latency = defaultCallLatency
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	callsPerSecond := float64(workers) / latency.Seconds()
+	if limits.Global.PerSecond > 0 {
+		callsPerSecond = math.Min(callsPerSecond, limits.Global.PerSecond)
+	}
+	if limits.PerKey.PerSecond > 0 && keys > 0 {
+		callsPerSecond = math.Min(callsPerSecond, limits.PerKey.PerSecond*float64(keys))
+	}
+
+	callsPerDay := 0
+	if limits.Global.PerDay > 0 {
+		callsPerDay = limits.Global.PerDay
+	}
+	if limits.PerKey.PerDay > 0 && keys > 0 && (callsPerDay == 0 || limits.PerKey.PerDay*keys < callsPerDay) {
+		callsPerDay = limits.PerKey.PerDay * keys
+	}
+	days := 0
+	if callsPerDay > 0 {
+		days = (calls - 1) / callsPerDay
+		calls -= days * callsPerDay
+	}
+	return time.Duration(days)*24*time.Hour + time.Duration(float64(calls)/callsPerSecond*float64(time.Second))
+}
+
+// String lays the plan out as a small report
+func (plan CrawlPlan) String() string {
+	report := strings.Builder{}
+	fmt.Fprintf(&report, "Plan for a level %d crawl of %s\n", plan.LevelCap, strings.Join(plan.SteamIDs, ", "))
+	for _, levelPlan := range plan.Levels {
+		fmt.Fprintf(&report, "\tLevel %d: %d known users (%d cached, %d uncached), ~%.0f more estimated, ~%.0f to fetch\n",
+			levelPlan.Level, levelPlan.KnownUsers, levelPlan.Cached, levelPlan.Uncached, levelPlan.EstimatedUsers, levelPlan.Fetches)
+	}
+	fmt.Fprintf(&report, "Averages from %d cached users: %.1f friends, %.1f followed\n", plan.SampledUsers, plan.FriendsPerUser, plan.FollowedPerUser)
+	fmt.Fprintf(&report, "Expected API calls: %d GetFriendList + %d GetPlayerSummaries = %d\n", plan.FriendListCalls, plan.PlayerSummaryCalls, plan.APICalls)
+	fmt.Fprintf(&report, "Quota: %d calls for each of %d keys", plan.CallsPerKey, plan.Keys)
+	if plan.KeyQuotaUsed > 0 {
+		fmt.Fprintf(&report, " (%.1f%% of each key's daily limit)", plan.KeyQuotaUsed*100)
+	}
+	fmt.Fprintf(&report, "\nEstimated wall time: %s\n", plan.WallTime.Round(time.Second))
+	return report.String()
+}
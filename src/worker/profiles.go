@@ -0,0 +1,179 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+// maxSummariesPerCall is the most steamIDs the Steam web
+// API accepts in a single player summary call
+const maxSummariesPerCall = 100
+
+// ProfileStore holds the player summary of every account looked up during a crawl so
+// that each account is only summarised once. steamIDs that haven't been summarised yet
+// are pooled across every worker so that calls are sent with as close to a full batch
+// of 100 steamIDs as possible
+type ProfileStore struct {
+	mutex sync.Mutex
+	// summarised is signalled every time a batch comes back
+	summarised *sync.Cond
+	profiles   map[string]util.Player
+	// pending holds the steamIDs waiting to be sent in a batch
+	// in the order they were asked for
+	pending  []string
+	queued   map[string]bool
+	inFlight map[string]bool
+
+	lookupsAvoided int
+}
+
+// NewProfileStore creates an empty ProfileStore
+func NewProfileStore() *ProfileStore {
+	store := &ProfileStore{
+		profiles: make(map[string]util.Player),
+		queued:   make(map[string]bool),
+		inFlight: make(map[string]bool),
+	}
+	store.summarised = sync.NewCond(&store.mutex)
+	return store
+}
+
+// Lookup returns the player summaries of the given steamIDs. Accounts that have already
+// been summarised are never looked up again. Batches are filled up with steamIDs other
+// workers are waiting on and if another worker is already looking up one of the given
+// steamIDs Lookup waits for it instead, until ctx is cancelled. Accounts the Steam web
+// API doesn't return a summary for are given an empty summary
+func (store *ProfileStore) Lookup(ctx context.Context, cntr util.ControllerInterface, apiKey string, steamIDs []string) (map[string]util.Player, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, steamID := range steamIDs {
+		if _, summarised := store.profiles[steamID]; summarised || store.queued[steamID] || store.inFlight[steamID] {
+			store.lookupsAvoided++
+			continue
+		}
+		store.queued[steamID] = true
+		store.pending = append(store.pending, steamID)
+	}
+
+	for {
+		missing := make(map[string]bool)
+		for _, steamID := range steamIDs {
+			if _, summarised := store.profiles[steamID]; !summarised {
+				missing[steamID] = true
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+
+		batch := store.nextBatch(missing)
+		// Everything still missing is being looked up by another worker
+		if len(batch) == 0 {
+			if err := store.waitForBatch(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, steamID := range batch {
+			delete(store.queued, steamID)
+			store.inFlight[steamID] = true
+		}
+
+		store.mutex.Unlock()
+		userStatsObj, err := cntr.CallPlayerSummaryAPI(ctx, strings.Join(batch, ","), apiKey)
+		store.mutex.Lock()
+
+		for _, steamID := range batch {
+			delete(store.inFlight, steamID)
+		}
+		if err != nil {
+			// The batch is put back so that anyone else waiting
+			// on it can look it up with their own API key
+			for _, steamID := range batch {
+				store.queued[steamID] = true
+			}
+			store.pending = append(batch, store.pending...)
+			store.summarised.Broadcast()
+			return nil, err
+		}
+		for _, steamID := range batch {
+			store.profiles[steamID] = util.Player{Steamid: steamID}
+		}
+		for _, player := range userStatsObj.Response.Players {
+			store.profiles[player.Steamid] = player
+		}
+		store.summarised.Broadcast()
+	}
+
+	players := make(map[string]util.Player, len(steamIDs))
+	for _, steamID := range steamIDs {
+		players[steamID] = store.profiles[steamID]
+	}
+	return players, nil
+}
+
+// waitForBatch waits for another worker's batch to come back. Waiting stops when ctx is
+// cancelled so a worker isn't left waiting on a batch that's never coming back. It must
+// be called with the mutex held
+func (store *ProfileStore) waitForBatch(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	waited := make(chan struct{})
+	defer close(waited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			store.mutex.Lock()
+			store.summarised.Broadcast()
+			store.mutex.Unlock()
+		case <-waited:
+		}
+	}()
+	store.summarised.Wait()
+	return ctx.Err()
+}
+
+// nextBatch takes the next batch to send off the pending steamIDs. A full batch
+// is always sent first. Otherwise a partial batch is only sent if it holds some
+// of the steamIDs the caller is missing
+func (store *ProfileStore) nextBatch(missing map[string]bool) []string {
+	batchSize := len(store.pending)
+	if batchSize > maxSummariesPerCall {
+		batchSize = maxSummariesPerCall
+	}
+	if batchSize < maxSummariesPerCall {
+		holdsMissing := false
+		for _, steamID := range store.pending {
+			if missing[steamID] {
+				holdsMissing = true
+				break
+			}
+		}
+		if !holdsMissing {
+			return nil
+		}
+	}
+	batch := append([]string{}, store.pending[:batchSize]...)
+	store.pending = store.pending[batchSize:]
+	return batch
+}
+
+// Summarised returns how many accounts have been summarised
+func (store *ProfileStore) Summarised() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.profiles)
+}
+
+// LookupsAvoided returns how many times an account was asked for
+// after it had already been summarised or asked for by another worker
+func (store *ProfileStore) LookupsAvoided() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.lookupsAvoided
+}
@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventKind is the type of a ProgressEvent
+type EventKind string
+
+const (
+	// EventUserFetched is published when a user's friends
+	// list was fetched from the Steam web API
+	EventUserFetched EventKind = "userFetched"
+	// EventCacheHit is published when a user's friends list was read from cache
+	EventCacheHit EventKind = "cacheHit"
+	// EventError is published when a user fails to be crawled,
+	// whether or not they're going to be retried
+	EventError EventKind = "error"
+	// EventLevelFinished is published once every user on a level has been crawled
+	EventLevelFinished EventKind = "levelFinished"
+	// EventCrawlFinished is published once when the crawl stops for any reason
+	EventCrawlFinished EventKind = "crawlFinished"
+)
+
+// ProgressEvent is published by ControlFunc as a crawl goes on. Every event
+// carries a snapshot of the crawl's progress at the time it was published
+type ProgressEvent struct {
+	Kind    EventKind `json:"kind"`
+	SteamID string    `json:"steamID,omitempty"`
+	Level   int       `json:"level,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	// Retrying is set on an error event if the user will be tried again
+	Retrying bool `json:"retrying,omitempty"`
+
+	// CrawlSteamID is the user the crawl was started for
+	CrawlSteamID string `json:"crawlSteamID"`
+	LevelCap     int    `json:"levelCap"`
+	UsersCrawled int    `json:"usersCrawled"`
+	// Frontier is how many users are waiting to be crawled
+	Frontier int `json:"frontier"`
+	APICalls int `json:"apiCalls"`
+	// ETA is a rough estimate of how long it will take to crawl
+	// the current frontier based on the rate users are crawled at
+	ETA     time.Duration `json:"eta"`
+	Elapsed time.Duration `json:"elapsed"`
+	Time    time.Time     `json:"time"`
+}
+
+// publishProgress sends an event without blocking. Events are dropped if the
+// channel is full so that a slow consumer never holds back the crawl
+func publishProgress(progress chan<- ProgressEvent, event ProgressEvent) {
+	if progress == nil {
+		return
+	}
+	select {
+	case progress <- event:
+	default:
+	}
+}
+
+// publishFinalProgress sends the last event of a crawl. Unlike publishProgress
+// it waits for room on the channel so the consumer always finds out that the
+// crawl has finished
+func publishFinalProgress(progress chan<- ProgressEvent, event ProgressEvent) {
+	if progress == nil {
+		return
+	}
+	progress <- event
+}
+
+// estimateETA estimates how long the remaining users will take
+// to crawl from how long the users crawled so far took
+func estimateETA(elapsed time.Duration, usersCrawled, remaining int) time.Duration {
+	if usersCrawled == 0 {
+		return 0
+	}
+	return elapsed / time.Duration(usersCrawled) * time.Duration(remaining)
+}
+
+// FormatProgressBar formats an event's progress snapshot as a single line
+// progress bar. The bar's total grows as more friends are found
+func FormatProgressBar(event ProgressEvent, width int) string {
+	total := event.UsersCrawled + event.Frontier
+	filled := width
+	if total > 0 {
+		filled = width * event.UsersCrawled / total
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", width-filled)
+	return fmt.Sprintf("%s [%s] %d/%d users | %d API calls | ETA %s",
+		event.CrawlSteamID, bar, event.UsersCrawled, total, event.APICalls, event.ETA.Round(time.Second))
+}
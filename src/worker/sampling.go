@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+
+	"github.com/steamFriendsGraphing/util"
+)
+
+// SampleBy is how a user's friends are ranked when only
+// the top few of them are followed
+type SampleBy string
+
+const (
+	// SampleByFriendSince follows the longest standing friendships first
+	SampleByFriendSince SampleBy = "friendSince"
+	// SampleByRandom follows a random set of friends. The same seed
+	// always picks the same friends for a given user
+	SampleByRandom SampleBy = "random"
+	// SampleByMutual follows the friends who share the most friends with
+	// the user. Mutual friends can only be counted for friends that are
+	// already cached so this is most useful when re-crawling
+	SampleByMutual SampleBy = "mutual"
+)
+
+// SamplingPolicy limits how many of each user's friends are followed so that
+// deep crawls stay tractable. Friends that aren't followed are still graphed
+// but their own friends are never crawled. A TopN of zero follows every friend
+type SamplingPolicy struct {
+	TopN int
+	By   SampleBy
+	Seed int64
+}
+
+// ParseSampleBy checks that a sampling ranking given on the command line is valid
+func ParseSampleBy(by string) (SampleBy, error) {
+	switch SampleBy(by) {
+	case SampleByFriendSince, SampleByRandom, SampleByMutual:
+		return SampleBy(by), nil
+	}
+	return "", fmt.Errorf("invalid sampling ranking %q given. must be one of %s, %s or %s",
+		by, SampleByFriendSince, SampleByRandom, SampleByMutual)
+}
+
+// sample splits a user's friends into the ones that should be followed and the ones that
+// are skipped. Ties are broken by steamID so the same friends are always picked for a user
+func (policy SamplingPolicy) sample(cntr util.ControllerInterface, steamID string, friends []util.Friend) ([]util.Friend, []util.Friend) {
+	if policy.TopN <= 0 || len(friends) <= policy.TopN {
+		return friends, nil
+	}
+	ranked := append([]util.Friend{}, friends...)
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].Steamid < ranked[j].Steamid
+	})
+
+	switch policy.By {
+	case SampleByRandom:
+		// Each user gets their own source so the friends picked don't
+		// depend on the order users happen to be crawled in
+		hash := fnv.New64a()
+		hash.Write([]byte(steamID))
+		random := rand.New(rand.NewSource(policy.Seed ^ int64(hash.Sum64())))
+		random.Shuffle(len(ranked), func(i, j int) {
+			ranked[i], ranked[j] = ranked[j], ranked[i]
+		})
+	case SampleByMutual:
+		mutuals := mutualFriendCounts(cntr, ranked)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return mutuals[ranked[i].Steamid] > mutuals[ranked[j].Steamid]
+		})
+	default:
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return ranked[i].FriendSince < ranked[j].FriendSince
+		})
+	}
+	return ranked[:policy.TopN], ranked[policy.TopN:]
+}
+
+// mutualFriendCounts counts how many of the given friends are also friends with each
+// of them. Friends that aren't cached or whose cache can't be read have a count of zero
+func mutualFriendCounts(cntr util.ControllerInterface, friends []util.Friend) map[string]int {
+	isFriend := make(map[string]bool, len(friends))
+	for _, friend := range friends {
+		isFriend[friend.Steamid] = true
+	}
+
+	mutuals := make(map[string]int, len(friends))
+	for _, friend := range friends {
+		if !isCached(cntr, friend.Steamid) {
+			continue
+		}
+		friendsObj, err := GetCache(cntr, friend.Steamid)
+		if err != nil {
+			continue
+		}
+		for _, friendOfFriend := range friendsObj.FriendsList.Friends {
+			if isFriend[friendOfFriend.Steamid] {
+				mutuals[friend.Steamid]++
+			}
+		}
+	}
+	return mutuals
+}
@@ -0,0 +1,245 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/util"
+)
+
+// DefaultLevel is the level crawls go to when no level is given. Graphs saved
+// before crawl stats were saved beside them are assumed to have been crawled to it
+const DefaultLevel = 2
+
+// CrawlStats holds the stats of a single crawl. Counts that are checkpointed
+// cover the whole crawl while cache, API call, latency and error stats only
+// cover the run that returned them when a crawl was resumed
+type CrawlStats struct {
+	SteamID  string `json:"steamID"`
+	LevelCap int    `json:"levelCap"`
+	Complete bool   `json:"complete"`
+
+	// FriendsPerLevel is how many friends were found on each level, including
+	// private and sampled out ones, and CrawledPerLevel is how many users were
+	// crawled on each level
+	FriendsPerLevel   map[int]int `json:"friendsPerLevel"`
+	CrawledPerLevel   map[int]int `json:"crawledPerLevel"`
+	TotalFriends      int         `json:"totalFriends"`
+	ReachableFriends  int         `json:"reachableFriends"`
+	UsersCrawled      int         `json:"usersCrawled"`
+	DuplicatesAvoided int         `json:"duplicatesAvoided"`
+	PrivateFriends    int         `json:"privateFriends"`
+	SkippedFriends    int         `json:"skippedFriends"`
+
+	CacheHits     int     `json:"cacheHits"`
+	CacheMisses   int     `json:"cacheMisses"`
+	CacheHitRatio float64 `json:"cacheHitRatio"`
+	// StaleRefetched is how many of the cache misses were users
+	// whose cached friend list was older than the max cache age
+	StaleRefetched int `json:"staleRefetched"`
+
+	APICalls int `json:"apiCalls"`
+	// APICallsPerKey maps each redacted API key to how many calls were made with it
+	APICallsPerKey map[string]int `json:"apiCallsPerKey"`
+	// FetchLatency is how long users that weren't cached took to fetch
+	FetchLatency LatencyStats `json:"fetchLatency"`
+	// ProfilesSummarised is how many accounts have had their player summary looked
+	// up and ProfileLookupsAvoided is how many lookups of those accounts were saved.
+	// Both cover every crawl sharing the same ProfileStore
+	ProfilesSummarised    int `json:"profilesSummarised"`
+	ProfileLookupsAvoided int `json:"profileLookupsAvoided"`
+
+	// Errors counts every failed attempt at crawling a user including
+	// ones that were retried. Failures is how many users gave up for good
+	Errors       int                      `json:"errors"`
+	ErrorsByKind map[util.FailureKind]int `json:"errorsByKind"`
+	Failures     int                      `json:"failures"`
+
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// LatencyStats holds percentiles of a set of latencies
+type LatencyStats struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// newLatencyStats works out the percentiles of the given latencies
+func newLatencyStats(latencies []time.Duration) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	sorted := append([]time.Duration{}, latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	// percentile uses the nearest rank method
+	percentile := func(p int) time.Duration {
+		rank := (p*len(sorted) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return sorted[rank-1]
+	}
+	return LatencyStats{
+		Count: len(sorted),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// statsRecorder collects the stats that aren't part of a crawl's checkpoint
+type statsRecorder struct {
+	startedAt    time.Time
+	cacheHits    int
+	cacheMisses  int
+	stale        int
+	latencies    []time.Duration
+	errors       int
+	errorsByKind map[util.FailureKind]int
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{
+		startedAt:    time.Now(),
+		errorsByKind: make(map[util.FailureKind]int),
+	}
+}
+
+// record records the outcome of a single job
+func (recorder *statsRecorder) record(result jobResult) {
+	if result.err != nil {
+		recorder.errors++
+		recorder.errorsByKind[failureKind(result.err)]++
+		return
+	}
+	if result.cached {
+		recorder.cacheHits++
+		return
+	}
+	recorder.cacheMisses++
+	if result.stale {
+		recorder.stale++
+	}
+	recorder.latencies = append(recorder.latencies, result.latency)
+}
+
+// stats builds the CrawlStats of a crawl from its state and what was recorded
+func (recorder *statsRecorder) stats(state *crawlState, apiCounter *countingController, profiles *ProfileStore, complete bool) CrawlStats {
+	stats := CrawlStats{
+		SteamID:           state.SteamID,
+		LevelCap:          state.LevelCap,
+		Complete:          complete,
+		FriendsPerLevel:   state.FriendsPerLevel,
+		CrawledPerLevel:   make(map[int]int),
+		TotalFriends:      state.TotalFriends,
+		ReachableFriends:  state.ReachableFriends,
+		UsersCrawled:      len(state.Visited),
+		DuplicatesAvoided: state.DuplicatesAvoided,
+		PrivateFriends:    state.PrivateFriends,
+		SkippedFriends:    state.Skipped.Count(),
+
+		CacheHits:      recorder.cacheHits,
+		CacheMisses:    recorder.cacheMisses,
+		StaleRefetched: recorder.stale,
+
+		APICalls:       apiCounter.apiCalls(),
+		APICallsPerKey: apiCounter.apiCallsPerKey(),
+		FetchLatency:   newLatencyStats(recorder.latencies),
+
+		ProfilesSummarised:    profiles.Summarised(),
+		ProfileLookupsAvoided: profiles.LookupsAvoided(),
+
+		Errors:       recorder.errors,
+		ErrorsByKind: recorder.errorsByKind,
+		Failures:     len(state.Failures),
+
+		StartedAt:  recorder.startedAt,
+		FinishedAt: time.Now(),
+	}
+	for _, level := range state.Visited {
+		stats.CrawledPerLevel[level]++
+	}
+	if lookups := stats.CacheHits + stats.CacheMisses; lookups > 0 {
+		stats.CacheHitRatio = float64(stats.CacheHits) / float64(lookups)
+	}
+	stats.Duration = stats.FinishedAt.Sub(stats.StartedAt)
+	return stats
+}
+
+// statsFileName is where the stats of a graph's crawls are saved, right beside the graph
+func statsFileName(graphID string) string {
+	return filepath.Join(configuration.AppConfig.FinishedGraphsLocation, fmt.Sprintf("%s.stats.json", graphID))
+}
+
+// SaveCrawlStats saves the stats of every crawl that went into a graph beside it
+func SaveCrawlStats(graphID string, stats []CrawlStats) error {
+	finishedGraphsFolder := configuration.AppConfig.FinishedGraphsLocation
+	if finishedGraphsFolder == "" {
+		return util.MakeErr(errors.New("configuration.AppConfig.FinishedGraphsLocation was not initialised before attempting to save crawl stats"))
+	}
+	err := os.MkdirAll(finishedGraphsFolder, 0755)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+
+	jsonObj, err := json.MarshalIndent(stats, "", "\t")
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	err = ioutil.WriteFile(statsFileName(graphID), jsonObj, 0644)
+	if err != nil {
+		return util.MakeErr(err)
+	}
+	return nil
+}
+
+// LoadCrawlStats loads the stats saved beside a graph
+func LoadCrawlStats(graphID string) ([]CrawlStats, error) {
+	stats := make([]CrawlStats, 0)
+	content, err := ioutil.ReadFile(statsFileName(graphID))
+	if err != nil {
+		return stats, util.MakeErr(err)
+	}
+	err = json.Unmarshal(content, &stats)
+	if err != nil {
+		return stats, util.MakeErr(err)
+	}
+	return stats, nil
+}
+
+// GetRefreshDetails finds the steamIDs and level of a graph that has already been
+// generated so it can be refreshed. The level is taken from the stats saved beside it
+// or is DefaultLevel for graphs that were saved without stats
+func GetRefreshDetails(graphID string) ([]string, int, error) {
+	identifier, err := crawlIdentifier(graphID)
+	if err != nil {
+		return nil, 0, err
+	}
+	steamIDs := strings.Split(identifier, ",")
+
+	stats, err := LoadCrawlStats(graphID)
+	if errors.Is(err, os.ErrNotExist) {
+		return steamIDs, DefaultLevel, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(stats) == 0 {
+		return nil, 0, fmt.Errorf("no crawl stats have been saved for graph %s", graphID)
+	}
+	return steamIDs, stats[0].LevelCap, nil
+}
@@ -2,11 +2,12 @@ package worker
 
 import (
 	"os"
-	"strconv"
+	"sort"
 	"strings"
 
 	"github.com/segmentio/ksuid"
 	"github.com/steamFriendsGraphing/configuration"
+	"github.com/steamFriendsGraphing/util"
 )
 
 // IsEnvVarSet does a simple check to see if an environment
@@ -19,31 +20,33 @@ func IsEnvVarSet(envvar string) bool {
 }
 
 func sortSteamIDs(steamIDs []string) ([]string, error) {
-	result := []string{}
-	if len(steamIDs) == 1 {
-		return append(result, steamIDs[0]), nil
-	}
 	// Given steamIDs a and b we can create a mapping.
 	// Given steamIDs b and a we already have made this graph and
 	// don't want to generate a new page
-	// Therefore if two steamIDs are given they are first sorted so
-	// that given a,b we always create the identifier using a,b and
-	// given b,a we always create the identifier using a,b
-
-	steamID1Int64, err := strconv.Atoi(steamIDs[0])
-	if err != nil {
-		return result, err
-	}
-	steamID2Int64, err := strconv.Atoi(steamIDs[1])
-	if err != nil {
-		return result, err
+	// Therefore the steamIDs are first sorted numerically so that
+	// any ordering of the same seeds always creates the same identifier
+	parsed := make([]util.SteamID, 0, len(steamIDs))
+	for _, steamID := range steamIDs {
+		id, err := util.ParseSteamID(steamID)
+		if err != nil {
+			return []string{}, err
+		}
+		parsed = append(parsed, id)
 	}
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i] < parsed[j] })
 
-	if steamID1Int64 < steamID2Int64 {
-		return []string{strconv.Itoa(steamID1Int64), strconv.Itoa(steamID2Int64)}, nil
+	result := make([]string, 0, len(parsed))
+	for _, id := range parsed {
+		result = append(result, id.String())
 	}
+	return result, nil
+}
 
-	return []string{strconv.Itoa(steamID2Int64), strconv.Itoa(steamID1Int64)}, nil
+// toSteamID converts a steamID a crawl was given. An invalid steamID is left
+// as zero so the crawl records it as a failure instead of stopping
+func toSteamID(steamID string) util.SteamID {
+	id, _ := util.ParseSteamID(steamID)
+	return id
 }
 
 func getSteamIDsIdentifier(steamIDs []string, urlMap map[string]string) (string, error) {
@@ -51,6 +54,29 @@ func getSteamIDsIdentifier(steamIDs []string, urlMap map[string]string) (string,
 	return strings.Join(steamIDs, ","), err
 }
 
+// dedupeSteamIDs removes repeated steamIDs, keeping them in the order first given
+func dedupeSteamIDs(steamIDs []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(steamIDs))
+	for _, steamID := range steamIDs {
+		if !seen[steamID] {
+			seen[steamID] = true
+			unique = append(unique, steamID)
+		}
+	}
+	return unique
+}
+
+// GraphIdentifier returns the key the graph of the given seed users is saved under
+// in the url map. Any ordering of the same seed users gives the same identifier
+func GraphIdentifier(steamIDs []string) (string, error) {
+	steamIDs = dedupeSteamIDs(steamIDs)
+	if len(steamIDs) == 1 {
+		return steamIDs[0], nil
+	}
+	return getSteamIDsIdentifier(steamIDs, configuration.AppConfig.UrlMap)
+}
+
 func GenerateURL(input string) {
 	identifier := ksuid.New()
 	configuration.AppConfig.UrlMap[input] = identifier.String()
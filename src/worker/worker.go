@@ -3,21 +3,20 @@ package worker
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"math"
 	"net/url"
 	"os"
 	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/steamFriendsGraphing/configuration"
 	"github.com/steamFriendsGraphing/logging"
+	"github.com/steamFriendsGraphing/queue"
 	"github.com/steamFriendsGraphing/util"
 )
 
@@ -25,22 +24,77 @@ import (
 // worker queue
 type JobsStruct struct {
 	Level                     int
-	OriginalTargetUserSteamID string
-	CurrentTargetSteamID      string
+	OriginalTargetUserSteamID util.SteamID
+	CurrentTargetSteamID      util.SteamID
 	APIKey                    string
+	// Retries is how many times this user has
+	// already failed to be crawled
+	Retries int
+}
+
+// jobResult is handed back to ControlFunc by a Worker once a
+// job has been processed
+type jobResult struct {
+	job     JobsStruct
+	friends []JobsStruct
+	// privateFriends are the user's friends with private profiles.
+	// They're left as leaves and aren't crawled
+	privateFriends []JobsStruct
+	// skippedFriends are the steamIDs of the friends
+	// left out by the crawl's sampling policy
+	skippedFriends []string
+	// usernames maps the user and each of their friends to their username
+	usernames map[string]string
+	// cached is set if the user's friends list was read from cache
+	// and stale is set if it was refetched as its cache entry was too old
+	cached bool
+	stale  bool
+	// latency is how long the user's friends list took to get
+	latency time.Duration
+	err     error
+}
+
+// CrawlResult is returned by ControlFunc once a crawl is over. If the crawl
+// was stopped early it holds everything that was crawled up until then
+type CrawlResult struct {
+	SteamID string
+	// Complete is false if the crawl was stopped before every
+	// user within the level cap was crawled
+	Complete bool
+	// Crawled maps every user that was crawled to the
+	// level they were crawled at
+	Crawled map[string]int
+	// Frontier holds the users still waiting to be crawled
+	Frontier []JobsStruct
+	// Failures holds every user that couldn't be crawled
+	Failures util.CrawlFailures
+	// DuplicatesAvoided is how many times a user was reached
+	// again after already being queued and so wasn't crawled again
+	DuplicatesAvoided int
+	// PrivateFriends is how many times a user with a private
+	// profile was reached and left as a leaf
+	PrivateFriends int
+	// StoppedBy says which budget stopped the crawl early if any did
+	StoppedBy string
+	// Skipped holds the friends left out by the sampling policy
+	Skipped util.SkippedFriends
+	Stats   CrawlStats
 }
 
 // WorkerConfig holds most of the configuration needed
 // to start the worker (apart from jobs and result channels)
 type WorkerConfig struct {
-	JobsMutex       *sync.Mutex
-	ResMutex        *sync.Mutex
-	ActiveJobsMutex *sync.Mutex
-	Wg              *sync.WaitGroup
-	Jobs            *chan JobsStruct
-	Results         *chan JobsStruct
-	LevelCap        int
-	WorkerAmount    int
+	Wg           *sync.WaitGroup
+	Jobs         *chan JobsStruct
+	Results      *chan JobsStruct
+	LevelCap     int
+	WorkerAmount int
+	// KeyPool hands out the API key used for each job
+	KeyPool *util.KeyPool
+	// Sampling decides which friends of each user are followed
+	Sampling SamplingPolicy
+	// Profiles holds every player summary looked up during the crawl
+	Profiles *ProfileStore
 }
 
 // CrawlerConfig holdes all of the configuration needed to
@@ -51,6 +105,68 @@ type CrawlerConfig struct {
 	TestKeys bool
 	Workers  int
 	APIKeys  []string
+
+	// CrawlID is the ID checkpoints are saved under. If Resume is set
+	// the crawl continues on from the last checkpoint saved for it
+	CrawlID string
+	Resume  bool
+	// Refresh re-crawls a graph that has already been generated under its
+	// existing ID. Only users with stale cache entries are fetched again
+	Refresh            bool
+	CheckpointInterval time.Duration
+
+	// MaxRetries is how many more times a user is tried after
+	// failing before they are recorded in the failures report
+	MaxRetries int
+
+	// FrontierMemory is how many queued users are kept in memory before
+	// the rest are spilled to disk. Zero means queue.DefaultMemoryLimit
+	FrontierMemory int
+
+	// Budgets stop a crawl once it has crawled MaxUsers users, made MaxAPICalls
+	// calls to the Steam web API or run for MaxDuration. Zero means no budget.
+	// A crawl stopped by a budget is checkpointed and its partial graph is rendered.
+	// Jobs are only handed out while they fit in MaxUsers but the calls of jobs
+	// already in flight can take a crawl up to Workers jobs over MaxAPICalls
+	MaxUsers    int
+	MaxAPICalls int
+	MaxDuration time.Duration
+
+	// PathFirst makes CrawlTwoUsers only search for the shortest paths
+	// between the two users instead of crawling both of them fully
+	PathFirst bool
+
+	// KeyPool is shared by every crawl using the same API keys so their
+	// health is tracked across crawls. A pool of APIKeys is made if nil
+	KeyPool *util.KeyPool
+	// Profiles can be shared by crawls so that no account is summarised
+	// twice across them. A new ProfileStore is made for each crawl if nil
+	Profiles *ProfileStore
+	// Budget can be shared by crawls so that their budgets cover all
+	// of them together. A new CrawlBudget is made for each crawl if nil
+	Budget *CrawlBudget
+
+	// Sampling only follows the top few friends of each user
+	// which makes crawls of level 3 and above tractable
+	Sampling SamplingPolicy
+
+	// Plan makes the crawl print an estimate of the API calls and time it would
+	// take, worked out from the cache and RateLimits, instead of crawling
+	Plan       bool
+	RateLimits PlanLimits
+
+	// Games enriches every crawled user with their games once the crawl
+	// is done. No games are fetched if it's empty. GameOverlay is the appID
+	// of a game whose players are highlighted on the graph, or removed from
+	// it along with everyone else if GameFilter is set. Zero means no overlay
+	Games       GamesSource
+	GameOverlay int
+	GameFilter  bool
+
+	// Progress receives events as the crawl goes on if it isn't nil. Events are
+	// dropped rather than waited on if it's full, apart from EventCrawlFinished,
+	// so it must be read from until the crawl returns
+	Progress chan<- ProgressEvent
 }
 
 // InitWorkerConfig initialises the worker based on the level and worker amount given
@@ -67,17 +183,12 @@ func InitWorkerConfig(levelCap, workerAmount int) (*WorkerConfig, error) {
 	}
 
 	var wg sync.WaitGroup
-	var resMutex sync.Mutex
-	var activeJobsMutex sync.Mutex
-	var jobMutex sync.Mutex
 
 	workConfig := &WorkerConfig{
-		JobsMutex:       &jobMutex,
-		ResMutex:        &resMutex,
-		ActiveJobsMutex: &activeJobsMutex,
-		Wg:              &wg,
-		LevelCap:        levelCap,
-		WorkerAmount:    workerAmount,
+		Wg:           &wg,
+		LevelCap:     levelCap,
+		WorkerAmount: workerAmount,
+		Profiles:     NewProfileStore(),
 	}
 	// fmt.Printf("======================================\n")
 	// fmt.Printf("       Crawler configuration\n")
@@ -94,258 +205,441 @@ func InitWorkerConfig(levelCap, workerAmount int) (*WorkerConfig, error) {
 
 // InitCrawling initialises the crawling and then starts up the graph crawler
 // that produces the HTML output
-func InitCrawling(cntr util.ControllerInterface, cfg CrawlerConfig, steamID string) {
+func InitCrawling(ctx context.Context, cntr util.ControllerInterface, cfg CrawlerConfig, steamID string) (CrawlResult, error) {
 	if cfg.TestKeys {
 		util.CheckAPIKeys(cntr, cfg.APIKeys)
 	}
 
-	ControlFunc(cntr, cfg.APIKeys, steamID, cfg.Level, cfg.Workers)
+	return ControlFunc(ctx, cntr, cfg, steamID)
 }
 
 // Worker is the crawling worker queue implementation. It takes in users off the jobs queue, processes
-//  them and places and then places user's friends onto the results queue
-func Worker(cntr util.ControllerInterface, jobs <-chan JobsStruct, results chan<- JobsStruct, cfg *WorkerConfig, activeJobs *int64) {
+// them and then places the user's friends onto the results queue. A worker returns once ctx is
+// cancelled or the jobs queue is closed
+func Worker(ctx context.Context, cntr util.ControllerInterface, jobs <-chan JobsStruct, results chan<- jobResult, cfg *WorkerConfig) {
+	defer cfg.Wg.Done()
+
 	for {
-		cfg.JobsMutex.Lock()
-		job := <-jobs
-		cfg.JobsMutex.Unlock()
-
-		// Temporary fix, sometimes level 0s get put onto jobs queue
-		if job.Level != 0 {
-			friendsObj, err := GetFriends(cntr, job, cfg.LevelCap, jobs)
-			util.CheckErr(err)
-
-			numFriends := len(friendsObj.FriendsList.Friends)
-
-			// For each friend we'll add them to the jobs queue if
-			// their level is within our range
-			for i := 0; i < numFriends; i++ {
-				indivFriends := JobsStruct{
-					OriginalTargetUserSteamID: job.OriginalTargetUserSteamID,
-					Level:                     job.Level + 1,
-					CurrentTargetSteamID:      friendsObj.FriendsList.Friends[i].Steamid,
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-jobs:
+			if !ok {
+				return
+			}
+			result := jobResult{job: job}
+
+			// An API key is only taken from the pool if the user isn't cached
+			// or their cached friend list has gone stale
+			result.cached, result.stale = cacheState(cntr, job.CurrentTargetSteamID.String())
+			if !result.cached {
+				apiKey, err := cfg.KeyPool.Acquire(ctx)
+				if err != nil {
+					result.err = err
+					results <- result
+					continue
 				}
+				job.APIKey = apiKey
+			}
 
-				// If their level is within range, we'll scrape them in the future
-				// and therefore we up the counter of activeJobs
-				if indivFriends.Level <= cfg.LevelCap {
-					atomic.AddInt64(activeJobs, 1)
+			fetchStart := time.Now()
+			friendsObj, err := getFriendsWithProfiles(ctx, cntr, job, cfg.LevelCap, jobs, cfg.Profiles)
+			result.latency = time.Since(fetchStart)
+			if job.APIKey != "" {
+				cfg.KeyPool.Report(job.APIKey, err)
+			}
+			if err != nil {
+				result.err = err
+			} else {
+				// Each friend is handed back as a job one level deeper. ControlFunc
+				// decides which of them are within range to be crawled
+				result.usernames = map[string]string{job.CurrentTargetSteamID.String(): friendsObj.Username}
+				publicFriends := make([]util.Friend, 0, len(friendsObj.FriendsList.Friends))
+				for _, friend := range friendsObj.FriendsList.Friends {
+					result.usernames[friend.Steamid] = friend.Username
+					if util.IsPrivateProfile(friend.CommunityVisibilityState) {
+						result.privateFriends = append(result.privateFriends, newFriendJob(job, friend))
+						continue
+					}
+					publicFriends = append(publicFriends, friend)
+				}
+				// Friends are only sampled if they would go on to be crawled
+				skippedFriends := []util.Friend{}
+				if job.Level < cfg.LevelCap {
+					publicFriends, skippedFriends = cfg.Sampling.sample(cntr, job.CurrentTargetSteamID.String(), publicFriends)
+				}
+				for _, friend := range publicFriends {
+					result.friends = append(result.friends, newFriendJob(job, friend))
+				}
+				for _, friend := range skippedFriends {
+					result.skippedFriends = append(result.skippedFriends, friend.Steamid)
 				}
-
-				cfg.ResMutex.Lock()
-				results <- indivFriends
-				cfg.ResMutex.Unlock()
 			}
 
-			cfg.ActiveJobsMutex.Lock()
-			atomic.AddInt64(activeJobs, -1)
-			cfg.ActiveJobsMutex.Unlock()
+			results <- result
+		}
+	}
+}
+
+// newFriendJob returns the job for crawling a friend of the user in job
+func newFriendJob(job JobsStruct, friend util.Friend) JobsStruct {
+	return JobsStruct{
+		OriginalTargetUserSteamID: job.OriginalTargetUserSteamID,
+		Level:                     job.Level + 1,
+		CurrentTargetSteamID:      toSteamID(friend.Steamid),
+	}
+}
 
-			cfg.Wg.Done()
+// validFriends drops the friends whose steamIDs aren't the SteamID64 of an individual
+// account so that they're never crawled. Every friend dropped is logged for the crawl
+func validFriends(cntr util.ControllerInterface, job JobsStruct, friends []util.Friend) []util.Friend {
+	valid := make([]util.Friend, 0, len(friends))
+	for _, friend := range friends {
+		if !util.IsValidFormatSteamID(friend.Steamid) {
+			logMsg := fmt.Sprintf("skipped friend of %s with the invalid steamID %q\n", job.CurrentTargetSteamID, friend.Steamid)
+			logging.SpecialLog(cntr, configuration.AppConfig.UrlMap[job.OriginalTargetUserSteamID.String()], logMsg)
+			continue
 		}
+		valid = append(valid, friend)
 	}
+	return valid
 }
 
 // GetFriends returns the list of friends for a given user and caches results if requested
 func GetFriends(cntr util.ControllerInterface, job JobsStruct, level int, jobs <-chan JobsStruct) (util.FriendsStruct, error) {
+	return getFriendsWithProfiles(context.Background(), cntr, job, level, jobs, NewProfileStore())
+}
+
+// getFriendsWithProfiles is GetFriends with player summaries
+// looked up through a ProfileStore shared across a crawl
+func getFriendsWithProfiles(ctx context.Context, cntr util.ControllerInterface, job JobsStruct, level int, jobs <-chan JobsStruct, profiles *ProfileStore) (util.FriendsStruct, error) {
 	startTime := time.Now().UnixNano() / int64(time.Millisecond)
 
-	exists, err := CacheFileExists(cntr, job.CurrentTargetSteamID)
+	exists, err := CacheFileExists(cntr, job.CurrentTargetSteamID.String())
 	if exists {
 		if !configuration.AppConfig.IgnoreCache {
-			friendsObj, err := GetCache(cntr, job.CurrentTargetSteamID)
+			friendsObj, err := GetCache(cntr, job.CurrentTargetSteamID.String())
 			if err != nil {
-				return util.FriendsStruct{}, err
+				return util.FriendsStruct{}, newCrawlError(util.FailureCache, err)
+			}
+			// A job without an API key was judged fresh by its worker so
+			// it's read from cache even if it has gone stale since
+			if !isStale(friendsObj) || job.APIKey == "" {
+				friendsObj.FriendsList.Friends = validFriends(cntr, job, friendsObj.FriendsList.Friends)
+				LogCall(cntr, "GET", job, friendsObj.Username, "200", util.Green, startTime)
+				return friendsObj, nil
 			}
-			LogCall(cntr, "GET", job, friendsObj.Username, "200", util.Green, startTime)
-			return friendsObj, nil
 		}
 	}
 	if err != nil {
-		return util.FriendsStruct{}, err
+		return util.FriendsStruct{}, newCrawlError(util.FailureCache, err)
 	}
 
 	// Check to see if the steamID is in the valid format now to save time
-	if valid := util.IsValidFormatSteamID(job.CurrentTargetSteamID); !valid {
+	if !job.CurrentTargetSteamID.IsValid() {
 		LogCall(cntr, "GET", job, "Invalid SteamID", "400", util.Red, startTime)
-		return util.FriendsStruct{}, util.MakeErr(fmt.Errorf("invalid steamID: %s, apikey: %s", job.CurrentTargetSteamID, job.APIKey))
+		return util.FriendsStruct{}, newCrawlError(util.FailureInvalidSteamID, util.MakeErr(fmt.Errorf("invalid steamID: %s, apikey: %s", job.CurrentTargetSteamID, job.APIKey)))
 	}
-	friendsObj, err := cntr.CallGetFriendsListAPI(url.QueryEscape(job.CurrentTargetSteamID), url.QueryEscape(job.APIKey))
+	friendsObj, err := cntr.CallGetFriendsListAPI(ctx, job.CurrentTargetSteamID.String(), url.QueryEscape(job.APIKey))
 	if err != nil {
 		LogCall(cntr, "GET", job, friendsObj.Username, "400", util.Red, startTime)
-		return util.FriendsStruct{}, err
+		return util.FriendsStruct{}, newCrawlError(friendsListFailureKind(err), err)
 	}
-
-	// Gathers usernames from steamIDs
-	steamIDsList := ""
-	friendsListLen := len(friendsObj.FriendsList.Friends)
-
-	// Only 100 steamIDs can be given per call, hence we must
-	// divide the friends list into lists of 100 or less
-	callCount, remainder := Divmod(friendsListLen, 100)
-	// less than 100 friends, only 1 call is needed
-	if callCount < 1 {
-		for ind, val := range friendsObj.FriendsList.Friends {
-			if ind < friendsListLen-1 {
-				steamIDsList += val.Steamid + ","
-			} else {
-				steamIDsList += val.Steamid
-			}
-		}
-		steamIDsList += ""
-		userStatsObj, err := cntr.CallPlayerSummaryAPI(steamIDsList, job.APIKey)
-		if err != nil {
-			var temp util.FriendsStruct
-			return temp, util.MakeErr(err)
-		}
-		// Do NOT process users with communityvisibilitystate = 1, this means they're private and will fuck up the system
-
-		// Order of received friends is random,
-		// must assign them using map[steamID]username
-		friendsMap := make(map[string]string)
-		for _, user := range userStatsObj.Response.Players {
-			friendsMap[user.Steamid] = user.Personaname
-		}
-
-		for i := 0; i < len(friendsObj.FriendsList.Friends); i++ {
-			friendsObj.FriendsList.Friends[i].Username = friendsMap[friendsObj.FriendsList.Friends[i].Steamid]
-		}
-
-	} else {
-		// More than 100 friends, subsequent calls are needed
-		for i := 0; i <= callCount; i++ {
-			//each batch of 100
-
-			steamIDsList = ""
-
-			if i < callCount {
-				// a full call of 100 friends
-				for k := 0; k < 100; k++ {
-					steamIDsList += friendsObj.FriendsList.Friends[k+(i*100)].Steamid + ","
-				}
-			} else {
-				// a batch of the remainder (less than 100)
-				for k := 0; k < remainder; k++ {
-					steamIDsList += friendsObj.FriendsList.Friends[k+(i*100)].Steamid + ","
-				}
-			}
-
-			userStatsObj, err := cntr.CallPlayerSummaryAPI(steamIDsList, job.APIKey)
-			if err != nil {
-				var temp util.FriendsStruct
-				return temp, util.MakeErr(err)
-			}
-
-			// Order of received friends is random,
-			// must assign them using map[steamID]username
-			friendsMap := make(map[string]string)
-			for _, user := range userStatsObj.Response.Players {
-				friendsMap[user.Steamid] = user.Personaname
-			}
-
-			if i < callCount {
-				for k := 0; k < 100; k++ {
-					// find the entry in the friendsObj struct and set the username field
-					friendsObj.FriendsList.Friends[k+(i*100)].Username = friendsMap[friendsObj.FriendsList.Friends[k+(i*100)].Steamid]
-				}
-			} else {
-				for k := 0; k < remainder; k++ {
-					friendsObj.FriendsList.Friends[k+(i*100)].Username = friendsMap[friendsObj.FriendsList.Friends[k+(i*100)].Steamid]
-				}
-			}
-		}
+	friendsObj.FriendsList.Friends = validFriends(cntr, job, friendsObj.FriendsList.Friends)
+
+	// The user is summarised along with their friends so that
+	// their own username doesn't need a separate call
+	steamIDs := make([]string, 0, len(friendsObj.FriendsList.Friends)+1)
+	steamIDs = append(steamIDs, job.CurrentTargetSteamID.String())
+	for _, friend := range friendsObj.FriendsList.Friends {
+		steamIDs = append(steamIDs, friend.Steamid)
 	}
-
-	username, err := util.GetUsername(cntr, job.APIKey, job.CurrentTargetSteamID)
+	players, err := profiles.Lookup(ctx, cntr, job.APIKey, steamIDs)
 	if err != nil {
-		return friendsObj, util.MakeErr(err)
+		return util.FriendsStruct{}, newCrawlError(util.FailurePlayerSummary, util.MakeErr(err))
+	}
+	for i := range friendsObj.FriendsList.Friends {
+		setFriendDetails(&friendsObj.FriendsList.Friends[i], players)
+	}
+	friendsObj.Username = players[job.CurrentTargetSteamID.String()].Personaname
+	friendsObj.CommunityVisibilityState = players[job.CurrentTargetSteamID.String()].Communityvisibilitystate
+	friendsObj.FetchedAt = time.Now()
+	// The fresh friend list is about to replace a cached one so anything
+	// that changed in between is recorded. Games fetched for the user
+	// are kept since only a games enrichment refreshes them
+	if exists {
+		friendsObj.Games = cachedGames(cntr, job.CurrentTargetSteamID.String())
+		recordFriendListChanges(cntr, job.CurrentTargetSteamID.String(), friendsObj)
 	}
-	friendsObj.Username = username
-	WriteToFile(cntr, job.APIKey, job.CurrentTargetSteamID, friendsObj)
+	WriteToFile(cntr, job.APIKey, job.CurrentTargetSteamID.String(), friendsObj)
 	// log the request along the round trip delay
 	LogCall(cntr, fmt.Sprintf("GET [%d][%d]", level, len(jobs)), job, friendsObj.Username, "200", util.Green, startTime)
 	return friendsObj, nil
 }
 
+// setFriendDetails fills in a friend's username and profile
+// visibility from their player summary
+func setFriendDetails(friend *util.Friend, players map[string]util.Player) {
+	player := players[friend.Steamid]
+	friend.Username = player.Personaname
+	friend.CommunityVisibilityState = player.Communityvisibilitystate
+}
+
 // ControlFunc is the parent function of Worker. It adds the target user to the jobs queue and then processes the
-// results queue until all users below the target level have been crawled
-func ControlFunc(cntr util.ControllerInterface, apiKeys []string, steamID string, levelCap, workerAmount int) {
-	workConfig, err := InitWorkerConfig(levelCap, workerAmount)
+// results queue until all users below the target level have been crawled. The state of the crawl is checkpointed
+// to disk every cfg.CheckpointInterval and whenever the crawl is stopped early so it can be resumed later.
+// If ctx is cancelled the workers are stopped and the partial result is returned along with the error
+func ControlFunc(ctx context.Context, cntr util.ControllerInterface, cfg CrawlerConfig, steamID string) (CrawlResult, error) {
+	crawlResult := CrawlResult{SteamID: steamID}
+	workConfig, err := InitWorkerConfig(cfg.Level, cfg.Workers)
+	if err != nil {
+		return crawlResult, err
+	}
+	state, err := initCrawlState(cfg, steamID)
 	if err != nil {
-		log.Fatal(err)
+		return crawlResult, err
 	}
 	logMsg := ""
 
-	// After level 3 the amount of friends gets CRAZY
-	// Therefore some rapid scaling is needed
-	// Level 2: 8100 buffer length
-	// Level 3: 729000 buffer length
-	// Level 4: 6.561e+07 buffer length (This is not feasible to crawl)
-	chanLen := 0
-	if levelCap <= 2 {
-		chanLen = 700
-	} else {
-		chanLen = int(math.Pow(90, float64(levelCap)))
+	// After level 3 the amount of friends gets CRAZY so the frontier is kept
+	// in a queue that spills to disk instead of in memory. Jobs are handed
+	// from it to the workers one at a time
+	frontier := queue.New(configuration.AppConfig.FrontierLocation, cfg.FrontierMemory)
+	defer frontier.Close()
+	jobs := make(chan JobsStruct)
+	results := make(chan jobResult, workConfig.WorkerAmount)
+
+	// The workers are stopped through workersCtx whether the crawl
+	// finishes, is cancelled, runs out of API keys or fails to save a checkpoint
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+
+	workConfig.KeyPool = cfg.KeyPool
+	if workConfig.KeyPool == nil {
+		workConfig.KeyPool = util.NewKeyPool(cfg.APIKeys)
+	}
+	workConfig.Sampling = cfg.Sampling
+	if cfg.Profiles != nil {
+		workConfig.Profiles = cfg.Profiles
 	}
-	jobs := make(chan JobsStruct, chanLen)
-	results := make(chan JobsStruct, chanLen)
 
-	var activeJobs int64 = 0
-	friendsPerLevel := make(map[int]int)
+	// Calls are counted for the API calls budget
+	apiCounter := &countingController{ControllerInterface: cntr}
+	budget := cfg.Budget
+	if budget == nil {
+		budget = NewCrawlBudget()
+	}
+	usedUsers, usedAPICalls := budget.used()
+	exceededBudget := func() string {
+		return cfg.exceededBudget(usedUsers+len(state.Visited), usedAPICalls+apiCounter.apiCalls())
+	}
+	// Jobs the workers are in the middle of
+	inFlight := 0
+	recorder := newStatsRecorder()
 
+	workConfig.Wg.Add(workConfig.WorkerAmount)
 	for i := 0; i < workConfig.WorkerAmount; i++ {
-		go Worker(cntr, jobs, results, workConfig, &activeJobs)
+		go Worker(workersCtx, apiCounter, jobs, results, workConfig)
 	}
 
-	tempStruct := JobsStruct{
-		OriginalTargetUserSteamID: steamID,
-		Level:                     1,
-		CurrentTargetSteamID:      steamID,
-		APIKey:                    apiKeys[0],
+	var crawlErr error
+	queueJob := func(job JobsStruct) {
+		state.add(job)
+		if err := frontier.Push(job); err != nil && crawlErr == nil {
+			crawlErr = err
+		}
+	}
+	// nextJob is the job waiting to be handed to a worker
+	var nextJob JobsStruct
+	hasNextJob := false
+	popNextJob := func() {
+		if hasNextJob {
+			return
+		}
+		nextJob = JobsStruct{}
+		var err error
+		hasNextJob, err = frontier.Pop(&nextJob)
+		if err != nil && crawlErr == nil {
+			crawlErr = err
+		}
+	}
+	saveCheckpoint := func() error {
+		if cfg.CrawlID == "" {
+			return nil
+		}
+		return SaveCheckpoint(state.checkpoint())
+	}
+	snapshot := func(event ProgressEvent) ProgressEvent {
+		event.CrawlSteamID = steamID
+		event.LevelCap = cfg.Level
+		event.UsersCrawled = len(state.Visited)
+		event.Frontier = state.pendingCount
+		event.APICalls = apiCounter.apiCalls()
+		event.Time = time.Now()
+		event.Elapsed = event.Time.Sub(recorder.startedAt)
+		event.ETA = estimateETA(event.Elapsed, event.UsersCrawled, event.Frontier)
+		return event
+	}
+	publish := func(event ProgressEvent) {
+		publishProgress(cfg.Progress, snapshot(event))
+	}
+	// Levels are finished in order as a level can't be
+	// finished while users on the level above are pending
+	nextLevel := 1
+	publishFinishedLevels := func() {
+		for ; nextLevel <= cfg.Level && state.levelPending[nextLevel] == 0; nextLevel++ {
+			publish(ProgressEvent{Kind: EventLevelFinished, Level: nextLevel})
+		}
+	}
+
+	for _, job := range state.Frontier {
+		queueJob(job)
 	}
 
-	workConfig.Wg.Add(1)
-	activeJobs++
-	jobs <- tempStruct
-	friendsPerLevel[1]++
+	checkpointInterval := cfg.CheckpointInterval
+	if checkpointInterval <= 0 {
+		checkpointInterval = defaultCheckpointInterval
+	}
+	checkpointTicker := time.NewTicker(checkpointInterval)
+	defer checkpointTicker.Stop()
+
+	var durationBudget <-chan time.Time
+	if cfg.MaxDuration > 0 {
+		durationTimer := time.NewTimer(budget.timeLeft(cfg.MaxDuration))
+		defer durationTimer.Stop()
+		durationBudget = durationTimer.C
+	}
 
-	reachableFriends := 0
-	totalFriends := 0
-	i := 1
+	stoppedBy := exceededBudget()
+	for state.pendingCount > 0 && crawlErr == nil && stoppedBy == "" {
+		// Sending on a nil channel blocks forever so jobs are only handed
+		// out while there's one waiting and it fits in the max users budget
+		popNextJob()
+		var jobsOut chan<- JobsStruct
+		if hasNextJob && cfg.canDispatch(usedUsers+len(state.Visited), inFlight) {
+			jobsOut = jobs
+		}
 
-	for {
-		if activeJobs == 0 {
-			break
+		select {
+		case jobsOut <- nextJob:
+			hasNextJob = false
+			inFlight++
+
+		case <-ctx.Done():
+			crawlErr = ctx.Err()
+
+		case <-durationBudget:
+			stoppedBy = fmt.Sprintf("the max duration budget of %s ran out", cfg.MaxDuration)
+
+		case result := <-results:
+			inFlight--
+			recorder.record(result)
+			if errors.Is(result.err, util.ErrNoValidKeys) {
+				// There's no point carrying on without a working API key. The
+				// job is left pending so it's retried when the crawl is resumed
+				crawlErr = result.err
+				break
+			}
+			if result.err != nil {
+				// A failed user is retried with the next API key until they run out
+				// of retries. After that they're recorded and the crawl carries on
+				state.done(result.job)
+				retrying := failureKind(result.err).IsRetryable() && result.job.Retries < cfg.MaxRetries
+				if retrying {
+					result.job.Retries++
+					queueJob(result.job)
+				} else {
+					state.Failures = append(state.Failures, newCrawlFailure(result.job, result.err))
+				}
+				publish(ProgressEvent{Kind: EventError, SteamID: result.job.CurrentTargetSteamID.String(), Level: result.job.Level,
+					Error: result.err.Error(), Retrying: retrying})
+				publishFinishedLevels()
+				break
+			}
+			newJobs := state.recordResult(result, cfg.Level)
+			stoppedBy = exceededBudget()
+			for _, job := range newJobs {
+				// Once a budget has run out new jobs are only kept for the checkpoint
+				if stoppedBy != "" {
+					state.add(job)
+					continue
+				}
+				queueJob(job)
+			}
+			eventKind := EventUserFetched
+			if result.cached {
+				eventKind = EventCacheHit
+			}
+			publish(ProgressEvent{Kind: eventKind, SteamID: result.job.CurrentTargetSteamID.String(), Level: result.job.Level})
+			publishFinishedLevels()
+
+		case <-checkpointTicker.C:
+			crawlErr = saveCheckpoint()
 		}
-		result := <-results
-		totalFriends++
-		friendsPerLevel[result.Level]++
-
-		if result.Level <= levelCap {
-			reachableFriends++
-
-			newJob := JobsStruct{
-				OriginalTargetUserSteamID: steamID,
-				Level:                     result.Level,
-				CurrentTargetSteamID:      result.CurrentTargetSteamID,
-				APIKey:                    apiKeys[i%len(apiKeys)],
+	}
+
+	// Jobs the workers were in the middle of when they were told to stop
+	// are still recorded so that nothing is lost from the checkpoint
+	stopWorkers()
+	close(jobs)
+	go func() {
+		workConfig.Wg.Wait()
+		close(results)
+	}()
+	for result := range results {
+		recorder.record(result)
+		if result.err == nil {
+			for _, job := range state.recordResult(result, cfg.Level) {
+				state.add(job)
 			}
-			workConfig.Wg.Add(1)
-			jobs <- newJob
-			i++
+		}
+	}
 
+	budget.spend(len(state.Visited), apiCounter.apiCalls())
+
+	crawlResult.Crawled = state.Visited
+	crawlResult.Frontier = state.checkpoint().Frontier
+	crawlResult.Failures = state.Failures
+	crawlResult.DuplicatesAvoided = state.DuplicatesAvoided
+	crawlResult.PrivateFriends = state.PrivateFriends
+	crawlResult.StoppedBy = stoppedBy
+	crawlResult.Skipped = state.Skipped
+	crawlResult.Complete = crawlErr == nil && stoppedBy == ""
+	crawlResult.Stats = recorder.stats(state, apiCounter, workConfig.Profiles, crawlResult.Complete)
+	publishFinalProgress(cfg.Progress, snapshot(ProgressEvent{Kind: EventCrawlFinished}))
+
+	if crawlErr != nil {
+		if cfg.CrawlID == "" {
+			return crawlResult, crawlErr
 		}
+		if err := saveCheckpoint(); err != nil {
+			return crawlResult, err
+		}
+		return crawlResult, fmt.Errorf("crawl %s stopped early, resume it with -resume %s: %w", cfg.CrawlID, cfg.CrawlID, crawlErr)
 	}
 
-	workConfig.Wg.Wait()
 	logMsg += "\n=============== Done ================\n"
-	logMsg += fmt.Sprintf("Total friends: %d\nCrawled friends: %d\n", totalFriends, reachableFriends)
-	logMsg += fmt.Sprintf("Friends per level: %+v\n=====================================\n", friendsPerLevel)
-	close(jobs)
-	close(results)
+	if stoppedBy != "" {
+		logMsg += fmt.Sprintf("Stopped early as %s\n", stoppedBy)
+	}
+	logMsg += fmt.Sprintf("Total friends: %d\nCrawled friends: %d\nFailed friends: %d\nDuplicates avoided: %d\nPrivate friends: %d\n",
+		state.TotalFriends, state.ReachableFriends, len(state.Failures), state.DuplicatesAvoided, state.PrivateFriends)
+	if cfg.Sampling.TopN > 0 {
+		logMsg += fmt.Sprintf("Friends skipped by sampling: %d\n", state.Skipped.Count())
+	}
+	logMsg += fmt.Sprintf("Friends per level: %+v\n=====================================\n", state.FriendsPerLevel)
 
 	logFileName := fmt.Sprintf("%s/%s.txt", configuration.AppConfig.LogsFolderLocation, configuration.AppConfig.UrlMap[steamID])
 	logging.SpecialLog(cntr, logFileName, logMsg)
+
+	// A crawl stopped by a budget is kept so that it can be resumed with a bigger budget
+	if stoppedBy != "" {
+		return crawlResult, saveCheckpoint()
+	}
+	if cfg.CrawlID != "" {
+		return crawlResult, RemoveCheckpoint(cfg.CrawlID, steamID)
+	}
+	return crawlResult, nil
 }
 
 // Divmod divides a friendslist into stacks of 100 and the remainder
@@ -362,42 +656,38 @@ func LogCall(cntr util.ControllerInterface, method string, job JobsStruct, usern
 
 	logMsg := fmt.Sprintf("%s [%s] %s %s%s%s %vms\n", method, job.CurrentTargetSteamID, username,
 		statusColor, status, "\033[0m", delay)
-	logging.SpecialLog(cntr, configuration.AppConfig.UrlMap[job.OriginalTargetUserSteamID], logMsg)
+	logging.SpecialLog(cntr, configuration.AppConfig.UrlMap[job.OriginalTargetUserSteamID.String()], logMsg)
 	// fmt.Printf("%s", logMsg)
 }
 
-// WriteToFile writes a user's friendlist to a file for later processing
+// WriteToFile writes a user's friendlist to a file for later processing.
+// An existing cache file is overwritten so that stale friend lists are replaced
 func WriteToFile(cntr util.ControllerInterface, apiKey, steamID string, friends util.FriendsStruct) error {
 	cacheFolder := configuration.AppConfig.CacheFolderLocation
 	if cacheFolder == "" {
 		return util.MakeErr(errors.New("configuration.AppConfig.CacheFolderLocation was not initialised before attempting to write to file"))
 	}
 
-	existing, err := CacheFileExists(cntr, steamID)
-	if !existing {
-		file, err := cntr.CreateFile(fmt.Sprintf("%s/%s.gz", cacheFolder, steamID))
-		if err != nil {
-			return util.MakeErr(err)
-		}
-
-		jsonObj, err := json.Marshal(friends)
-		if err != nil {
-			return util.MakeErr(err)
-		}
-		err = cntr.WriteGzip(file, string(jsonObj))
-		if err != nil {
-			return util.MakeErr(err)
-		}
+	file, err := cntr.CreateFile(fmt.Sprintf("%s/%s.gz", cacheFolder, steamID))
+	if err != nil {
+		return util.MakeErr(err)
+	}
 
-		err = file.Close()
-		if err != nil {
-			return util.MakeErr(err)
-		}
+	jsonObj, err := json.Marshal(friends)
+	if err != nil {
+		file.Close()
+		return util.MakeErr(err)
 	}
+	err = cntr.WriteGzip(file, string(jsonObj))
 	if err != nil {
-		return err
+		file.Close()
+		return util.MakeErr(err)
 	}
 
+	err = file.Close()
+	if err != nil {
+		return util.MakeErr(err)
+	}
 	return nil
 }
 
@@ -491,6 +781,46 @@ func GetUsernameFromCacheFile(cntr util.ControllerInterface, steamID string) (st
 	return "", util.MakeErr(fmt.Errorf("cache file %s.gz does not exist", steamID))
 }
 
+// isCached checks if a user's friends can be read from cache
+// instead of calling the Steam web API
+func isCached(cntr util.ControllerInterface, steamID string) bool {
+	cached, _ := cacheState(cntr, steamID)
+	return cached
+}
+
+// cacheState checks if a user's friends can be read from cache and if
+// not whether that's because their cached friend list has gone stale
+func cacheState(cntr util.ControllerInterface, steamID string) (cached, stale bool) {
+	if configuration.AppConfig.IgnoreCache {
+		return false, false
+	}
+	exists, err := CacheFileExists(cntr, steamID)
+	if err != nil || !exists {
+		return false, false
+	}
+	if configuration.AppConfig.MaxCacheAge <= 0 {
+		return true, false
+	}
+	friendsObj, err := GetCache(cntr, steamID)
+	if err != nil {
+		return false, false
+	}
+	if isStale(friendsObj) {
+		return false, true
+	}
+	return true, false
+}
+
+// isStale checks if a cached friend list is older than configuration.AppConfig.MaxCacheAge.
+// Friend lists cached before fetch times were recorded are stale whenever a max age is set
+func isStale(friends util.FriendsStruct) bool {
+	maxAge := configuration.AppConfig.MaxCacheAge
+	if maxAge <= 0 {
+		return false
+	}
+	return friends.FetchedAt.IsZero() || time.Since(friends.FetchedAt) > maxAge
+}
+
 // CacheFileExists checks whether a given cached file exists
 func CacheFileExists(cntr util.ControllerInterface, steamID string) (bool, error) {
 	cacheFolder := configuration.AppConfig.CacheFolderLocation
@@ -3,13 +3,16 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/steamFriendsGraphing/configuration"
 	"github.com/steamFriendsGraphing/util"
@@ -71,11 +74,11 @@ func TestGetFriendsWithValidInformation(t *testing.T) {
 	mockController := &util.MockControllerInterface{}
 	originalUserSteamID := "76561198282036055"
 
-	eddieDurcanSteamID := "007"
+	eddieDurcanSteamID := "76561198000000007"
 	eddieDurcanUsername := "eddieDurcan247"
 	eddieDurcanFriendsSince := 5
 
-	frenchToastSteamID := "008"
+	frenchToastSteamID := "76561198000000008"
 	frenchToastUsername := "toasteen"
 	frenchToastFriendsSince := 8
 
@@ -147,8 +150,8 @@ func TestGetFriendsWithValidInformation(t *testing.T) {
 	}
 
 	firstJob := JobsStruct{
-		OriginalTargetUserSteamID: originalUserSteamID,
-		CurrentTargetSteamID:      testCase.steamID,
+		OriginalTargetUserSteamID: toSteamID(originalUserSteamID),
+		CurrentTargetSteamID:      toSteamID(testCase.steamID),
 		Level:                     1,
 		APIKey:                    testCase.apikey,
 	}
@@ -171,10 +174,10 @@ func TestGetFriendsWithValidInformation(t *testing.T) {
 	mockController.On("OpenFile", expectedLogsFile, mock.Anything, mock.Anything).Return(tempLogFile, nil)
 
 	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
-	mockController.On("CallGetFriendsListAPI", originalUserSteamID, mock.AnythingOfType("string")).Return(friendsInfoForOriginalUser, nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, originalUserSteamID, mock.AnythingOfType("string")).Return(friendsInfoForOriginalUser, nil)
 
 	// Used to get the friendslist of the target user
-	mockController.On("CallPlayerSummaryAPI", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(friendsInfoForOriginalUserUserStats, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(friendsInfoForOriginalUserUserStats, nil)
 	// Used to get the username of the current target user
 	mockController.On("CallPlayerSummary", originalUserSteamID, mock.AnythingOfType("string")).Return(friendsUsernamesForOriginalUser, nil)
 
@@ -186,6 +189,50 @@ func TestGetFriendsWithValidInformation(t *testing.T) {
 	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
 }
 
+func TestSetFriendDetailsRecordsProfileVisibility(t *testing.T) {
+	players := map[string]util.Player{
+		"76561198090461077": {Steamid: "76561198090461077", Personaname: "eddieDurcan247", Communityvisibilitystate: 1},
+	}
+	friend := util.Friend{Steamid: "76561198090461077"}
+
+	setFriendDetails(&friend, players)
+
+	assert.Equal(t, "eddieDurcan247", friend.Username)
+	assert.Equal(t, 1, friend.CommunityVisibilityState)
+	assert.True(t, util.IsPrivateProfile(friend.CommunityVisibilityState))
+}
+
+func TestValidFriendsSkipsMalformedSteamIDs(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	originalUserSteamID := "76561198282036055"
+	job := JobsStruct{
+		OriginalTargetUserSteamID: toSteamID(originalUserSteamID),
+		CurrentTargetSteamID:      toSteamID(originalUserSteamID),
+		Level:                     1,
+	}
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	defer os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+	expectedLogsFile := fmt.Sprintf("%s/%s.txt", configuration.AppConfig.LogsFolderLocation, configuration.AppConfig.UrlMap[originalUserSteamID])
+	tempLogFile, err := os.Create(expectedLogsFile)
+	if err != nil {
+		t.Error(err)
+	}
+	mockController.On("OpenFile", expectedLogsFile, mock.AnythingOfType("int"), mock.AnythingOfType("os.FileMode")).Return(tempLogFile, nil)
+
+	friends := []util.Friend{
+		{Steamid: "76561198090461077"},
+		{Steamid: "not a steamID"},
+		{Steamid: ""},
+		{Steamid: "76561198063271448"},
+	}
+
+	valid := validFriends(mockController, job, friends)
+
+	assert.Equal(t, []util.Friend{friends[0], friends[3]}, valid)
+	mockController.AssertNumberOfCalls(t, "OpenFile", 2)
+}
+
 func TestGetFriendsWithInvalidGetFriendsAPICallWhenRetrievingTargetUsersFriends(t *testing.T) {
 	mockController := &util.MockControllerInterface{}
 	originalUserSteamID := "76561198282036055"
@@ -204,8 +251,8 @@ func TestGetFriendsWithInvalidGetFriendsAPICallWhenRetrievingTargetUsersFriends(
 	}
 
 	firstJob := JobsStruct{
-		OriginalTargetUserSteamID: originalUserSteamID,
-		CurrentTargetSteamID:      testCase.steamID,
+		OriginalTargetUserSteamID: toSteamID(originalUserSteamID),
+		CurrentTargetSteamID:      toSteamID(testCase.steamID),
 		Level:                     1,
 		APIKey:                    testCase.apikey,
 	}
@@ -224,7 +271,7 @@ func TestGetFriendsWithInvalidGetFriendsAPICallWhenRetrievingTargetUsersFriends(
 	mockController.On("OpenFile", expectedLogsFile, mock.AnythingOfType("int"), mock.AnythingOfType("os.FileMode")).Return(tempLogFile, nil)
 
 	getFriendsListAPIError := errors.New("error")
-	mockController.On("CallGetFriendsListAPI", originalUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{}, getFriendsListAPIError)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, originalUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{}, getFriendsListAPIError)
 
 	friends, err := GetFriends(mockController, firstJob, 1, jobs)
 
@@ -252,8 +299,8 @@ func TestGetFriendsWithInvalidFormatSteamID(t *testing.T) {
 	}
 
 	firstJob := JobsStruct{
-		OriginalTargetUserSteamID: originalUserSteamID,
-		CurrentTargetSteamID:      testCase.steamID,
+		OriginalTargetUserSteamID: toSteamID(originalUserSteamID),
+		CurrentTargetSteamID:      toSteamID(testCase.steamID),
 		Level:                     1,
 		APIKey:                    testCase.apikey,
 	}
@@ -270,7 +317,8 @@ func TestGetFriendsWithInvalidFormatSteamID(t *testing.T) {
 	mockController.On("OpenFile", expectedLogsFile, mock.AnythingOfType("int"), mock.AnythingOfType("os.FileMode")).Return(tempLogFile, nil)
 
 	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
-	expectedError := errors.New(fmt.Sprintf("invalid steamID: %s, apikey: %s", testCase.steamID, testCase.apikey))
+	// A steamID that can't be parsed is held as zero
+	expectedError := errors.New(fmt.Sprintf("invalid steamID: %s, apikey: %s", firstJob.CurrentTargetSteamID, testCase.apikey))
 
 	friends, err := GetFriends(mockController, firstJob, 1, jobs)
 
@@ -280,6 +328,1094 @@ func TestGetFriendsWithInvalidFormatSteamID(t *testing.T) {
 	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
 }
 
+func TestSaveAndLoadCheckpoint(t *testing.T) {
+	state := newCrawlState("testCrawlID", "76561198282036055", 2)
+	firstJob := state.Frontier[0]
+	firstJob.APIKey = "apiKey1"
+	state.add(firstJob)
+	state.TotalFriends = 3
+
+	err := SaveCheckpoint(state.checkpoint())
+	assert.Nil(t, err)
+
+	checkpoint, exists, err := LoadCheckpoint("testCrawlID", "76561198282036055")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 2, checkpoint.LevelCap)
+	assert.Equal(t, 3, checkpoint.TotalFriends)
+	assert.Len(t, checkpoint.Frontier, 1)
+	// API keys should never be written to disk
+	assert.Empty(t, checkpoint.Frontier[0].APIKey)
+
+	err = RemoveCheckpoint("testCrawlID", "76561198282036055")
+	assert.Nil(t, err)
+	_, exists, err = LoadCheckpoint("testCrawlID", "76561198282036055")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	os.RemoveAll(configuration.AppConfig.CheckpointsLocation)
+}
+
+func TestCacheStateRefetchesStaleFriendLists(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "cacheTest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.CacheFolderLocation = cacheDir
+
+	cntr := util.Controller{}
+	err = WriteToFile(cntr, "", "76561198282036055", util.FriendsStruct{Username: "fresh", FetchedAt: time.Now()})
+	assert.Nil(t, err)
+	err = WriteToFile(cntr, "", "76561198130544932", util.FriendsStruct{Username: "stale", FetchedAt: time.Now().Add(-48 * time.Hour)})
+	assert.Nil(t, err)
+	err = WriteToFile(cntr, "", "76561197960287930", util.FriendsStruct{Username: "unknown age"})
+	assert.Nil(t, err)
+
+	// Without a max age every cache entry is trusted
+	for _, steamID := range []string{"76561198282036055", "76561198130544932", "76561197960287930"} {
+		cached, stale := cacheState(cntr, steamID)
+		assert.True(t, cached)
+		assert.False(t, stale)
+	}
+
+	configuration.AppConfig.MaxCacheAge = 24 * time.Hour
+	cached, stale := cacheState(cntr, "76561198282036055")
+	assert.True(t, cached)
+	assert.False(t, stale)
+	cached, stale = cacheState(cntr, "76561198130544932")
+	assert.False(t, cached)
+	assert.True(t, stale)
+	cached, stale = cacheState(cntr, "76561197960287930")
+	assert.False(t, cached)
+	assert.True(t, stale)
+	cached, stale = cacheState(cntr, "76561198090461077")
+	assert.False(t, cached)
+	assert.False(t, stale)
+
+	// A refetched friend list replaces the stale one
+	err = WriteToFile(cntr, "", "76561198130544932", util.FriendsStruct{Username: "refetched", FetchedAt: time.Now()})
+	assert.Nil(t, err)
+	friends, err := GetCache(cntr, "76561198130544932")
+	assert.Nil(t, err)
+	assert.Equal(t, "refetched", friends.Username)
+	assert.True(t, isCached(cntr, "76561198130544932"))
+}
+
+func TestDiffFriendListsFindsAddedRemovedAndRenamedFriends(t *testing.T) {
+	previous := util.FriendsStruct{Username: "moose"}
+	previous.FriendsList.Friends = []util.Friend{
+		{Steamid: "76561198130544932", Username: "Joe"},
+		{Steamid: "76561197960287930", Username: "Declan"},
+		{Steamid: "76561198090461077", Username: ""},
+	}
+	current := util.FriendsStruct{Username: "moose2"}
+	current.FriendsList.Friends = []util.Friend{
+		{Steamid: "76561198130544932", Username: "Joseph"},
+		{Steamid: "76561198090461077", Username: "Michael"},
+		{Steamid: "76561198030000000", Username: "Johnny"},
+	}
+
+	change := diffFriendLists("76561198282036055", previous, current)
+	assert.False(t, change.IsEmpty())
+	assert.Equal(t, []util.Friend{{Steamid: "76561198030000000", Username: "Johnny"}}, change.Added)
+	assert.Equal(t, []util.Friend{{Steamid: "76561197960287930", Username: "Declan"}}, change.Removed)
+	// A friend whose name wasn't known before hasn't been renamed
+	assert.Equal(t, []Rename{
+		{SteamID: "76561198282036055", From: "moose", To: "moose2"},
+		{SteamID: "76561198130544932", From: "Joe", To: "Joseph"},
+	}, change.Renamed)
+
+	assert.True(t, diffFriendLists("76561198282036055", current, current).IsEmpty())
+}
+
+func TestChangeHistoryOfAGraphCoversEveryCachedUser(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "changesTest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.CacheFolderLocation = tempDir
+	configuration.AppConfig.ChangesLocation = tempDir
+	configuration.AppConfig.FinishedGraphsLocation = tempDir
+	configuration.AppConfig.UrlMap = map[string]string{"76561198282036055": "testGraphID"}
+	assert.Nil(t, SaveCrawlStats("testGraphID", []CrawlStats{{SteamID: "76561198282036055", LevelCap: 2}}))
+
+	cntr := util.Controller{}
+	seed := util.FriendsStruct{Username: "moose", FetchedAt: time.Now()}
+	seed.FriendsList.Friends = []util.Friend{{Steamid: "76561198130544932", Username: "Joe"}}
+	assert.Nil(t, WriteToFile(cntr, "", "76561198282036055", seed))
+	friend := util.FriendsStruct{Username: "Joe", FetchedAt: time.Now()}
+	friend.FriendsList.Friends = []util.Friend{{Steamid: "76561198282036055", Username: "moose"}}
+	assert.Nil(t, WriteToFile(cntr, "", "76561198130544932", friend))
+
+	// The seed drops Joe and Joe renames
+	refetchedSeed := util.FriendsStruct{Username: "moose", FetchedAt: time.Now()}
+	recordFriendListChanges(cntr, "76561198282036055", refetchedSeed)
+	refetchedFriend := friend
+	refetchedFriend.Username = "Joseph"
+	recordFriendListChanges(cntr, "76561198130544932", refetchedFriend)
+	// Nothing is recorded when nothing changed
+	recordFriendListChanges(cntr, "76561198130544932", friend)
+
+	history, err := ChangeHistory(cntr, "76561198130544932")
+	assert.Nil(t, err)
+	assert.Len(t, history, 1)
+
+	history, err = ChangeHistory(cntr, "testGraphID")
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	for _, change := range history {
+		if change.SteamID == "76561198282036055" {
+			assert.Equal(t, []util.Friend{{Steamid: "76561198130544932", Username: "Joe"}}, change.Removed)
+		}
+	}
+
+	_, err = ChangeHistory(cntr, "notAGraph")
+	assert.NotNil(t, err)
+}
+
+func TestPlanCrawlEstimatesUncachedUsersFromTheCache(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "planTest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.CacheFolderLocation = cacheDir
+
+	cntr := util.Controller{}
+	seed := util.FriendsStruct{Username: "moose"}
+	seed.FriendsList.Friends = []util.Friend{
+		{Steamid: "76561198130544932"},
+		{Steamid: "76561197960287930"},
+		{Steamid: "76561198090461077"},
+		{Steamid: "76561198030000000", CommunityVisibilityState: 1},
+	}
+	assert.Nil(t, WriteToFile(cntr, "", "76561198282036055", seed))
+	friend := util.FriendsStruct{Username: "Joe"}
+	friend.FriendsList.Friends = []util.Friend{{Steamid: "76561198282036055"}, {Steamid: "76561198040000000"}}
+	assert.Nil(t, WriteToFile(cntr, "", "76561198130544932", friend))
+
+	config := CrawlerConfig{
+		Level:   3,
+		Workers: 2,
+		APIKeys: []string{"key1", "key2"},
+		RateLimits: PlanLimits{
+			Global:      util.RateLimit{PerSecond: 1},
+			PerKey:      util.RateLimit{PerDay: 100},
+			CallLatency: time.Second,
+		},
+	}
+	plan := PlanCrawl(cntr, config, []string{"76561198282036055"})
+
+	assert.Equal(t, []LevelPlan{
+		{Level: 1, KnownUsers: 1, Cached: 1},
+		{Level: 2, KnownUsers: 3, Cached: 1, Uncached: 2, Fetches: 2},
+		// The two uncached users on level 2 are expected to have 2.5 followed
+		// friends each of which 4 out of every 5 haven't been seen before
+		{Level: 3, KnownUsers: 1, Uncached: 1, EstimatedUsers: 4, Fetches: 5},
+	}, plan.Levels)
+	assert.Equal(t, 7, plan.FriendListCalls)
+	assert.Equal(t, 1, plan.PlayerSummaryCalls)
+	assert.Equal(t, 4, plan.CallsPerKey)
+	assert.InDelta(t, 0.04, plan.KeyQuotaUsed, 0.0001)
+	// The global limit of one call a second is slower than the workers
+	assert.Equal(t, 8*time.Second, plan.WallTime)
+}
+
+func TestEstimateWallTimeWaitsForDailyLimits(t *testing.T) {
+	limits := PlanLimits{PerKey: util.RateLimit{PerSecond: 10, PerDay: 100}, CallLatency: time.Millisecond}
+	// 100 calls are made on each of the first two days and the last 50 on the third
+	assert.Equal(t, 48*time.Hour+5*time.Second, estimateWallTime(250, 1, 100, limits))
+}
+
+func TestCrawlStateOnlyCheckpointsPendingJobs(t *testing.T) {
+	state := newCrawlState("testCrawlID", "76561198282036055", 2)
+	firstJob := JobsStruct{Level: 1, CurrentTargetSteamID: util.SteamID(76561198282036055)}
+	secondJob := JobsStruct{Level: 2, CurrentTargetSteamID: util.SteamID(76561198130544932)}
+
+	state.add(firstJob)
+	state.add(secondJob)
+	state.finish(firstJob)
+
+	checkpoint := state.checkpoint()
+
+	assert.Equal(t, []JobsStruct{secondJob}, checkpoint.Frontier)
+	assert.Equal(t, 1, checkpoint.Visited["76561198282036055"])
+}
+
+func TestCrawlStateOnlyQueuesEachUserOnce(t *testing.T) {
+	state := newCrawlState("", "76561198282036055", 3)
+	friend := JobsStruct{Level: 3, CurrentTargetSteamID: util.SteamID(76561198063271448)}
+
+	assert.False(t, state.markSeen(JobsStruct{Level: 2, CurrentTargetSteamID: util.SteamID(76561198282036055)}))
+	assert.True(t, state.markSeen(friend))
+	assert.False(t, state.markSeen(friend))
+	// Reaching a user at a lower level means their friends are within range
+	friend.Level = 2
+	assert.True(t, state.markSeen(friend))
+	assert.False(t, state.markSeen(friend))
+
+	assert.Equal(t, 3, state.DuplicatesAvoided)
+	assert.Equal(t, 2, state.Seen["76561198063271448"])
+}
+
+func TestControlFuncWithCancelledContextReturnsPartialResult(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	originalUserSteamID := "76561198282036055"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, originalUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{}, errors.New("error"))
+
+	crawlerConfig := CrawlerConfig{
+		Level:   2,
+		Workers: 2,
+		APIKeys: []string{"apiKey1"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := ControlFunc(ctx, mockController, crawlerConfig, originalUserSteamID)
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, result.Complete)
+	assert.Len(t, result.Frontier, 1)
+	assert.Equal(t, originalUserSteamID, result.Frontier[0].CurrentTargetSteamID.String())
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestControlFuncRecordsFailedUsersAndFinishesCrawl(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	originalUserSteamID := "76561198282036055"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, originalUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{}, errors.New("error"))
+
+	crawlerConfig := CrawlerConfig{
+		Level:      2,
+		Workers:    2,
+		APIKeys:    []string{"apiKey1", "apiKey2"},
+		MaxRetries: 1,
+	}
+
+	result, err := ControlFunc(context.Background(), mockController, crawlerConfig, originalUserSteamID)
+
+	assert.Nil(t, err)
+	assert.True(t, result.Complete)
+	assert.Len(t, result.Frontier, 0)
+	assert.Len(t, result.Failures, 1)
+	assert.Equal(t, originalUserSteamID, result.Failures[0].SteamID)
+	assert.Equal(t, util.FailureFriendsList, result.Failures[0].Kind)
+	assert.Equal(t, 1, result.Failures[0].Retries)
+	mockController.AssertCalled(t, "CallGetFriendsListAPI", mock.Anything, originalUserSteamID, "apiKey1")
+	mockController.AssertCalled(t, "CallGetFriendsListAPI", mock.Anything, originalUserSteamID, "apiKey2")
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestControlFuncStopsWhenEveryAPIKeyIsQuarantined(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	originalUserSteamID := "76561198282036055"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, originalUserSteamID, "apiKey1").Return(util.FriendsStruct{}, util.MakeErr(fmt.Errorf("%w: apiKey1", util.ErrInvalidKey)))
+
+	crawlerConfig := CrawlerConfig{
+		Level:      2,
+		Workers:    2,
+		APIKeys:    []string{"apiKey1"},
+		MaxRetries: 2,
+	}
+
+	result, err := ControlFunc(context.Background(), mockController, crawlerConfig, originalUserSteamID)
+
+	assert.True(t, errors.Is(err, util.ErrNoValidKeys))
+	assert.False(t, result.Complete)
+	assert.Len(t, result.Frontier, 1)
+	assert.Empty(t, result.Failures)
+	mockController.AssertNumberOfCalls(t, "CallGetFriendsListAPI", 1)
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestFailureKindOfUntaggedErrorIsUnknown(t *testing.T) {
+	taggedErr := newCrawlError(util.FailureInvalidSteamID, errors.New("invalid steamID"))
+
+	assert.Equal(t, util.FailureInvalidSteamID, failureKind(fmt.Errorf("wrapped: %w", taggedErr)))
+	assert.Equal(t, util.FailureUnknown, failureKind(errors.New("error")))
+	assert.False(t, failureKind(taggedErr).IsRetryable())
+}
+
+func TestFriendsListFailureKindOfSentinelErrors(t *testing.T) {
+	notFound := util.MakeErr(fmt.Errorf("%w: invalid steamID given", util.ErrNotFound))
+	assert.Equal(t, util.FailureInvalidSteamID, friendsListFailureKind(notFound))
+	assert.Equal(t, util.FailurePrivateProfile, friendsListFailureKind(util.MakeErr(util.ErrPrivateProfile)))
+	assert.False(t, util.FailurePrivateProfile.IsRetryable())
+	assert.Equal(t, util.FailureFriendsList, friendsListFailureKind(util.MakeErr(util.ErrRateLimited)))
+}
+
+func TestFindPathsStopsWhenBothSidesMeet(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	firstUserSteamID := "76561198000000001"
+	secondUserSteamID := "76561198000000002"
+	mutualFriendSteamID := "76561198000000003"
+	otherFriendSteamID := "76561198000000004"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	dummyFile, err := ioutil.TempFile("", "tempCacheFile.gz")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(dummyFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CreateFile", mock.AnythingOfType("string")).Return(dummyFile, nil)
+	mockController.On("WriteGzip", mock.AnythingOfType("*os.File"), mock.AnythingOfType("string")).Return(nil)
+
+	friendsList := func(steamIDs ...string) util.FriendsStruct {
+		friends := util.FriendsStruct{}
+		for _, steamID := range steamIDs {
+			friends.FriendsList.Friends = append(friends.FriendsList.Friends, util.Friend{Steamid: steamID, Relationship: "friend"})
+		}
+		return friends
+	}
+	mockController.On("CallGetFriendsListAPI", mock.Anything, firstUserSteamID, mock.AnythingOfType("string")).Return(friendsList(otherFriendSteamID, mutualFriendSteamID), nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, secondUserSteamID, mock.AnythingOfType("string")).Return(friendsList(mutualFriendSteamID), nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.UserStatsStruct{
+		Response: util.Response{
+			Players: []util.Player{
+				{Steamid: mutualFriendSteamID, Personaname: "mutualFriend", Communityvisibilitystate: util.CommunityVisibilityPublic},
+				{Steamid: otherFriendSteamID, Personaname: "otherFriend", Communityvisibilitystate: util.CommunityVisibilityPublic},
+			},
+		},
+	}, nil)
+
+	crawlerConfig := CrawlerConfig{
+		Level:   3,
+		Workers: 2,
+		APIKeys: []string{"apiKey1"},
+	}
+
+	pathResult, err := FindPaths(context.Background(), mockController, crawlerConfig, firstUserSteamID, secondUserSteamID)
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{firstUserSteamID, mutualFriendSteamID, secondUserSteamID}}, pathResult.Paths)
+	assert.Equal(t, 2, pathResult.UsersCrawled)
+	assert.Equal(t, "mutualFriend", pathResult.Usernames[mutualFriendSteamID])
+	// Neither of the friends needed to be crawled to find the path
+	mockController.AssertNotCalled(t, "CallGetFriendsListAPI", mock.Anything, mutualFriendSteamID, mock.Anything)
+	mockController.AssertNotCalled(t, "CallGetFriendsListAPI", mock.Anything, otherFriendSteamID, mock.Anything)
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestFindPathsRetriesFailedUsers(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	firstUserSteamID := "76561198000000001"
+	secondUserSteamID := "76561198000000002"
+	mutualFriendSteamID := "76561198000000003"
+	otherFriendSteamID := "76561198000000004"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	dummyFile, err := ioutil.TempFile("", "tempCacheFile.gz")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(dummyFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CreateFile", mock.AnythingOfType("string")).Return(dummyFile, nil)
+	mockController.On("WriteGzip", mock.AnythingOfType("*os.File"), mock.AnythingOfType("string")).Return(nil)
+
+	friendsList := func(steamIDs ...string) util.FriendsStruct {
+		friends := util.FriendsStruct{}
+		for _, steamID := range steamIDs {
+			friends.FriendsList.Friends = append(friends.FriendsList.Friends, util.Friend{Steamid: steamID, Relationship: "friend"})
+		}
+		return friends
+	}
+	// The first user's friend list fails to be fetched the first time
+	mockController.On("CallGetFriendsListAPI", mock.Anything, firstUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{}, errors.New("connection reset")).Once()
+	mockController.On("CallGetFriendsListAPI", mock.Anything, firstUserSteamID, mock.AnythingOfType("string")).Return(friendsList(otherFriendSteamID, mutualFriendSteamID), nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, secondUserSteamID, mock.AnythingOfType("string")).Return(friendsList(mutualFriendSteamID), nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.UserStatsStruct{
+		Response: util.Response{
+			Players: []util.Player{
+				{Steamid: mutualFriendSteamID, Personaname: "mutualFriend", Communityvisibilitystate: util.CommunityVisibilityPublic},
+				{Steamid: otherFriendSteamID, Personaname: "otherFriend", Communityvisibilitystate: util.CommunityVisibilityPublic},
+			},
+		},
+	}, nil)
+
+	crawlerConfig := CrawlerConfig{
+		Level:      3,
+		Workers:    2,
+		APIKeys:    []string{"apiKey1"},
+		MaxRetries: 1,
+	}
+
+	pathResult, err := FindPaths(context.Background(), mockController, crawlerConfig, firstUserSteamID, secondUserSteamID)
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{firstUserSteamID, mutualFriendSteamID, secondUserSteamID}}, pathResult.Paths)
+	assert.Empty(t, pathResult.Failures)
+	assert.Len(t, pathResult.Stats, 2)
+	assert.Equal(t, 1, pathResult.Stats[0].Errors)
+	assert.True(t, pathResult.Stats[0].Complete)
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestFindPathsMeetsThroughSampledOutFriends(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	firstUserSteamID := "76561198000000001"
+	secondUserSteamID := "76561198000000002"
+	mutualFriendSteamID := "76561198000000003"
+	otherFriendSteamID := "76561198000000004"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	dummyFile, err := ioutil.TempFile("", "tempCacheFile.gz")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(dummyFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CreateFile", mock.AnythingOfType("string")).Return(dummyFile, nil)
+	mockController.On("WriteGzip", mock.AnythingOfType("*os.File"), mock.AnythingOfType("string")).Return(nil)
+
+	// Only the longest standing friend of the first user is followed
+	mockController.On("CallGetFriendsListAPI", mock.Anything, firstUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{
+		FriendsList: util.Friendslist{
+			Friends: []util.Friend{
+				{Steamid: otherFriendSteamID, Relationship: "friend", FriendSince: 1},
+				{Steamid: mutualFriendSteamID, Relationship: "friend", FriendSince: 2},
+			},
+		},
+	}, nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, otherFriendSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{}, nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, secondUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{
+		FriendsList: util.Friendslist{
+			Friends: []util.Friend{{Steamid: mutualFriendSteamID, Relationship: "friend"}},
+		},
+	}, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.UserStatsStruct{
+		Response: util.Response{
+			Players: []util.Player{
+				{Steamid: mutualFriendSteamID, Personaname: "mutualFriend", Communityvisibilitystate: util.CommunityVisibilityPublic},
+				{Steamid: otherFriendSteamID, Personaname: "otherFriend", Communityvisibilitystate: util.CommunityVisibilityPublic},
+			},
+		},
+	}, nil)
+
+	crawlerConfig := CrawlerConfig{
+		Level:    3,
+		Workers:  2,
+		APIKeys:  []string{"apiKey1"},
+		Sampling: SamplingPolicy{TopN: 1},
+	}
+
+	pathResult, err := FindPaths(context.Background(), mockController, crawlerConfig, firstUserSteamID, secondUserSteamID)
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{firstUserSteamID, mutualFriendSteamID, secondUserSteamID}}, pathResult.Paths)
+	assert.Equal(t, 1, pathResult.Stats[0].SkippedFriends)
+	mockController.AssertNotCalled(t, "CallGetFriendsListAPI", mock.Anything, mutualFriendSteamID, mock.Anything)
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestFindPathsStopsWhenUsersBudgetRunsOut(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	firstUserSteamID := "76561198000000001"
+	secondUserSteamID := "76561198000000002"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	dummyFile, err := ioutil.TempFile("", "tempCacheFile.gz")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(dummyFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CreateFile", mock.AnythingOfType("string")).Return(dummyFile, nil)
+	mockController.On("WriteGzip", mock.AnythingOfType("*os.File"), mock.AnythingOfType("string")).Return(nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, firstUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{
+		FriendsList: util.Friendslist{
+			Friends: []util.Friend{{Steamid: "76561198000000003", Relationship: "friend"}},
+		},
+	}, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.UserStatsStruct{}, nil)
+
+	crawlerConfig := CrawlerConfig{
+		Level:    3,
+		Workers:  2,
+		APIKeys:  []string{"apiKey1"},
+		MaxUsers: 1,
+	}
+
+	pathResult, err := FindPaths(context.Background(), mockController, crawlerConfig, firstUserSteamID, secondUserSteamID)
+
+	assert.Nil(t, err)
+	assert.Empty(t, pathResult.Paths)
+	assert.Equal(t, "the max users budget of 1 was reached", pathResult.StoppedBy)
+	assert.Equal(t, 1, pathResult.UsersCrawled)
+	assert.False(t, pathResult.Stats[0].Complete)
+	mockController.AssertNotCalled(t, "CallGetFriendsListAPI", mock.Anything, secondUserSteamID, mock.Anything)
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestJoinPathsBuildsEveryShortestPath(t *testing.T) {
+	first := newSearchSide("a", nil, 3)
+	first.depth = 1
+	first.addFriend("a", "x")
+	first.addFriend("a", "y")
+	first.depth = 2
+	first.addFriend("x", "m")
+	first.addFriend("y", "m")
+	second := newSearchSide("b", nil, 3)
+	second.depth = 1
+	second.addFriend("b", "m")
+
+	paths := joinPaths(first, second, []string{"m"})
+
+	assert.ElementsMatch(t, [][]string{{"a", "x", "m", "b"}, {"a", "y", "m", "b"}}, paths)
+}
+
+func TestControlFuncStopsWhenUsersBudgetRunsOut(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	originalUserSteamID := "76561198000000001"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	dummyFile, err := ioutil.TempFile("", "tempCacheFile.gz")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(dummyFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CreateFile", mock.AnythingOfType("string")).Return(dummyFile, nil)
+	mockController.On("WriteGzip", mock.AnythingOfType("*os.File"), mock.AnythingOfType("string")).Return(nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, originalUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{
+		FriendsList: util.Friendslist{
+			Friends: []util.Friend{
+				{Steamid: "76561198000000002", Relationship: "friend"},
+				{Steamid: "76561198000000003", Relationship: "friend"},
+			},
+		},
+	}, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.UserStatsStruct{
+		Response: util.Response{Players: []util.Player{{Steamid: originalUserSteamID, Personaname: "original"}}},
+	}, nil)
+
+	crawlerConfig := CrawlerConfig{
+		Level:    2,
+		Workers:  2,
+		APIKeys:  []string{"apiKey1"},
+		MaxUsers: 1,
+	}
+
+	result, err := ControlFunc(context.Background(), mockController, crawlerConfig, originalUserSteamID)
+
+	assert.Nil(t, err)
+	assert.False(t, result.Complete)
+	assert.Equal(t, "the max users budget of 1 was reached", result.StoppedBy)
+	assert.Len(t, result.Crawled, 1)
+	assert.Len(t, result.Frontier, 2)
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestControlFuncDoesNotDispatchPastTheUsersBudget(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	originalUserSteamID := "76561198000000001"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	dummyFile, err := ioutil.TempFile("", "tempCacheFile.gz")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(dummyFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CreateFile", mock.AnythingOfType("string")).Return(dummyFile, nil)
+	mockController.On("WriteGzip", mock.AnythingOfType("*os.File"), mock.AnythingOfType("string")).Return(nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.FriendsStruct{
+		FriendsList: util.Friendslist{
+			Friends: []util.Friend{
+				{Steamid: "76561198000000002", Relationship: "friend"},
+				{Steamid: "76561198000000003", Relationship: "friend"},
+				{Steamid: "76561198000000004", Relationship: "friend"},
+			},
+		},
+	}, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.UserStatsStruct{
+		Response: util.Response{Players: []util.Player{{Steamid: originalUserSteamID, Personaname: "original"}}},
+	}, nil)
+
+	crawlerConfig := CrawlerConfig{
+		Level:    3,
+		Workers:  4,
+		APIKeys:  []string{"apiKey1"},
+		MaxUsers: 2,
+	}
+
+	result, err := ControlFunc(context.Background(), mockController, crawlerConfig, originalUserSteamID)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "the max users budget of 2 was reached", result.StoppedBy)
+	assert.Len(t, result.Crawled, 2)
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestControlFuncSharesItsBudget(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	originalUserSteamID := "76561198000000001"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+
+	// An earlier seed's crawl already used up the whole budget
+	budget := NewCrawlBudget()
+	budget.spend(5, 20)
+	crawlerConfig := CrawlerConfig{
+		Level:    2,
+		Workers:  2,
+		APIKeys:  []string{"apiKey1"},
+		MaxUsers: 5,
+		Budget:   budget,
+	}
+
+	result, err := ControlFunc(context.Background(), mockController, crawlerConfig, originalUserSteamID)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "the max users budget of 5 was reached", result.StoppedBy)
+	assert.Len(t, result.Crawled, 0)
+	assert.Len(t, result.Frontier, 1)
+	mockController.AssertNotCalled(t, "CallGetFriendsListAPI", mock.Anything, mock.Anything, mock.Anything)
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestControlFuncPublishesProgressEvents(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	originalUserSteamID := "76561198000000001"
+
+	os.Mkdir(configuration.AppConfig.LogsFolderLocation, 0755)
+	tempLogFile, err := ioutil.TempFile("", "tempLogFile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(tempLogFile.Name())
+	dummyFile, err := ioutil.TempFile("", "tempCacheFile.gz")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove(dummyFile.Name())
+	mockController.On("OpenFile", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(tempLogFile, nil)
+	mockController.On("FileExists", mock.AnythingOfType("string")).Return(false)
+	mockController.On("CreateFile", mock.AnythingOfType("string")).Return(dummyFile, nil)
+	mockController.On("WriteGzip", mock.AnythingOfType("*os.File"), mock.AnythingOfType("string")).Return(nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, originalUserSteamID, mock.AnythingOfType("string")).Return(util.FriendsStruct{
+		FriendsList: util.Friendslist{
+			Friends: []util.Friend{
+				{Steamid: "76561198000000002", Relationship: "friend"},
+				{Steamid: "76561198000000003", Relationship: "friend"},
+			},
+		},
+	}, nil)
+	mockController.On("CallGetFriendsListAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.FriendsStruct{}, nil)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(util.UserStatsStruct{
+		Response: util.Response{Players: []util.Player{{Steamid: originalUserSteamID, Personaname: "original"}}},
+	}, nil)
+
+	progress := make(chan ProgressEvent, 20)
+	crawlerConfig := CrawlerConfig{
+		Level:    2,
+		Workers:  2,
+		APIKeys:  []string{"apiKey1"},
+		Progress: progress,
+	}
+
+	result, err := ControlFunc(context.Background(), mockController, crawlerConfig, originalUserSteamID)
+	assert.Nil(t, err)
+	close(progress)
+
+	kinds := make([]EventKind, 0)
+	var lastEvent ProgressEvent
+	for event := range progress {
+		kinds = append(kinds, event.Kind)
+		lastEvent = event
+	}
+	assert.Equal(t, []EventKind{EventUserFetched, EventLevelFinished, EventUserFetched, EventUserFetched, EventLevelFinished, EventCrawlFinished}, kinds)
+	assert.Equal(t, 3, lastEvent.UsersCrawled)
+	assert.Equal(t, 0, lastEvent.Frontier)
+	assert.Equal(t, originalUserSteamID, lastEvent.CrawlSteamID)
+
+	assert.Equal(t, map[int]int{1: 1, 2: 2}, result.Stats.CrawledPerLevel)
+	assert.Equal(t, 3, result.Stats.CacheMisses)
+	assert.Equal(t, 3, result.Stats.FetchLatency.Count)
+	assert.Equal(t, result.Stats.APICalls, result.Stats.APICallsPerKey["****Key1"])
+
+	os.RemoveAll(configuration.AppConfig.LogsFolderLocation)
+}
+
+func TestNewLatencyStats(t *testing.T) {
+	latencies := make([]time.Duration, 0)
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	stats := newLatencyStats(latencies)
+
+	assert.Equal(t, LatencyStats{Count: 100, P50: 50 * time.Millisecond, P90: 90 * time.Millisecond,
+		P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}, stats)
+	assert.Equal(t, LatencyStats{}, newLatencyStats(nil))
+}
+
+func TestSaveAndLoadCrawlStats(t *testing.T) {
+	os.Mkdir(configuration.AppConfig.FinishedGraphsLocation, 0755)
+	defer os.Remove(statsFileName("testGraphID"))
+	stats := []CrawlStats{{SteamID: "76561198000000001", CacheHits: 3, CacheHitRatio: 0.75, FriendsPerLevel: map[int]int{1: 1}}}
+
+	err := SaveCrawlStats("testGraphID", stats)
+	assert.Nil(t, err)
+	loadedStats, err := LoadCrawlStats("testGraphID")
+	assert.Nil(t, err)
+
+	assert.Equal(t, stats, loadedStats)
+}
+
+func TestGetRefreshDetailsOfGraphsSavedWithoutStats(t *testing.T) {
+	os.Mkdir(configuration.AppConfig.FinishedGraphsLocation, 0755)
+	configuration.AppConfig.UrlMap["76561198000000001,76561198000000002"] = "legacyGraphID"
+	defer delete(configuration.AppConfig.UrlMap, "76561198000000001,76561198000000002")
+	defer os.Remove(statsFileName("legacyGraphID"))
+
+	steamIDs, level, err := GetRefreshDetails("legacyGraphID")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"76561198000000001", "76561198000000002"}, steamIDs)
+	assert.Equal(t, DefaultLevel, level)
+
+	assert.Nil(t, SaveCrawlStats("legacyGraphID", []CrawlStats{{LevelCap: 3}}))
+	_, level, err = GetRefreshDetails("legacyGraphID")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, level)
+}
+
+func TestProfileStoreSummarisesEachAccountOnce(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	batchSizes := make([]int, 0)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), "apiKey1").Return(util.UserStatsStruct{
+		Response: util.Response{Players: []util.Player{{Steamid: "76561198000000000", Personaname: "first"}}},
+	}, nil).Run(func(args mock.Arguments) {
+		batchSizes = append(batchSizes, len(strings.Split(args.String(1), ",")))
+	})
+
+	steamIDs := make([]string, 0)
+	for i := 0; i < 250; i++ {
+		steamIDs = append(steamIDs, fmt.Sprintf("76561198000000%03d", i))
+	}
+	store := NewProfileStore()
+
+	players, err := store.Lookup(context.Background(), mockController, "apiKey1", steamIDs)
+	assert.Nil(t, err)
+	assert.Len(t, players, 250)
+	assert.Equal(t, "first", players["76561198000000000"].Personaname)
+	assert.Equal(t, []int{100, 100, 50}, batchSizes)
+
+	// Every account has been summarised so no more calls are made
+	_, err = store.Lookup(context.Background(), mockController, "apiKey1", steamIDs[:10])
+	assert.Nil(t, err)
+	assert.Len(t, batchSizes, 3)
+	assert.Equal(t, 250, store.Summarised())
+	assert.Equal(t, 10, store.LookupsAvoided())
+}
+
+func TestProfileStoreLetsAnotherWorkerRetryAFailedBatch(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), "badKey").Return(util.UserStatsStruct{}, util.ErrInvalidKey)
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), "goodKey").Return(util.UserStatsStruct{}, nil)
+	store := NewProfileStore()
+
+	_, err := store.Lookup(context.Background(), mockController, "badKey", []string{"76561198000000001"})
+	assert.True(t, errors.Is(err, util.ErrInvalidKey))
+
+	players, err := store.Lookup(context.Background(), mockController, "goodKey", []string{"76561198000000001"})
+	assert.Nil(t, err)
+	assert.Equal(t, "76561198000000001", players["76561198000000001"].Steamid)
+	mockController.AssertNumberOfCalls(t, "CallPlayerSummaryAPI", 2)
+}
+
+func TestProfileStoreStopsWaitingWhenCancelled(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	mockController.On("CallPlayerSummaryAPI", mock.Anything, mock.AnythingOfType("string"), "apiKey1").Return(util.UserStatsStruct{}, nil).Run(func(args mock.Arguments) {
+		close(inFlight)
+		<-release
+	})
+	store := NewProfileStore()
+	defer close(release)
+
+	go store.Lookup(context.Background(), mockController, "apiKey1", []string{"76561198000000001"})
+	<-inFlight
+	ctx, cancel := context.WithCancel(context.Background())
+	lookupErr := make(chan error)
+	go func() {
+		_, err := store.Lookup(ctx, mockController, "apiKey2", []string{"76561198000000001"})
+		lookupErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-lookupErr:
+		assert.True(t, errors.Is(err, context.Canceled))
+	case <-time.After(time.Second):
+		t.Fatal("Lookup kept waiting after its context was cancelled")
+	}
+}
+
+func TestFinalProgressEventIsNeverDropped(t *testing.T) {
+	progress := make(chan ProgressEvent, 1)
+
+	publishProgress(progress, ProgressEvent{Kind: EventUserFetched})
+	// The channel is full so this one is dropped
+	publishProgress(progress, ProgressEvent{Kind: EventCacheHit})
+	go publishFinalProgress(progress, ProgressEvent{Kind: EventCrawlFinished})
+
+	assert.Equal(t, EventUserFetched, (<-progress).Kind)
+	assert.Equal(t, EventCrawlFinished, (<-progress).Kind)
+}
+
+func TestFormatProgressBar(t *testing.T) {
+	event := ProgressEvent{CrawlSteamID: "76561198000000001", UsersCrawled: 1, Frontier: 3, APICalls: 2, ETA: 90 * time.Second}
+
+	assert.Equal(t, "76561198000000001 [##------] 1/4 users | 2 API calls | ETA 1m30s", FormatProgressBar(event, 8))
+}
+
+func TestExceededBudget(t *testing.T) {
+	cfg := CrawlerConfig{MaxUsers: 10, MaxAPICalls: 100}
+
+	assert.Equal(t, "", cfg.exceededBudget(9, 99))
+	assert.Equal(t, "the max users budget of 10 was reached", cfg.exceededBudget(10, 0))
+	assert.Equal(t, "the max API calls budget of 100 was reached", cfg.exceededBudget(0, 100))
+	assert.Equal(t, "", CrawlerConfig{}.exceededBudget(1000, 1000))
+}
+
+func TestCanDispatch(t *testing.T) {
+	cfg := CrawlerConfig{MaxUsers: 10}
+
+	assert.True(t, cfg.canDispatch(5, 4))
+	assert.False(t, cfg.canDispatch(5, 5))
+	assert.True(t, CrawlerConfig{}.canDispatch(1000, 1000))
+}
+
+func TestCrawlBudgetAddsUpEveryCrawl(t *testing.T) {
+	budget := NewCrawlBudget()
+	budget.spend(3, 10)
+	budget.spend(2, 5)
+
+	usersCrawled, apiCalls := budget.used()
+	assert.Equal(t, 5, usersCrawled)
+	assert.Equal(t, 15, apiCalls)
+	assert.True(t, budget.timeLeft(time.Hour) > 59*time.Minute)
+}
+
+func TestSamplingFollowsLongestStandingFriends(t *testing.T) {
+	friends := []util.Friend{
+		{Steamid: "76561197960287930", FriendSince: 300},
+		{Steamid: "76561197960287931", FriendSince: 100},
+		{Steamid: "76561197960287932", FriendSince: 200},
+	}
+	policy := SamplingPolicy{TopN: 2, By: SampleByFriendSince}
+
+	followed, skipped := policy.sample(&util.MockControllerInterface{}, "76561197960287929", friends)
+
+	assert.Equal(t, []util.Friend{friends[1], friends[2]}, followed)
+	assert.Equal(t, []util.Friend{friends[0]}, skipped)
+}
+
+func TestRandomSamplingIsReproducible(t *testing.T) {
+	friends := make([]util.Friend, 0)
+	for i := 0; i < 50; i++ {
+		friends = append(friends, util.Friend{Steamid: fmt.Sprintf("765611979602879%02d", i)})
+	}
+	policy := SamplingPolicy{TopN: 5, By: SampleByRandom, Seed: 42}
+
+	followed, skipped := policy.sample(&util.MockControllerInterface{}, "76561197960287929", friends)
+	// The order friends are given in shouldn't change which are picked
+	rand.Shuffle(len(friends), func(i, j int) { friends[i], friends[j] = friends[j], friends[i] })
+	followedAgain, _ := policy.sample(&util.MockControllerInterface{}, "76561197960287929", friends)
+
+	assert.Len(t, followed, 5)
+	assert.Len(t, skipped, 45)
+	assert.Equal(t, followed, followedAgain)
+}
+
+func TestSamplingFollowsEveryFriendWithoutTopN(t *testing.T) {
+	friends := []util.Friend{{Steamid: "76561197960287930"}, {Steamid: "76561197960287931"}}
+
+	followed, skipped := SamplingPolicy{}.sample(&util.MockControllerInterface{}, "76561197960287929", friends)
+
+	assert.Equal(t, friends, followed)
+	assert.Empty(t, skipped)
+}
+
+func TestParseSampleBy(t *testing.T) {
+	sampleBy, err := ParseSampleBy("mutual")
+	assert.Nil(t, err)
+	assert.Equal(t, SampleByMutual, sampleBy)
+
+	_, err = ParseSampleBy("popularity")
+	assert.NotNil(t, err)
+}
+
+func TestParseGamesSource(t *testing.T) {
+	for _, source := range []GamesSource{"", GamesOwned, GamesRecent} {
+		parsed, err := ParseGamesSource(string(source))
+		assert.Nil(t, err)
+		assert.Equal(t, source, parsed)
+	}
+
+	_, err := ParseGamesSource("wishlist")
+	assert.NotNil(t, err)
+}
+
+func TestGamesInCommonOfAUserCoversTheirFriends(t *testing.T) {
+	appConfig := configuration.AppConfig
+	defer configuration.SetConfig(appConfig)
+	configuration.AppConfig.CacheFolderLocation = t.TempDir()
+
+	cntr := util.Controller{}
+	withGames := func(username string, appIDs ...int) util.FriendsStruct {
+		friendsObj := util.FriendsStruct{Username: username, Games: &util.UserGames{Source: string(GamesOwned)}}
+		for _, appID := range appIDs {
+			friendsObj.Games.Games = append(friendsObj.Games.Games, util.OwnedGame{AppID: appID, PlaytimeForever: 60})
+		}
+		return friendsObj
+	}
+	seed := withGames("moose", 730, 570)
+	seed.FriendsList.Friends = []util.Friend{
+		{Steamid: "76561198130544932"},
+		{Steamid: "76561198090461077"},
+		{Steamid: "76561198030000000"},
+	}
+	assert.Nil(t, WriteToFile(cntr, "", "76561198282036055", seed))
+	assert.Nil(t, WriteToFile(cntr, "", "76561198130544932", withGames("Joe", 730, 570, 440)))
+	assert.Nil(t, WriteToFile(cntr, "", "76561198090461077", withGames("Michael", 730, 440)))
+	hidden := withGames("Johnny")
+	hidden.Games.Private = true
+	assert.Nil(t, WriteToFile(cntr, "", "76561198030000000", hidden))
+
+	report, err := GamesInCommon(cntr, "76561198282036055")
+	assert.Nil(t, err)
+	assert.Equal(t, GamesReport{
+		Users:     4,
+		WithGames: 3,
+		Private:   1,
+		Games: []SharedGame{
+			{AppID: 730, Owners: 3, Playtime: 180},
+			{AppID: 440, Owners: 2, Playtime: 120},
+			{AppID: 570, Owners: 2, Playtime: 120},
+		},
+	}, report)
+
+	_, err = GamesInCommon(cntr, "not an ID")
+	assert.NotNil(t, err)
+}
+
+func TestWorkerReturnsWhenJobsQueueIsClosed(t *testing.T) {
+	mockController := &util.MockControllerInterface{}
+	workerConfig, err := InitWorkerConfig(2, 1)
+	assert.Nil(t, err)
+
+	jobs := make(chan JobsStruct)
+	results := make(chan jobResult)
+	close(jobs)
+
+	workerConfig.Wg.Add(1)
+	Worker(context.Background(), mockController, jobs, results, workerConfig)
+	workerConfig.Wg.Wait()
+}
+
+func TestGraphIdentifierIgnoresSeedOrder(t *testing.T) {
+	identifier, err := GraphIdentifier([]string{"76561198090461077", "76561197960287930", "76561198030000000"})
+	assert.Nil(t, err)
+	assert.Equal(t, "76561197960287930,76561198030000000,76561198090461077", identifier)
+
+	reordered, err := GraphIdentifier([]string{"76561198030000000", "76561198090461077", "76561197960287930", "76561198030000000"})
+	assert.Nil(t, err)
+	assert.Equal(t, identifier, reordered)
+
+	identifier, err = GraphIdentifier([]string{"76561198090461077", "76561198090461077"})
+	assert.Nil(t, err)
+	assert.Equal(t, "76561198090461077", identifier)
+
+	_, err = GraphIdentifier([]string{"76561198090461077", "notASteamID"})
+	assert.NotNil(t, err)
+}
+
 func TestIsEnvVarSetWithValidEnvVar(t *testing.T) {
 	os.Setenv("examplevariable", "thisIsSet")
 	exists := IsEnvVarSet("examplevariable")